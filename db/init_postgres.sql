@@ -6,7 +6,10 @@ periodically transferred over to Snowflake.
 create or replace function update_modified_column()
 returns trigger as $$
 begin
-    new.modified = (now() at time zone 'utc');
+    -- Soft deleting or restoring a test is not an enrichment
+    if new.deleted is not distinct from old.deleted then
+        new.modified = (now() at time zone 'utc');
+    end if;
     return new;
 end;
 $$ language 'plpgsql';
@@ -22,6 +25,9 @@ create table if not exists oar_tests
     created     timestamp not null default (now() at time zone 'utc'),
     modified    timestamp not null default (now() at time zone 'utc'),
     doc         jsonb,
+    deleted     timestamp,
+    definition_id bigint,
+    run_id      bigint,
     constraint analysis
         check (analysis in ('NotAnalyzed', 'TruePositive', 'FalsePositive', 'TrueNegative', 'FalseNegative')),
     constraint outcome
@@ -30,11 +36,151 @@ create table if not exists oar_tests
         check (resolution in ('Unresolved', 'NotNeeded', 'TicketCreated', 'QuickFix', 'KnownIssue', 'TestFixed', 'TestDisabled'))
 );
 
+-- Adds the deleted column to tables that were created before soft deletes
+alter table oar_tests add column if not exists deleted timestamp;
+
+-- Test definitions are the tests that results are reported for. Each result links to its definition by a fingerprint
+-- of its identity fields, which are configured in the OAR service, like the summary and doc.nodeid.
+create table if not exists oar_test_definitions
+(
+    id          bigserial   constraint definition_id primary key,
+    fingerprint char(64)    not null constraint definition_fingerprint unique,
+    summary     text        not null,
+    identity    jsonb       not null,
+    created     timestamp not null default (now() at time zone 'utc'),
+    last_seen   timestamp not null default (now() at time zone 'utc')
+);
+
+-- Adds the definition_id column to tables that were created before test definitions. Results that were reported
+-- before are linked to their definition the next time they are updated.
+alter table oar_tests add column if not exists definition_id bigint;
+
+do $$
+begin
+    if not exists (select from pg_constraint where conname = 'definition') then
+        alter table oar_tests add constraint definition
+            foreign key (definition_id) references oar_test_definitions (id);
+    end if;
+end;
+$$;
+
+create index if not exists oar_tests_definition on oar_tests (definition_id, created);
+
+-- Runs group the results of a single execution of a test suite, like a CI build. Results are attached to a run when
+-- they are created, until the run is finished.
+create table if not exists oar_runs
+(
+    id          bigserial   constraint run_id primary key,
+    name        text        not null,
+    branch      text        not null default '',
+    commit      text        not null default '',
+    environment text        not null default '',
+    doc         jsonb,
+    started     timestamp not null default (now() at time zone 'utc'),
+    finished    timestamp
+);
+
+create index if not exists oar_runs_started on oar_runs (started);
+
+-- Adds the run_id column to tables that were created before runs
+alter table oar_tests add column if not exists run_id bigint;
+
+do $$
+begin
+    if not exists (select from pg_constraint where conname = 'run') then
+        alter table oar_tests add constraint run foreign key (run_id) references oar_runs (id);
+    end if;
+end;
+$$;
+
+create index if not exists oar_tests_run on oar_tests (run_id, definition_id);
+
 -- Will add the trigger that updates the modified column automatically on every update.
 create or replace trigger update_modified
 before update on oar_tests
 for each row execute procedure update_modified_column();
 
+-- Full-text search index over the summary and every string value in the doc. The expression must stay the same as
+-- testSearchVector in the oar-service, or searches will not use the index.
+create index if not exists oar_tests_search on oar_tests using gin ((
+    setweight(to_tsvector('english', summary), 'A') ||
+    setweight(jsonb_to_tsvector('english', coalesce(doc, '{}'), '["string"]'), 'B')
+));
+
+-- Lets the purge of soft deleted tests find them without scanning the table
+create index if not exists oar_tests_deleted on oar_tests (deleted) where deleted is not null;
+
+-- Every change to a test is recorded, so enrichments can be attributed and audited. The OAR service sets the
+-- transaction-local "oar.actor" and "oar.request_id" settings before it writes, other writers are recorded without them.
+create table if not exists oar_test_history
+(
+    id          bigserial   constraint history_id primary key,
+    test_id     bigint      not null,
+    operation   varchar(7)  not null,
+    before      jsonb,
+    after       jsonb,
+    actor       text,
+    request_id  text,
+    timestamp   timestamp not null default (now() at time zone 'utc'),
+    constraint operation
+        check (operation in ('INSERT', 'UPDATE', 'DELETE', 'RESTORE', 'PURGE'))
+);
+
+create index if not exists oar_test_history_test on oar_test_history (test_id, id);
+create index if not exists oar_test_history_actor on oar_test_history (actor, timestamp);
+
+create or replace function record_test_history()
+returns trigger as $$
+declare
+    operation varchar(7);
+begin
+    if tg_op = 'INSERT' then
+        operation = 'INSERT';
+    elsif tg_op = 'DELETE' then
+        operation = 'PURGE';
+    elsif old.deleted is null and new.deleted is not null then
+        operation = 'DELETE';
+    elsif old.deleted is not null and new.deleted is null then
+        operation = 'RESTORE';
+    else
+        operation = 'UPDATE';
+    end if;
+
+    insert into oar_test_history (test_id, operation, before, after, actor, request_id)
+    values (
+        case when tg_op = 'DELETE' then old.id else new.id end,
+        operation,
+        case when tg_op = 'INSERT' then null else to_jsonb(old) end,
+        case when tg_op = 'DELETE' then null else to_jsonb(new) end,
+        nullif(current_setting('oar.actor', true), ''),
+        nullif(current_setting('oar.request_id', true), '')
+    );
+    return null;
+end;
+$$ language 'plpgsql';
+comment on function record_test_history() is 'Records every insert, update and delete of "oar_tests" in "oar_test_history"';
+
+-- Will add the trigger that records the history of a test after every change.
+create or replace trigger record_history
+after insert or update or delete on oar_tests
+for each row execute procedure record_test_history();
+
+-- API keys authenticate callers of the OAR service. Only a hash of each key is stored, the key itself is shown once
+-- when it is created.
+create table if not exists oar_api_keys
+(
+    id          bigserial   constraint api_key_id primary key,
+    name        text        not null,
+    prefix      varchar(12) not null,
+    hash        char(64)    not null constraint api_key_hash unique,
+    scopes      text[]      not null,
+    created     timestamp not null default (now() at time zone 'utc'),
+    last_used   timestamp,
+    revoked     timestamp,
+    constraint scopes
+        check (scopes <@ array ['ingest', 'enrich', 'read', 'admin'] and cardinality(scopes) > 0)
+);
+
 comment on table oar_tests
     is 'tests is the core test ledger where results will be stored. Contains both structured test data and unstructured data that will be stored in BJSON';
 
@@ -62,6 +208,9 @@ comment on column oar_tests.modified
 comment on column oar_tests.doc
     is 'Unstructured document for any additional test result data';
 
+comment on column oar_tests.deleted
+    is 'UTC timestamp of when the test result was soft deleted, null if it is not deleted. Deleted test results are purged after a retention period.';
+
 comment on constraint analysis on oar_tests
     is 'Ensures that the analysis is a valid analysis option';
 
@@ -70,3 +219,82 @@ comment on constraint outcome on oar_tests
 
 comment on constraint resolution on oar_tests
     is 'Ensures that a resolution is a valid value';
+
+comment on column oar_tests.definition_id
+    is 'The test definition that the test result is a result of, null if the result has not been linked to one';
+
+comment on constraint definition on oar_tests
+    is 'Ensures that a test result links to an existing test definition';
+
+comment on column oar_tests.run_id
+    is 'The run that the test result was reported in, null if it was not reported in a run';
+
+comment on constraint run on oar_tests
+    is 'Ensures that a test result is attached to an existing run';
+
+comment on index oar_tests_search
+    is 'Full-text search index over the summary (weighted highest) and every string value in the doc';
+
+
+comment on table oar_test_history
+    is 'Audit log of every change to a test result. Entries are kept after the test result is purged';
+
+comment on column oar_test_history.operation
+    is 'INSERT, UPDATE, DELETE (soft delete), RESTORE or PURGE (permanent delete)';
+
+comment on column oar_test_history.before
+    is 'The test result row before the change, null for an INSERT';
+
+comment on column oar_test_history.after
+    is 'The test result row after the change, null for a PURGE';
+
+comment on column oar_test_history.actor
+    is 'Who made the change, if it is known';
+
+comment on column oar_test_history.request_id
+    is 'ID of the OAR service request that made the change, if it is known';
+
+comment on table oar_test_definitions
+    is 'Tests that results are reported for. A test result is a single execution of a test definition';
+
+comment on column oar_test_definitions.fingerprint
+    is 'Hex encoded SHA-256 hash of the identity, results with the same identity fields have the same fingerprint';
+
+comment on column oar_test_definitions.summary
+    is 'Summary of the first result of the test definition';
+
+comment on column oar_test_definitions.identity
+    is 'Value of each identity field of the test definition, by field, like {"summary": "login works", "doc.nodeid": "test_login"}';
+
+comment on column oar_test_definitions.last_seen
+    is 'UTC timestamp of when a result was last linked to the test definition';
+
+comment on table oar_runs
+    is 'Runs group the results of a single execution of a test suite, like a CI build';
+
+comment on column oar_runs.name
+    is 'Name of the run, like "CI build #1234"';
+
+comment on column oar_runs.doc
+    is 'Unstructured document for any additional run metadata';
+
+comment on column oar_runs.started
+    is 'UTC timestamp of when the run was created';
+
+comment on column oar_runs.finished
+    is 'UTC timestamp of when the run was finished, null while results can still be attached to it';
+
+comment on table oar_api_keys
+    is 'API keys that can call the OAR service, each with the scopes of the endpoints it can call';
+
+comment on column oar_api_keys.prefix
+    is 'Start of the API key, so it can be recognized without storing the key';
+
+comment on column oar_api_keys.hash
+    is 'Hex encoded SHA-256 hash of the API key';
+
+comment on column oar_api_keys.scopes
+    is 'Any of "ingest" (create test results), "enrich" (patch), "read" (query) and "admin" (everything, including deletes and API keys)';
+
+comment on column oar_api_keys.revoked
+    is 'UTC timestamp of when the API key was revoked, null if it can still be used';
@@ -7,6 +7,9 @@ export type Test = {
 	outcome: Outcome | string;
 	analysis: Analysis | string;
 	resolution: Resolution | string;
+	deleted?: string;
+	definitionId?: number;
+	runId?: number;
 	[x: string]: unknown; // Allows for arbitrary properties
 };
 
@@ -24,6 +27,9 @@ export type TestQuery = {
 	modifiedBefore?: Date;
 	modifiedAfter?: Date;
 	docs?: object[];
+	includeDeleted?: boolean;
+	definitionIds?: number[];
+	runIds?: number[];
 };
 
 /*
@@ -41,6 +47,9 @@ TestQueryResult is the test results and metadata associated with a test query.
  */
 export type TestQueryResult = {
 	count: number;
+	total: number;
+	totalEstimated: boolean;
+	nextCursor?: string;
 	tests: Test[];
 };
 
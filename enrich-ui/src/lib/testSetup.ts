@@ -35,7 +35,12 @@ export const restHandlers = [
 	}),
 
 	rest.get(testOARBaseURL + '/tests', (req, res, ctx) => {
-		const testQueryResult = { count: 1, tests: [selectRandomItem(fakeTests)] };
+		const testQueryResult = {
+			count: 1,
+			total: 1,
+			totalEstimated: false,
+			tests: [selectRandomItem(fakeTests)]
+		};
 		return res(ctx.status(200), ctx.json(testQueryResult));
 	}),
 
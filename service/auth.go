@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx"
+	"golang.org/x/exp/slices"
+	"net/http"
+	"strings"
+)
+
+const (
+	// APIKeyHeader is the header that an API key can be passed in, it can also be passed as a Bearer token
+	APIKeyHeader = "X-API-Key"
+	// ScopesKey is the gin context key of the scopes of the caller of a request
+	ScopesKey = "scopes"
+	// apiKeyPrefix is the start of every API key, so they are easy to recognize
+	apiKeyPrefix = "oar_"
+	// apiKeyPrefixLength is how much of an API key is kept in the clear, to tell API keys apart
+	apiKeyPrefixLength = 12
+)
+
+// NewAPIKey will generate a new random API key. Returns the API key and its prefix, which can be stored.
+func NewAPIKey() (string, string, error) {
+	randomKey := make([]byte, 32)
+	if _, err := rand.Read(randomKey); err != nil {
+		return "", "", err
+	}
+
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(randomKey)
+	return key, key[:apiKeyPrefixLength], nil
+}
+
+// HashAPIKey will return the hex encoded SHA-256 hash of an API key, which is what is stored instead of the key. API
+// keys are long and random, so a fast hash is enough to keep them safe.
+func HashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// A Principal is an authenticated caller. The Actor is who they are, which is recorded in the history of every change
+// they make, and the Scopes are what they can do.
+type Principal struct {
+	Actor  string
+	Scopes []Scope
+}
+
+// HasScope will return true if the principal has the scope, or has the admin scope, which includes every other scope
+func (p *Principal) HasScope(scope Scope) bool {
+	return slices.Contains(p.Scopes, scope) || slices.Contains(p.Scopes, ScopeAdmin)
+}
+
+// errUnauthenticated is the error of a request without valid credentials
+var errUnauthenticated = fmt.Errorf(
+	"missing or invalid credentials, pass an API key in the %s header or a Bearer token",
+	APIKeyHeader,
+)
+
+// Authenticator authenticates the callers of the OAR service with API keys, or with bearer JWTs if JWT is set. See
+// AuthConfig.
+type Authenticator struct {
+	DBPool *pgx.ConnPool
+	Config *AuthConfig
+	JWT    *JWTVerifier
+}
+
+// Require is a middleware that will only let callers with the scope through. The actor and scopes of the caller are
+// set on the gin context. If authentication is not enabled, every caller is let through.
+// Require will respond with a http.StatusUnauthorized (401) status code if there is no valid API key or JWT, or with a
+// http.StatusForbidden (403) status code if the caller does not have the scope.
+func (auth *Authenticator) Require(scope Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if auth.Config == nil || !auth.Config.Enabled {
+			c.Next()
+			return
+		}
+
+		principal, err := auth.authenticate(c)
+		if errors.Is(err, errUnauthenticated) {
+			c.Header("WWW-Authenticate", `Bearer realm="oar"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ConvertErrToGinH(err))
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, ConvertErrToGinH(err))
+			return
+		}
+		if !principal.HasScope(scope) {
+			c.AbortWithStatusJSON(
+				http.StatusForbidden,
+				ConvertErrToGinH(fmt.Errorf("%s does not have the %s scope", principal.Actor, scope)),
+			)
+			return
+		}
+
+		c.Set(ActorKey, principal.Actor)
+		c.Set(ScopesKey, principal.Scopes)
+		c.Next()
+	}
+}
+
+// authenticate will return the Principal of the API key or JWT of a request. Will return an error that wraps
+// errUnauthenticated if there are no credentials or they are not valid. A bearer token is verified as a JWT if it is
+// not an API key, otherwise the static admin key is checked first, then the API keys in the database.
+func (auth *Authenticator) authenticate(c *gin.Context) (*Principal, error) {
+	key := c.GetHeader(APIKeyHeader)
+	if key == "" {
+		key = bearerToken(c)
+		if auth.JWT != nil && strings.Count(key, ".") == 2 {
+			return auth.authenticateJWT(key)
+		}
+	}
+	if key == "" {
+		return nil, errUnauthenticated
+	}
+
+	hash := HashAPIKey(key)
+	if auth.Config.AdminKey != "" &&
+		subtle.ConstantTimeCompare([]byte(hash), []byte(HashAPIKey(auth.Config.AdminKey))) == 1 {
+		return &Principal{Actor: "admin", Scopes: []Scope{ScopeAdmin}}, nil
+	}
+
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, errUnauthenticated
+	}
+	if auth.DBPool == nil {
+		return nil, errors.New("API keys cannot be checked without a database")
+	}
+	apiKey, err := AuthenticateAPIKey(auth.DBPool, hash)
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == nil {
+		return nil, errUnauthenticated
+	}
+
+	return &Principal{Actor: "apikey:" + apiKey.Name, Scopes: apiKey.Scopes}, nil
+}
+
+// authenticateJWT will return the Principal of a bearer JWT. The user in the JWT is the actor of the request, so
+// their changes are attributed to them in the test history.
+func (auth *Authenticator) authenticateJWT(token string) (*Principal, error) {
+	claims, err := auth.JWT.Verify(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errUnauthenticated, err)
+	}
+
+	principal, err := auth.JWT.Principal(claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errUnauthenticated, err)
+	}
+	return principal, nil
+}
+
+// bearerToken will return the token of a "Bearer" Authorization header, or an empty string if there is none
+func bearerToken(c *gin.Context) string {
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/magiconair/properties/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestNewAPIKey will ensure that generated API keys are random, recognizable and hashed consistently
+func TestNewAPIKey(t *testing.T) {
+	key, prefix, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, strings.HasPrefix(key, apiKeyPrefix), true)
+	assert.Equal(t, strings.HasPrefix(key, prefix), true)
+	assert.Equal(t, len(prefix), apiKeyPrefixLength)
+	assert.Equal(t, key != otherKey, true)
+	assert.Equal(t, len(HashAPIKey(key)), 64)
+	assert.Equal(t, HashAPIKey(key), HashAPIKey(key))
+	assert.Equal(t, HashAPIKey(key) != HashAPIKey(otherKey), true)
+}
+
+// TestPrincipal_HasScope will ensure that the admin scope includes every other scope
+func TestPrincipal_HasScope(t *testing.T) {
+	ingest := &Principal{Actor: "ci", Scopes: []Scope{ScopeIngest}}
+	assert.Equal(t, ingest.HasScope(ScopeIngest), true)
+	assert.Equal(t, ingest.HasScope(ScopeRead), false)
+	assert.Equal(t, ingest.HasScope(ScopeAdmin), false)
+
+	admin := &Principal{Actor: "admin", Scopes: []Scope{ScopeAdmin}}
+	for _, scope := range []Scope{ScopeIngest, ScopeEnrich, ScopeRead, ScopeAdmin} {
+		assert.Equal(t, admin.HasScope(scope), true)
+	}
+}
+
+// requireScope will run the Require middleware of an Authenticator for a request with headers. Returns the gin
+// context and the response.
+func requireScope(
+	auth *Authenticator,
+	scope Scope,
+	headers map[string]string,
+) (*gin.Context, *httptest.ResponseRecorder) {
+	c, w := Fake.ginContext()
+	c.Request = httptest.NewRequest(http.MethodGet, "/tests", nil)
+	for header, value := range headers {
+		c.Request.Header.Set(header, value)
+	}
+
+	auth.Require(scope)(c)
+	return c, w
+}
+
+// TestAuthenticator_Require will ensure that only callers with the scope are let through when authentication is
+// enabled
+func TestAuthenticator_Require(t *testing.T) {
+	adminKey := "static-admin-key"
+	auth := &Authenticator{Config: &AuthConfig{Enabled: true, AdminKey: adminKey}}
+
+	t.Run("disabled lets everyone through", func(t *testing.T) {
+		c, _ := requireScope(&Authenticator{Config: &AuthConfig{}}, ScopeAdmin, nil)
+		assert.Equal(t, c.IsAborted(), false)
+	})
+
+	t.Run("missing API key", func(t *testing.T) {
+		c, w := requireScope(auth, ScopeRead, nil)
+		assert.Equal(t, c.IsAborted(), true)
+		assert.Equal(t, w.Code, 401)
+		assert.Equal(t, w.Header().Get("WWW-Authenticate"), `Bearer realm="oar"`)
+	})
+
+	t.Run("invalid API key", func(t *testing.T) {
+		c, w := requireScope(auth, ScopeRead, map[string]string{APIKeyHeader: "not-the-admin-key"})
+		assert.Equal(t, c.IsAborted(), true)
+		assert.Equal(t, w.Code, 401)
+	})
+
+	t.Run("admin key in the header", func(t *testing.T) {
+		c, _ := requireScope(auth, ScopeAdmin, map[string]string{APIKeyHeader: adminKey})
+		assert.Equal(t, c.IsAborted(), false)
+		assert.Equal(t, c.GetString(ActorKey), "admin")
+	})
+
+	t.Run("admin key as a bearer token", func(t *testing.T) {
+		c, _ := requireScope(auth, ScopeIngest, map[string]string{"Authorization": "Bearer " + adminKey})
+		assert.Equal(t, c.IsAborted(), false)
+	})
+
+	t.Run("API keys from the database", func(t *testing.T) {
+		auth := &Authenticator{DBPool: Fake.pgPool(), Config: &AuthConfig{Enabled: true}}
+		key, prefix, err := NewAPIKey()
+		if err != nil {
+			t.Fatal("setup error", err)
+		}
+		apiKey := &APIKey{Name: "ci runner", Prefix: prefix, Scopes: []Scope{ScopeIngest}}
+		if err = InsertAPIKey(auth.DBPool, apiKey, HashAPIKey(key)); err != nil {
+			t.Fatal("setup error", err)
+		}
+
+		c, _ := requireScope(auth, ScopeIngest, map[string]string{APIKeyHeader: key})
+		assert.Equal(t, c.IsAborted(), false)
+		assert.Equal(t, c.GetString(ActorKey), "apikey:ci runner")
+
+		c, w := requireScope(auth, ScopeRead, map[string]string{APIKeyHeader: key})
+		assert.Equal(t, c.IsAborted(), true)
+		assert.Equal(t, w.Code, 403)
+
+		if _, err = RevokeAPIKey(auth.DBPool, apiKey.ID); err != nil {
+			t.Fatal("setup error", err)
+		}
+		c, w = requireScope(auth, ScopeIngest, map[string]string{APIKeyHeader: key})
+		assert.Equal(t, c.IsAborted(), true)
+		assert.Equal(t, w.Code, 401)
+	})
+}
@@ -1,14 +1,113 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"github.com/jackc/pgx"
 	"github.com/spf13/viper"
+	"golang.org/x/exp/slices"
 	"log"
+	"net/http"
 	"strings"
+	"time"
 )
 
 type Config struct {
-	PG *PGConfig
+	PG          *PGConfig
+	Delete      *DeleteConfig
+	Auth        *AuthConfig
+	CORS        *CORSConfig
+	Definitions *DefinitionConfig
+}
+
+// DeleteConfig configures deletes of tests. Deleted tests are soft deleted, then purged once they have been deleted
+// for longer than the Retention.
+type DeleteConfig struct {
+	// Max tests a delete can affect without being confirmed, 0 for no max
+	MaxRows uint64 `mapstructure:"MAX_ROWS"`
+	// How long deleted tests are kept before they are purged, 0 to keep them forever
+	Retention time.Duration `mapstructure:"RETENTION"`
+	// How often deleted tests past the Retention are purged
+	PurgeInterval time.Duration `mapstructure:"PURGE_INTERVAL"`
+}
+
+// AuthConfig configures authentication. When it is enabled, every endpoint except /health can only be called with an
+// API key that has the scope of the endpoint. See Authenticator.
+type AuthConfig struct {
+	// Whether callers must authenticate
+	Enabled bool `mapstructure:"ENABLED"`
+	// A static API key with the admin scope, that can create the first API keys. Empty for none
+	AdminKey string `mapstructure:"ADMIN_KEY"`
+	// Bearer JWTs from an OIDC identity provider, that can be used instead of API keys
+	JWT *JWTConfig `mapstructure:"JWT"`
+}
+
+// JWTConfig configures the verification of bearer JWTs from an OIDC identity provider, see JWTVerifier. JWTs are only
+// accepted if the JWKS is set.
+type JWTConfig struct {
+	// The "iss" claim that every JWT must have
+	Issuer string `mapstructure:"ISSUER"`
+	// The "aud" claim that every JWT must have, usually the client ID of the enrich UI
+	Audience string `mapstructure:"AUDIENCE"`
+	// URL or local file path of the JSON Web Key Set with the public keys of the identity provider
+	JWKS string `mapstructure:"JWKS"`
+	// How often the keys of a JWKS URL are fetched again
+	JWKSRefresh time.Duration `mapstructure:"JWKS_REFRESH"`
+	// Claim that identifies the user as the actor of their changes, the subject is used if a JWT does not have it
+	ActorClaim string `mapstructure:"ACTOR_CLAIM"`
+	// Claim with the scopes of the user, as a space separated string or an array
+	ScopesClaim string `mapstructure:"SCOPES_CLAIM"`
+	// Scopes of users whose JWT does not have any OAR scopes in the ScopesClaim
+	DefaultScopes []string `mapstructure:"DEFAULT_SCOPES"`
+	// Allowed clock skew when checking the expiration of a JWT
+	Leeway time.Duration `mapstructure:"LEEWAY"`
+}
+
+// CORSConfig configures Cross-Origin Resource Sharing, so that browser apps on other origins, like the enrich UI, can
+// call the service. The policy applies to every route group, unless the group has its own policy in Groups. See CORS.
+type CORSConfig struct {
+	CORSPolicy `mapstructure:",squash"`
+	// Policies of the route groups (public, read, ingest, enrich, admin) that replace the default policy for that group
+	Groups map[string]*CORSPolicy `mapstructure:"GROUPS"`
+}
+
+// CORSPolicy is which cross-origin requests browsers are allowed to make
+type CORSPolicy struct {
+	// Origins that can call the service. An origin can have * wildcards, like https://*.example.com, or be * for any
+	AllowOrigins []string `mapstructure:"ALLOW_ORIGINS"`
+	// Methods that can be called
+	AllowMethods []string `mapstructure:"ALLOW_METHODS"`
+	// Request headers that can be sent
+	AllowHeaders []string `mapstructure:"ALLOW_HEADERS"`
+	// Response headers that can be read
+	ExposeHeaders []string `mapstructure:"EXPOSE_HEADERS"`
+	// Whether cookies and Authorization headers can be sent. Origins cannot be * when this is enabled
+	AllowCredentials bool `mapstructure:"ALLOW_CREDENTIALS"`
+	// How long browsers can cache a preflight response, 0 to not send a max age
+	MaxAge time.Duration `mapstructure:"MAX_AGE"`
+}
+
+// DefinitionConfig configures how test results are linked to their TestDefinition. Results with the same value for
+// every identity field are results of the same test. Changing the fields links new results to new definitions.
+type DefinitionConfig struct {
+	// Identity fields of a test, each is either summary or a path into the doc, like doc.nodeid or doc.package
+	Fields []string `mapstructure:"FIELDS"`
+}
+
+// Validate will return an error if the identity fields are not valid
+func (config *DefinitionConfig) Validate() error {
+	if len(config.Fields) == 0 {
+		return errors.New("test definitions need at least 1 identity field")
+	}
+	for i, field := range config.Fields {
+		if _, ok := parseDocPath(field); !ok && field != "summary" {
+			return fmt.Errorf("invalid identity field: '%s', must be summary or a path into the doc", field)
+		}
+		if slices.Contains(config.Fields[:i], field) {
+			return fmt.Errorf("identity field %s is repeated", field)
+		}
+	}
+	return nil
 }
 
 func NewConfig() (*Config, error) {
@@ -45,4 +144,43 @@ func SetDefaultConfigValues() {
 	viper.SetDefault("PG.LL", pgx.LogLevelInfo) // Postgres Log Level
 	viper.SetDefault("PG.POOL_SIZE", 4)         // Max number of pool connections
 	viper.SetDefault("PG.POLL_TIMEOUT", 30)     // Time to wait for a connection to be freed up
+
+	viper.SetDefault("DELETE.MAX_ROWS", 1000)       // Max tests a delete can affect without passing confirm=true
+	viper.SetDefault("DELETE.RETENTION", "720h")    // Deleted tests are purged after 30 days
+	viper.SetDefault("DELETE.PURGE_INTERVAL", "1h") // Checks for deleted tests to purge every hour
+
+	viper.SetDefault("AUTH.ENABLED", false) // Anyone that can reach the service can call it
+	viper.SetDefault("AUTH.ADMIN_KEY", "")  // No static admin API key
+
+	viper.SetDefault("AUTH.JWT.ISSUER", "")
+	viper.SetDefault("AUTH.JWT.AUDIENCE", "")
+	viper.SetDefault("AUTH.JWT.JWKS", "")                                   // JWTs are not accepted
+	viper.SetDefault("AUTH.JWT.JWKS_REFRESH", "1h")                         // Picks up rotated keys every hour
+	viper.SetDefault("AUTH.JWT.ACTOR_CLAIM", "email")                       // Attributes changes to the user's email
+	viper.SetDefault("AUTH.JWT.SCOPES_CLAIM", "scope")                      // Standard OAuth 2.0 scope claim
+	viper.SetDefault("AUTH.JWT.DEFAULT_SCOPES", []string{"read", "enrich"}) // Enrich UI users can query and enrich
+	viper.SetDefault("AUTH.JWT.LEEWAY", "1m")                               // Allowed clock skew
+
+	viper.SetDefault("CORS.ALLOW_ORIGINS", []string{"*"}) // Any origin, without credentials
+	viper.SetDefault("CORS.ALLOW_METHODS", []string{
+		http.MethodGet,
+		http.MethodPost,
+		http.MethodPatch,
+		http.MethodDelete,
+	})
+	viper.SetDefault("CORS.ALLOW_HEADERS", []string{
+		"Accept",
+		"Authorization",
+		"Cache-Control",
+		"Content-Type",
+		"If-Match",
+		"X-API-Key",
+		"X-Request-ID",
+		"X-Requested-With",
+	})
+	viper.SetDefault("CORS.EXPOSE_HEADERS", []string{"ETag", "X-Request-ID"})
+	viper.SetDefault("CORS.ALLOW_CREDENTIALS", false)
+	viper.SetDefault("CORS.MAX_AGE", "10m") // Browsers can skip preflight requests for 10 minutes
+
+	viper.SetDefault("DEFINITIONS.FIELDS", []string{"summary"}) // Results with the same summary are the same test
 }
@@ -28,3 +28,39 @@ const (
 	TestFixed     Resolution = "TestFixed"
 	TestDisabled  Resolution = "TestDisabled"
 )
+
+type DocOperator string
+
+const (
+	DocExists             DocOperator = "exists"
+	DocMissing            DocOperator = "missing"
+	DocEqual              DocOperator = "="
+	DocNotEqual           DocOperator = "!="
+	DocLessThan           DocOperator = "<"
+	DocLessThanOrEqual    DocOperator = "<="
+	DocGreaterThan        DocOperator = ">"
+	DocGreaterThanOrEqual DocOperator = ">="
+	DocBetween            DocOperator = "between"
+	DocIn                 DocOperator = "in"
+	DocRegex              DocOperator = "regex"
+	DocContains           DocOperator = "contains"
+)
+
+type TestOperation string
+
+const (
+	TestInserted TestOperation = "INSERT"
+	TestUpdated  TestOperation = "UPDATE"
+	TestDeleted  TestOperation = "DELETE"
+	TestRestored TestOperation = "RESTORE"
+	TestPurged   TestOperation = "PURGE"
+)
+
+type Scope string
+
+const (
+	ScopeIngest Scope = "ingest"
+	ScopeEnrich Scope = "enrich"
+	ScopeRead   Scope = "read"
+	ScopeAdmin  Scope = "admin"
+)
@@ -4,10 +4,12 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/exp/slices"
 	"io"
+	"strconv"
 	"strings"
 )
 
@@ -24,16 +26,22 @@ func CopyRequestBody(c *gin.Context) ([]byte, error) {
 // to the fully-bound models.Test object and any potential errors that occurred during the process. Note that it will
 // not be validated or cleaned.
 func DoubleBindTest(c *gin.Context) (*Test, error) {
-	test := &Test{}
-
 	// We must copy our request body for the second unmarshal because the bind operation will consume it
 	byteBody, err := CopyRequestBody(c)
 	if err != nil {
 		return nil, err
 	}
 
+	return DoubleUnmarshalTest(byteBody)
+}
+
+// DoubleUnmarshalTest is the same as DoubleBindTest, but works on a raw JSON test object instead of a request body.
+// This lets a batch of tests be bound one at a time.
+func DoubleUnmarshalTest(byteBody []byte) (*Test, error) {
+	test := &Test{}
+
 	// First bind binds the test information
-	if err := c.BindJSON(test); err != nil {
+	if err := json.Unmarshal(byteBody, test); err != nil {
 		return nil, err
 	}
 
@@ -49,10 +57,227 @@ func DoubleBindTest(c *gin.Context) (*Test, error) {
 
 	// Removes the keys that are from the first binding
 	for key := range test.Doc {
-		if slices.Contains([]string{"summary", "id", "outcome", "analysis", "resolution"}, strings.ToLower(key)) {
+		firstBindKeys := []string{
+			"summary",
+			"id",
+			"outcome",
+			"analysis",
+			"resolution",
+			"created",
+			"modified",
+			"deleted",
+			"definitionid",
+			"runid",
+		}
+		if slices.Contains(firstBindKeys, strings.ToLower(key)) {
 			delete(test.Doc, key)
 		}
 	}
 
 	return test, nil
 }
+
+// BindTestPatch will read the patch of a PATCH request into a TestPatcher. The format of the patch depends on the
+// Content-Type of the request:
+//   - "application/merge-patch+json" is a JSON Merge Patch, see JSONMergePatcher. A null value removes a key.
+//   - "application/json-patch+json" is a JSON Patch, see JSONPatcher.
+//   - Anything else is a partial test that is bound with DoubleBindTest and right-merged, see RightMergePatcher.
+func BindTestPatch(c *gin.Context) (TestPatcher, error) {
+	switch c.ContentType() {
+	case MergePatchContentType:
+		var mergePatch map[string]any
+		if err := json.NewDecoder(c.Request.Body).Decode(&mergePatch); err != nil {
+			return nil, fmt.Errorf("a JSON Merge Patch must be an object: %w", err)
+		}
+		return JSONMergePatcher(mergePatch), nil
+	case JSONPatchContentType:
+		byteBody, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return nil, err
+		}
+		operations, err := ParseJSONPatch(byteBody)
+		if err != nil {
+			return nil, err
+		}
+		return JSONPatcher(operations), nil
+	}
+
+	testPatch, err := DoubleBindTest(c)
+	if err != nil {
+		return nil, err
+	}
+	return RightMergePatcher(testPatch), nil
+}
+
+// BindAudit will return the Audit of a request, from the actor and request ID on the gin context. Either can be empty
+// if the request is not authenticated or has no ID.
+func BindAudit(c *gin.Context) *Audit {
+	return &Audit{Actor: c.GetString(ActorKey), RequestID: c.GetString(RequestIDKey)}
+}
+
+// BindTestBatch will read a batch of tests from the request body without decoding them. The body can either be a
+// JSON array of test objects or, if the Content-Type is "application/x-ndjson", a stream of newline-delimited test
+// objects. Each raw test can then be bound on its own with DoubleUnmarshalTest.
+func BindTestBatch(c *gin.Context) ([]json.RawMessage, error) {
+	var rawTests []json.RawMessage
+
+	if c.ContentType() != "application/x-ndjson" {
+		if err := json.NewDecoder(c.Request.Body).Decode(&rawTests); err != nil {
+			return nil, err
+		}
+		return rawTests, nil
+	}
+
+	decoder := json.NewDecoder(c.Request.Body)
+	for {
+		var rawTest json.RawMessage
+		err := decoder.Decode(&rawTest)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", len(rawTests)+1, err)
+		}
+		rawTests = append(rawTests, rawTest)
+	}
+
+	return rawTests, nil
+}
+
+// BindTestQuery will read the TestQuery of a request from either the "q" URL param, a textual query (see
+// ParseTextQuery), or the "query" URL param, a base64 encoded TestQuery obtained from the /query endpoint. Only one of
+// them can be passed. If required is false and neither is passed, an empty TestQuery is returned.
+func BindTestQuery(c *gin.Context, required bool) (*TestQuery, error) {
+	textQuery, hasTextQuery := c.GetQuery("q")
+	encodedQuery, hasEncodedQuery := c.GetQuery("query")
+
+	switch {
+	case hasTextQuery && hasEncodedQuery:
+		return nil, errors.New("can only pass one of the q or query parameters")
+	case hasTextQuery:
+		return ParseTextQuery(textQuery)
+	case hasEncodedQuery && encodedQuery != "null":
+		query := &TestQuery{}
+		if err := decodeFromBase64(query, encodedQuery); err != nil {
+			return nil, err
+		}
+		return query, nil
+	case required:
+		return nil, errors.New("must pass a query parameter")
+	}
+
+	return &TestQuery{}, nil
+}
+
+// BindGroupBy will read the group by dimensions of a request from the "groupBy" URL param. The dimensions can be
+// passed as separate params or comma separated in a single param, like groupBy=created:day,doc.env.
+func BindGroupBy(c *gin.Context) []string {
+	return bindListQuery(c, "groupBy")
+}
+
+// BindFlakinessOptions will read the FlakinessOptions of a flaky test analysis from the URL params:
+//   - "identity" are the Doc paths that identify a test along with its summary, the same way as BindGroupBy.
+//   - "runs" is how many of the most recent results of each test to analyze, 20 by default.
+//   - "days" is how many days back to analyze results from, 0 by default for no limit.
+//   - "minRuns" is the least amount of results a test needs to be flaky, 5 by default.
+//   - "threshold" is the least flakiness score a test needs to be flaky, from 0 to 1, 0.25 by default.
+//   - "limit" is the max amount of flaky tests, 100 by default.
+func BindFlakinessOptions(c *gin.Context) (*FlakinessOptions, error) {
+	options := &FlakinessOptions{Identity: bindListQuery(c, "identity")}
+
+	intParams := []struct {
+		name         string
+		defaultValue string
+		max          int
+		value        *int
+	}{
+		{"runs", "20", 1000, &options.Runs},
+		{"days", "0", 3650, &options.Days},
+		{"minRuns", "5", 1000, &options.MinRuns},
+		{"limit", "100", 1000, &options.Limit},
+	}
+	for _, param := range intParams {
+		value, err := strconv.Atoi(c.DefaultQuery(param.name, param.defaultValue))
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("invalid %s: '%s', must be a positive integer", param.name, c.Query(param.name))
+		}
+		if value > param.max {
+			return nil, fmt.Errorf("maximum allowed %s is %d", param.name, param.max)
+		}
+		*param.value = value
+	}
+	if options.MinRuns < 2 {
+		return nil, errors.New("minRuns must be at least 2, a test cannot flip with fewer results")
+	}
+
+	threshold, err := strconv.ParseFloat(c.DefaultQuery("threshold", "0.25"), 64)
+	if err != nil || threshold < 0 || threshold > 1 {
+		return nil, fmt.Errorf("invalid threshold: '%s', must be from 0 to 1", c.Query("threshold"))
+	}
+	options.Threshold = threshold
+
+	return options, nil
+}
+
+// bindListQuery will read a list from a URL param. The values can be passed as separate params or comma separated in
+// a single param. Blank values are skipped.
+func bindListQuery(c *gin.Context, name string) []string {
+	var values []string
+	for _, param := range c.QueryArray(name) {
+		for _, value := range strings.Split(param, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// BindTestID will read the test ID from the "id" URL path param
+func BindTestID(c *gin.Context) (uint64, error) {
+	testID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid test ID: '%s'", c.Param("id"))
+	}
+	return testID, nil
+}
+
+// BindDefinitionID will read the test definition ID from the "id" URL path param
+func BindDefinitionID(c *gin.Context) (uint64, error) {
+	definitionID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid test definition ID: '%s'", c.Param("id"))
+	}
+	return definitionID, nil
+}
+
+// BindRunID will read the run ID from the "id" URL path param
+func BindRunID(c *gin.Context) (uint64, error) {
+	return parseRunID(c.Param("id"))
+}
+
+// parseRunID will parse a run ID from a URL param
+func parseRunID(value string) (uint64, error) {
+	runID, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid run ID: '%s'", value)
+	}
+	return runID, nil
+}
+
+// IfMatch will check the If-Match header of the request against the current ETag of a resource. Returns true if there
+// is no If-Match header, or if any of its entity tags, or "*", match. Weak entity tags never match.
+// See: https://www.rfc-editor.org/rfc/rfc9110#name-if-match
+func IfMatch(c *gin.Context, etag string) bool {
+	ifMatch := strings.TrimSpace(c.GetHeader("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return true
+	}
+
+	for _, requestETag := range strings.Split(ifMatch, ",") {
+		if strings.TrimSpace(requestETag) == etag {
+			return true
+		}
+	}
+	return false
+}
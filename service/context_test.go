@@ -0,0 +1,105 @@
+package main
+
+import (
+	"github.com/magiconair/properties/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestIfMatch will ensure that the If-Match header is compared to an ETag with a strong comparison
+func TestIfMatch(t *testing.T) {
+	etag := `"7-1704067200000001"`
+
+	scenarios := map[string]struct {
+		ifMatch string
+		matches bool
+	}{
+		"no header":        {"", true},
+		"any":              {"*", true},
+		"same ETag":        {etag, true},
+		"in a list":        {`"1-2", ` + etag, true},
+		"different ETag":   {`"7-1704067200000000"`, false},
+		"weak ETag":        {"W/" + etag, false},
+		"unquoted ETag":    {"7-1704067200000001", false},
+		"empty list entry": {`"1-2",`, false},
+	}
+	for scenario, s := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			c, _ := Fake.ginContext()
+			req, err := http.NewRequest(http.MethodPatch, "/test/7", nil)
+			if err != nil {
+				t.Error("setup error", err)
+			}
+			if s.ifMatch != "" {
+				req.Header.Set("If-Match", s.ifMatch)
+			}
+			c.Request = req
+
+			assert.Equal(t, IfMatch(c, etag), s.matches)
+		})
+	}
+}
+
+// TestDoubleUnmarshalTest will ensure that the keys of the Test are not also kept in the Doc
+func TestDoubleUnmarshalTest(t *testing.T) {
+	test, err := DoubleUnmarshalTest([]byte(
+		`{"summary": "login", "outcome": "Passed", "deleted": "2024-01-01T00:00:00Z", ` +
+			`"Created": "2024-01-01T00:00:00Z", "modified": "2024-01-01T00:00:00Z", "runId": 3, "env": "prod"}`,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, test.Doc, map[string]any{"env": "prod"})
+	assert.Equal(t, test.Summary, "login")
+}
+
+// TestBindGroupBy will ensure that group by dimensions can be passed as separate or comma separated params
+func TestBindGroupBy(t *testing.T) {
+	c, _ := Fake.ginContext()
+	c.Request = httptest.NewRequest(http.MethodGet, "/stats?groupBy=created:day,%20doc.env&groupBy=outcome&groupBy=", nil)
+	assert.Equal(t, BindGroupBy(c), []string{"created:day", "doc.env", "outcome"})
+
+	c, _ = Fake.ginContext()
+	c.Request = httptest.NewRequest(http.MethodGet, "/stats", nil)
+	assert.Equal(t, len(BindGroupBy(c)), 0)
+}
+
+// TestBindFlakinessOptions will ensure that the options of a flaky test analysis have defaults and are limited
+func TestBindFlakinessOptions(t *testing.T) {
+	c, _ := Fake.ginContext()
+	c.Request = httptest.NewRequest(http.MethodGet, "/flaky", nil)
+	options, err := BindFlakinessOptions(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, *options, FlakinessOptions{Runs: 20, MinRuns: 5, Threshold: 0.25, Limit: 100})
+
+	c, _ = Fake.ginContext()
+	c.Request = httptest.NewRequest(
+		http.MethodGet,
+		"/flaky?identity=doc.package,doc.nodeid&runs=50&days=7&minRuns=10&threshold=0.5&limit=10",
+		nil,
+	)
+	options, err = BindFlakinessOptions(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, *options, FlakinessOptions{
+		Identity:  []string{"doc.package", "doc.nodeid"},
+		Runs:      50,
+		Days:      7,
+		MinRuns:   10,
+		Threshold: 0.5,
+		Limit:     10,
+	})
+
+	invalidParams := []string{"runs=1001", "runs=many", "days=-1", "minRuns=1", "threshold=1.5", "limit=1001"}
+	for _, invalidParam := range invalidParams {
+		c, _ = Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodGet, "/flaky?"+invalidParam, nil)
+		if _, err := BindFlakinessOptions(c); err == nil {
+			t.Errorf("invalid param %s did not throw error", invalidParam)
+		}
+	}
+}
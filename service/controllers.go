@@ -2,15 +2,22 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
-// TestController will maintain a database pool for all test controllers
+// TestController will maintain a database pool for all test controllers. MaxDeleteRows is the max amount of tests a
+// delete can affect without being confirmed, 0 for no max. DefinitionFields are the identity fields that link tests to
+// their test definition, see DefinitionConfig.
 type TestController struct {
-	DBPool *pgx.ConnPool
+	DBPool           *pgx.ConnPool
+	MaxDeleteRows    uint64
+	DefinitionFields []string
 }
 
 // CreateTest will create a new test from a Summary, Outcome, and optional Doc
@@ -27,7 +34,7 @@ func (tc *TestController) CreateTest(c *gin.Context) {
 		return
 	}
 
-	testID, err := InsertTest(tc.DBPool, test)
+	testID, err := InsertTest(tc.DBPool, BindAudit(c), test, tc.DefinitionFields)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
 		return
@@ -36,105 +43,457 @@ func (tc *TestController) CreateTest(c *gin.Context) {
 	c.JSON(http.StatusCreated, testID)
 }
 
-// PatchTests will perform a patch (partial update) operation on a batch of tests identified by a base64 test query
-// string obtained from the /query endpoint.
-// PatchTests will respond with a http.StatusNotModified (304) status code if it does not modify a single test.
-// PatchTests will respond with a http.StatusOK (200) status code if it modifies at least 1 test.
+// GetTest will respond with the test of the "id" URL path param, or with a http.StatusNotFound (404) status code if
+// there is no test with the ID. Soft deleted tests are not found. The ETag header will have the version of the test,
+// which can be passed back in an If-Match header to only patch or delete the test if it has not changed since.
+func (tc *TestController) GetTest(c *gin.Context) {
+	testID, err := BindTestID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	test, err := SelectTest(tc.DBPool, testID, false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+	if test == nil {
+		c.JSON(http.StatusNotFound, ConvertErrToGinH(fmt.Errorf("test %d not found", testID)))
+		return
+	}
+
+	c.Header("ETag", test.ETag())
+	c.JSON(http.StatusOK, test)
+}
+
+// PatchTest will perform a patch (partial update) operation on the test of the "id" URL path param. The patch can be
+// a partial test, a JSON Merge Patch or a JSON Patch, the same as PatchTests, and the test is validated after it.
+// PatchTest will respond with a http.StatusOK (200) status code and the updated test, or with a
+// http.StatusNotFound (404) status code if there is no test with the ID.
 //
-// Note that if an error occurs in the middle of updating a batch of tests, it will result in some tests in the batch
-// being updated, while others are not.
-func (tc *TestController) PatchTests(c *gin.Context) {
-	var query TestQuery
+// If the request has an If-Match header, the test will only be patched if its ETag still matches. Otherwise, it will
+// respond with a http.StatusPreconditionFailed (412) status code and the current state of the test, so that
+// concurrent enrichments do not overwrite each other.
+func (tc *TestController) PatchTest(c *gin.Context) {
+	testID, err := BindTestID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
 
-	encodedQuery := c.DefaultQuery("query", "null")
-	if encodedQuery == "null" {
-		c.JSON(http.StatusBadRequest, ConvertErrToGinH(errors.New("must pass a query parameter")))
+	patch, err := BindTestPatch(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
 		return
 	}
 
-	err := decodeFromBase64(&query, encodedQuery)
+	test, err := SelectTest(tc.DBPool, testID, false)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
 		return
 	}
+	if test == nil {
+		c.JSON(http.StatusNotFound, ConvertErrToGinH(fmt.Errorf("test %d not found", testID)))
+		return
+	}
+
+	if !IfMatch(c, test.ETag()) {
+		testPreconditionFailed(c, test)
+		return
+	}
+
+	if err = patch(test); err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
 
-	queryResult, err := QueryTest(tc.DBPool, &query, 250, 0)
+	updated := true
+	if c.GetHeader("If-Match") == "" {
+		err = UpdateTest(tc.DBPool, BindAudit(c), test, tc.DefinitionFields)
+	} else {
+		// The test could have been modified since it was selected
+		updated, err = UpdateTestIfUnmodified(tc.DBPool, BindAudit(c), test, tc.DefinitionFields, test.Modified)
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
 		return
 	}
 
-	testPatch, err := DoubleBindTest(c)
+	// Selects the test again for the new modified timestamp, or the current state if it was not updated
+	test, err = SelectTest(tc.DBPool, testID, false)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
 		return
 	}
+	if test == nil {
+		c.JSON(http.StatusNotFound, ConvertErrToGinH(fmt.Errorf("test %d not found", testID)))
+		return
+	}
+	if !updated {
+		testPreconditionFailed(c, test)
+		return
+	}
 
-	if queryResult.Count == 0 {
-		c.AbortWithStatus(http.StatusNotModified)
+	c.Header("ETag", test.ETag())
+	c.JSON(http.StatusOK, test)
+}
+
+// DeleteTest will soft delete the test of the "id" URL path param, the same way as DeleteTests.
+// DeleteTest will respond with a http.StatusOK (200) status code and the deleted test, or with a
+// http.StatusNotFound (404) status code if there is no test with the ID or it is already deleted.
+//
+// If the request has an If-Match header, the test will only be deleted if its ETag still matches, the same as
+// PatchTest.
+func (tc *TestController) DeleteTest(c *gin.Context) {
+	testID, err := BindTestID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
 		return
 	}
 
-	for _, test := range queryResult.Tests {
-		test.Merge(testPatch)
+	where, err := buildTestQueryWhere(&TestQuery{IDs: []uint64{testID}})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
 
-		// Validate after update to ensure testPatch is still okay
-		if err = test.Validate(); err != nil {
+	ifMatch := c.GetHeader("If-Match") != ""
+	if ifMatch {
+		test, err := SelectTest(tc.DBPool, testID, false)
+		if err != nil {
 			c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
 			return
 		}
+		if test != nil {
+			if !IfMatch(c, test.ETag()) {
+				testPreconditionFailed(c, test)
+				return
+			}
+			// The test could have been modified since it was selected
+			where.and("MODIFIED = " + where.param(test.Modified))
+		}
+	}
 
-		// Update in DB
-		err = UpdateTest(tc.DBPool, test)
+	deleteResponse, err := DeleteTestsWhere(tc.DBPool, BindAudit(c), where, 0, false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+	if deleteResponse.Count == 0 && ifMatch {
+		// Selects the test again for its current state, if it still exists it was modified since it was selected
+		test, err := SelectTest(tc.DBPool, testID, false)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
 			return
 		}
+		if test != nil {
+			testPreconditionFailed(c, test)
+			return
+		}
 	}
-	c.Status(http.StatusOK)
+	if deleteResponse.Count == 0 {
+		c.JSON(http.StatusNotFound, ConvertErrToGinH(fmt.Errorf("test %d not found", testID)))
+		return
+	}
+
+	test, err := SelectTest(tc.DBPool, testID, true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, test)
+}
+
+// GetTestHistory will respond with every recorded change of the test of the "id" URL path param, oldest first, in a
+// TestHistoryResponse. Each change has the before and after values, who made it and the request that made it. The
+// history of a deleted or purged test can still be read.
+// GetTestHistory will respond with a http.StatusNotFound (404) status code if the test has no history.
+func (tc *TestController) GetTestHistory(c *gin.Context) {
+	testID, err := BindTestID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	history, err := SelectTestHistory(tc.DBPool, testID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+	if len(history) == 0 {
+		c.JSON(http.StatusNotFound, ConvertErrToGinH(fmt.Errorf("test %d has no history", testID)))
+		return
+	}
+
+	c.JSON(http.StatusOK, &TestHistoryResponse{Count: len(history), History: history})
 }
 
-// DeleteTests takes in a TestQuery and will delete all the query results
+// testPreconditionFailed will respond with a http.StatusPreconditionFailed (412) status code and the current state of
+// a test, so that the caller can resolve the conflict and try again with its current ETag
+func testPreconditionFailed(c *gin.Context, test *Test) {
+	body := ConvertErrToGinH(fmt.Errorf("test %d has been modified, its current ETag is %s", test.ID, test.ETag()))
+	body["current"] = test
+
+	c.Header("ETag", test.ETag())
+	c.JSON(http.StatusPreconditionFailed, body)
+}
+
+// CreateTests will create a batch of new tests in a single request. The body can be a JSON array of tests or a
+// newline-delimited stream of tests with a Content-Type of "application/x-ndjson". Each test in the batch goes through
+// the same binding, cleaning and validation as CreateTest.
+// CreateTests will respond with a http.StatusCreated (201) status code and the ID of every test if the whole batch was
+// created. If any test in the batch is invalid, none of them will be created and it will respond with a
+// http.StatusBadRequest (400) status code and the error of every invalid test.
+func (tc *TestController) CreateTests(c *gin.Context) {
+	rawTests, err := BindTestBatch(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	tests := make([]*Test, len(rawTests))
+	bindErrs := make([]error, len(rawTests))
+	for i, rawTest := range rawTests {
+		tests[i], bindErrs[i] = DoubleUnmarshalTest(rawTest)
+	}
+
+	tc.createTestBatch(c, tests, bindErrs)
+}
+
+// createTestBatch will clean, validate and insert a batch of tests in a single transaction and respond with a
+// TestBatchResponse. bindErrs can hold an error for each test that could not be bound, at the same index as the test;
+// it can be nil if every test was bound.
+func (tc *TestController) createTestBatch(c *gin.Context, tests []*Test, bindErrs []error) {
+	if len(tests) == 0 {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(errors.New("batch must contain at least 1 test")))
+		return
+	}
+
+	if len(tests) > 10000 { // Maximum batch size
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(errors.New("maximum allowed batch size is 10000")))
+		return
+	}
+
+	response := &TestBatchResponse{Results: make([]*TestBatchResult, len(tests))}
+	batchIsValid := true
+	for i, test := range tests {
+		response.Results[i] = &TestBatchResult{Index: i}
+
+		var err error
+		if bindErrs != nil && bindErrs[i] != nil {
+			err = bindErrs[i]
+		} else {
+			test.Clean()
+			err = test.Validate()
+		}
+
+		if err != nil {
+			response.Results[i].Error = err.Error()
+			batchIsValid = false
+		}
+	}
+
+	if !batchIsValid {
+		c.JSON(http.StatusBadRequest, response)
+		return
+	}
+
+	testIDs, err := InsertTests(tc.DBPool, BindAudit(c), tests, tc.DefinitionFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	for i, testID := range testIDs {
+		response.Results[i].ID = testID
+	}
+	response.Count = len(testIDs)
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// ImportJUnit will create a batch of tests from a JUnit/xUnit XML report in the request body. See ParseJUnitXML for how
+// test cases are converted. Responds the same way as CreateTests.
+func (tc *TestController) ImportJUnit(c *gin.Context) {
+	tc.importTests(c, ParseJUnitXML)
+}
+
+// ImportGoTest will create a batch of tests from a `go test -json` event stream in the request body. See
+// ParseGoTestJSON for how events are converted. Responds the same way as CreateTests.
+func (tc *TestController) ImportGoTest(c *gin.Context) {
+	tc.importTests(c, ParseGoTestJSON)
+}
+
+// ImportCucumber will create a batch of tests from a Cucumber JSON report in the request body. See ParseCucumberJSON
+// for how scenarios are converted. Responds the same way as CreateTests.
+func (tc *TestController) ImportCucumber(c *gin.Context) {
+	tc.importTests(c, ParseCucumberJSON)
+}
+
+// importTests will parse the request body into a batch of tests with an importer parse function, then create them all
+// in a single transaction. The optional "runId" URL param will attach every test to the run, see Run.
+func (tc *TestController) importTests(c *gin.Context, parse func(r io.Reader) ([]*Test, error)) {
+	var runID *uint64
+	if rawRunID, ok := c.GetQuery("runId"); ok {
+		id, err := parseRunID(rawRunID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+			return
+		}
+		runID = &id
+	}
+
+	tests, err := parse(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+	for _, test := range tests {
+		test.RunID = runID
+	}
+
+	tc.createTestBatch(c, tests, nil)
+}
+
+// PatchTests will perform a patch (partial update) operation on every test identified by either a base64 test query
+// string obtained from the /query endpoint or a textual "q" query. See BindTestQuery.
+// PatchTests will respond with a http.StatusNotModified (304) status code if the query does not match a single test.
+// PatchTests will respond with a http.StatusOK (200) status code and a TestPatchResponse if it matches at least 1 test.
+//
+// The patch is a partial test that is right-merged into every test by default. Sending it with the
+// "application/merge-patch+json" Content-Type applies it as a JSON Merge Patch instead, where a null value removes a
+// field or Doc key, and "application/json-patch+json" applies a JSON Patch. See BindTestPatch.
+//
+// All matching tests are patched in a single transaction, if the patch makes any of them invalid, none of them will be
+// updated. Passing the "dryRun=true" URL param will respond with the tests that would change, without updating them.
+func (tc *TestController) PatchTests(c *gin.Context) {
+	query, err := BindTestQuery(c, true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dryRun", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(fmt.Errorf("invalid dryRun: %w", err)))
+		return
+	}
+
+	where, err := buildTestQueryWhere(query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	patch, err := BindTestPatch(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	patchResponse, err := PatchTests(tc.DBPool, BindAudit(c), where, patch, tc.DefinitionFields, dryRun)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	if patchResponse.Matched == 0 {
+		c.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+	c.JSON(http.StatusOK, patchResponse)
+}
+
+// DeleteTests takes in a TestQuery, either base64 encoded or as a textual "q" query, and will soft delete every test
+// that matches it in a single statement. Deleted tests are hidden from queries, unless they have includeDeleted, and
+// can be restored with RestoreTests until they are purged.
 // DeleteTests will respond with a http.StatusNotModified (304) status code if it does not delete a single test.
-// DeleteTests will respond with a http.StatusOK (200) status code if it deletes at least 1 test.
+// DeleteTests will respond with a http.StatusOK (200) status code and a TestDeleteResponse if it deletes at least 1
+// test.
+//
+// Deletes that would affect more than MaxDeleteRows tests, or that have an empty query matching every test, are
+// refused unless the "confirm=true" URL param is passed. Passing the "dryRun=true" URL param will respond with the
+// count and IDs of the tests that would be deleted, without deleting them.
 func (tc *TestController) DeleteTests(c *gin.Context) {
-	var query TestQuery
+	query, err := BindTestQuery(c, true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dryRun", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(fmt.Errorf("invalid dryRun: %w", err)))
+		return
+	}
+	confirmed, err := strconv.ParseBool(c.DefaultQuery("confirm", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(fmt.Errorf("invalid confirm: %w", err)))
+		return
+	}
 
-	encodedQuery := c.DefaultQuery("query", "null")
-	if encodedQuery == "null" {
-		c.JSON(http.StatusBadRequest, ConvertErrToGinH(errors.New("must pass a query parameter")))
+	where, err := buildTestQueryWhere(query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
 		return
 	}
 
-	err := decodeFromBase64(&query, encodedQuery)
+	// The only condition of an empty query is to exclude the tests that are already deleted
+	emptyQuery := len(where.conditions) == 0 || (len(where.conditions) == 1 && where.conditions[0] == testNotDeleted)
+	if emptyQuery && !confirmed && !dryRun {
+		err = errors.New("an empty query would delete every test, pass confirm=true to delete them")
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	maxRows := tc.MaxDeleteRows
+	if confirmed || dryRun {
+		maxRows = 0
+	}
+	deleteResponse, err := DeleteTestsWhere(tc.DBPool, BindAudit(c), where, maxRows, dryRun)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
 		return
 	}
 
-	queryResult, err := QueryTest(tc.DBPool, &query, 250, 0)
+	if deleteResponse.Count == 0 {
+		c.Status(http.StatusNotModified)
+	} else {
+		c.JSON(http.StatusOK, deleteResponse)
+	}
+}
+
+// RestoreTests takes in a TestQuery, either base64 encoded or as a textual "q" query, and will restore every soft
+// deleted test that matches it. The query does not need includeDeleted.
+// RestoreTests will respond with a http.StatusNotModified (304) status code if it does not restore a single test.
+// RestoreTests will respond with a http.StatusOK (200) status code and a TestRestoreResponse if it restores at least 1
+// test.
+func (tc *TestController) RestoreTests(c *gin.Context) {
+	query, err := BindTestQuery(c, true)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
 		return
 	}
+	query.IncludeDeleted = true
 
-	var testIDsToDelete []uint64
-	for _, testToDelete := range queryResult.Tests {
-		testIDsToDelete = append(testIDsToDelete, testToDelete.ID)
+	where, err := buildTestQueryWhere(query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
 	}
 
-	testsDeleted, err := DeleteTests(tc.DBPool, testIDsToDelete)
+	restoredIDs, err := RestoreTestsWhere(tc.DBPool, BindAudit(c), where)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
 		return
 	}
 
-	if testsDeleted < 0 {
-		c.Status(http.StatusInternalServerError)
-	} else if testsDeleted == 0 {
+	if len(restoredIDs) == 0 {
 		c.Status(http.StatusNotModified)
 	} else {
-		c.Status(http.StatusOK)
+		c.JSON(http.StatusOK, TestRestoreResponse{Count: uint64(len(restoredIDs)), IDs: restoredIDs})
 	}
 }
 
@@ -144,8 +503,13 @@ func (tc *TestController) DeleteTests(c *gin.Context) {
 //
 // Additionally, the unstructured Doc can be queried, it will partially match with the Postgres "contains (@>)"
 // operator. For more information, see: https://www.postgresql.org/docs/current/functions-json.html
+//
+// The query can also be passed as a compact textual "q" URL param, like q=outcome:Failed doc.env:prod. See
+// ParseTextQuery for the syntax.
+//
+// Results include the total amount of matching tests and a "nextCursor". Passing the nextCursor back in as the
+// "cursor" URL param will return the next page of results, which stays fast on large tables where an offset does not.
 func (tc *TestController) GetTests(c *gin.Context) {
-	var query TestQuery
 	limit, err := strconv.Atoi(c.DefaultQuery("limit", "250"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
@@ -162,16 +526,28 @@ func (tc *TestController) GetTests(c *gin.Context) {
 		return
 	}
 
-	encodedQuery := c.DefaultQuery("query", "null")
-	if encodedQuery != "null" {
-		err = decodeFromBase64(&query, encodedQuery)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+	query, err := BindTestQuery(c, false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	tc.queryTests(c, query, limit, offset)
+}
+
+// queryTests will respond with a page of the tests that match a query, continuing after the "cursor" URL param if it
+// is passed. See GetTests for more info
+func (tc *TestController) queryTests(c *gin.Context, query *TestQuery, limit int, offset int) {
+	var cursor *TestCursor
+	if encodedCursor := c.Query("cursor"); encodedCursor != "" {
+		cursor = &TestCursor{}
+		if err := decodeFromBase64(cursor, encodedCursor); err != nil {
+			c.JSON(http.StatusBadRequest, ConvertErrToGinH(fmt.Errorf("invalid cursor: %w", err)))
 			return
 		}
 	}
 
-	queryResult, err := QueryTest(tc.DBPool, &query, limit, offset)
+	queryResult, err := QueryTest(tc.DBPool, query, limit, offset, cursor)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
 		return
@@ -179,6 +555,230 @@ func (tc *TestController) GetTests(c *gin.Context) {
 	c.JSON(200, queryResult)
 }
 
+// GetTestDefinitions will respond with the test definitions in a TestDefinitionsResponse, most recently seen first,
+// each with its count of results and its latest result. The "summary" URL param will only include the definitions
+// whose summary matches it, as a case-insensitive regular expression. It can be passed more than once to match any of
+// them. The definitions are paginated with the "limit" and "offset" URL params.
+func (tc *TestController) GetTestDefinitions(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "250"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	if limit > 1000 { // Maximum limit
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(errors.New("maximum allowed limit is 1000")))
+		return
+	}
+
+	definitionsResponse, err := QueryTestDefinitions(tc.DBPool, c.QueryArray("summary"), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+	c.JSON(http.StatusOK, definitionsResponse)
+}
+
+// GetTestDefinition will respond with the test definition of the "id" URL path param, with its count of results and
+// its latest result, or with a http.StatusNotFound (404) status code if there is no test definition with the ID.
+func (tc *TestController) GetTestDefinition(c *gin.Context) {
+	definitionID, err := BindDefinitionID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	definition, err := QueryTestDefinition(tc.DBPool, definitionID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+	if definition == nil {
+		c.JSON(http.StatusNotFound, ConvertErrToGinH(fmt.Errorf("test definition %d not found", definitionID)))
+		return
+	}
+
+	c.JSON(http.StatusOK, definition)
+}
+
+// GetTestDefinitionTests will respond with the results of the test definition of the "id" URL path param, most recent
+// first, or with a http.StatusNotFound (404) status code if there is no test definition with the ID. The results can
+// be narrowed down and paginated the same way as GetTests.
+func (tc *TestController) GetTestDefinitionTests(c *gin.Context) {
+	definitionID, err := BindDefinitionID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "250"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	if limit > 1000 { // Maximum limit
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(errors.New("maximum allowed limit is 1000")))
+		return
+	}
+
+	query, err := BindTestQuery(c, false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	definition, err := QueryTestDefinition(tc.DBPool, definitionID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+	if definition == nil {
+		c.JSON(http.StatusNotFound, ConvertErrToGinH(fmt.Errorf("test definition %d not found", definitionID)))
+		return
+	}
+
+	if query == nil {
+		query = &TestQuery{}
+	}
+	query.DefinitionIDs = []uint64{definitionID}
+	tc.queryTests(c, query, limit, offset)
+}
+
+// GetTestStats will respond with the aggregate statistics of the tests that match a query, in a TestStatsResponse. The
+// query is passed the same way as GetTests, and the "groupBy" URL param has the dimensions to group the tests by: any
+// of summary, outcome, analysis and resolution, a path into the Doc, like doc.env, or a time bucket of created or
+// modified, like created:day. See BindGroupBy.
+//
+// For example, failures per day per environment are: /stats?q=outcome:Failed&groupBy=created:day,doc.env
+//
+// Each group has its count, its ratio of all the matching tests and its pass and failure counts. The groups are
+// ordered by their key, up to the "limit" URL param.
+func (tc *TestController) GetTestStats(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "1000"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	if limit > 10000 { // Maximum limit
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(errors.New("maximum allowed limit is 10000")))
+		return
+	}
+
+	query, err := BindTestQuery(c, false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	statsResponse, err := QueryTestStats(tc.DBPool, query, BindGroupBy(c), limit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+	c.JSON(http.StatusOK, statsResponse)
+}
+
+// GetTestQuality will respond with the quality metrics of the tests that match a query, in a TestQualityResponse. The
+// query and the "groupBy" and "limit" URL params are the same as GetTestStats, like groupBy=summary or
+// groupBy=doc.suite for the metrics of each test or suite. Without a groupBy, the metrics are over every matching test.
+//
+// Each group has the confusion matrix of its analyses, its precision, recall and false positive rate and how much of
+// it has been analyzed. The response also has the noisiest tests, whose failures were most often false positives, up
+// to the "noisiest" URL param, so that they can be fixed or disabled.
+func (tc *TestController) GetTestQuality(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "1000"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+	noisiest, err := strconv.Atoi(c.DefaultQuery("noisiest", "10"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	if limit > 10000 { // Maximum limit
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(errors.New("maximum allowed limit is 10000")))
+		return
+	}
+	if noisiest > 100 { // Maximum noisiest tests
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(errors.New("maximum allowed noisiest is 100")))
+		return
+	}
+
+	query, err := BindTestQuery(c, false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	qualityResponse, err := QueryTestQuality(tc.DBPool, query, BindGroupBy(c), limit, noisiest)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+	c.JSON(http.StatusOK, qualityResponse)
+}
+
+// GetFlakyTests will respond with the tests that flip between Passed and Failed among the tests that match a query,
+// in a FlakyTestsResponse, flakiest first. The query is passed the same way as GetTests, and the analysis options are
+// URL params, see BindFlakinessOptions. Tests are identified by their summary and the "identity" Doc paths, like
+// identity=doc.package, and only their most recent results are analyzed.
+func (tc *TestController) GetFlakyTests(c *gin.Context) {
+	tc.analyzeFlakyTests(c, false)
+}
+
+// MarkFlakyTests will find the flaky tests the same way as GetFlakyTests, then add a "flaky": true marker to the Doc
+// of every analyzed result of each flaky test, so that the enrich UI can show them as flaky. The response has the
+// amount of results that were marked.
+func (tc *TestController) MarkFlakyTests(c *gin.Context) {
+	tc.analyzeFlakyTests(c, true)
+}
+
+// analyzeFlakyTests will find the flaky tests of a request and respond with them, after marking their results if mark
+// is true
+func (tc *TestController) analyzeFlakyTests(c *gin.Context, mark bool) {
+	options, err := BindFlakinessOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	query, err := BindTestQuery(c, false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	flakyTests, err := QueryFlakyTests(tc.DBPool, query, options)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	response := &FlakyTestsResponse{Count: len(flakyTests), Tests: flakyTests}
+	if mark {
+		response.Marked, err = MarkFlakyTests(tc.DBPool, BindAudit(c), flakyTests, tc.DefinitionFields)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
 // EncodeSearchQuery will take a TestQuery as a body and encode it in base64 to send to the GET endpoint.
 // This is an intermediate step for 2 reasons:
 //
@@ -200,3 +800,213 @@ func EncodeSearchQuery(c *gin.Context) {
 	}
 	c.JSON(200, encodedString)
 }
+
+// APIKeyController manages the API keys that can call the OAR service. See Authenticator.
+type APIKeyController struct {
+	DBPool *pgx.ConnPool
+}
+
+// CreateAPIKey will create a new API key from a name and scopes. The API key is generated and only its hash is stored.
+// CreateAPIKey will respond with a http.StatusCreated (201) status code and an APIKeyCreateResponse, which is the only
+// time that the API key itself is returned.
+func (kc *APIKeyController) CreateAPIKey(c *gin.Context) {
+	apiKey := &APIKey{}
+	if err := c.ShouldBindJSON(apiKey); err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	key, prefix, err := NewAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ConvertErrToGinH(err))
+		return
+	}
+	apiKey = &APIKey{Name: strings.TrimSpace(apiKey.Name), Prefix: prefix, Scopes: apiKey.Scopes}
+
+	if err = InsertAPIKey(kc.DBPool, apiKey, HashAPIKey(key)); err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, &APIKeyCreateResponse{APIKey: apiKey, Key: key})
+}
+
+// GetAPIKeys will respond with every API key in an APIKeysResponse, without the keys themselves. Revoked API keys are
+// only included with the "includeRevoked=true" URL param.
+func (kc *APIKeyController) GetAPIKeys(c *gin.Context) {
+	includeRevoked, err := strconv.ParseBool(c.DefaultQuery("includeRevoked", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(fmt.Errorf("invalid includeRevoked: %w", err)))
+		return
+	}
+
+	apiKeys, err := SelectAPIKeys(kc.DBPool, includeRevoked)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, &APIKeysResponse{Count: len(apiKeys), Keys: apiKeys})
+}
+
+// RevokeAPIKey will revoke the API key of the "id" URL path param, so that it can no longer be used.
+// RevokeAPIKey will respond with a http.StatusOK (200) status code and the revoked API key, or with a
+// http.StatusNotFound (404) status code if there is no API key with the ID or it is already revoked.
+func (kc *APIKeyController) RevokeAPIKey(c *gin.Context) {
+	apiKeyID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(fmt.Errorf("invalid API key ID: %w", err)))
+		return
+	}
+
+	apiKey, err := RevokeAPIKey(kc.DBPool, apiKeyID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+	if apiKey == nil {
+		c.JSON(http.StatusNotFound, ConvertErrToGinH(fmt.Errorf("API key %d not found", apiKeyID)))
+		return
+	}
+
+	c.JSON(http.StatusOK, apiKey)
+}
+
+// RunController manages the runs that group the results of a single execution of a test suite. See Run.
+type RunController struct {
+	DBPool *pgx.ConnPool
+}
+
+// CreateRun will start a new run from a name and optional branch, commit, environment and Doc. Results are attached
+// to the run with its ID as their "runId", or with the "runId" URL param of the importers.
+// CreateRun will respond with a http.StatusCreated (201) status code and the new Run.
+func (rc *RunController) CreateRun(c *gin.Context) {
+	run := &Run{}
+	if err := c.ShouldBindJSON(run); err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+	run = &Run{
+		Name:        run.Name,
+		Branch:      run.Branch,
+		Commit:      run.Commit,
+		Environment: run.Environment,
+		Doc:         run.Doc,
+	}
+
+	run.Clean()
+	if err := InsertRun(rc.DBPool, run); err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, run)
+}
+
+// FinishRun will finish the run of the "id" URL path param, so that no more results can be attached to it.
+// FinishRun will respond with a http.StatusOK (200) status code and the RunSummary of the finished run, or with a
+// http.StatusNotFound (404) status code if there is no run with the ID or it is already finished.
+func (rc *RunController) FinishRun(c *gin.Context) {
+	runID, err := BindRunID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	run, err := FinishRun(rc.DBPool, runID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+	if run == nil {
+		c.JSON(http.StatusNotFound, ConvertErrToGinH(fmt.Errorf("run %d not found or already finished", runID)))
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+// GetRuns will respond with the summaries of the runs in a RunsResponse, most recently started first. The "branch",
+// "commit" and "environment" URL params will only include the runs that match all of them exactly. The runs are
+// paginated with the "limit" and "offset" URL params.
+func (rc *RunController) GetRuns(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "250"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	if limit > 1000 { // Maximum limit
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(errors.New("maximum allowed limit is 1000")))
+		return
+	}
+
+	runsResponse, err := QueryRuns(
+		rc.DBPool,
+		c.Query("branch"),
+		c.Query("commit"),
+		c.Query("environment"),
+		limit,
+		offset,
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+	c.JSON(http.StatusOK, runsResponse)
+}
+
+// GetRun will respond with the RunSummary of the run of the "id" URL path param, or with a http.StatusNotFound (404)
+// status code if there is no run with the ID. The results of the run can be queried with the runIds of a TestQuery.
+func (rc *RunController) GetRun(c *gin.Context) {
+	runID, err := BindRunID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+
+	run, err := QueryRun(rc.DBPool, runID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+	if run == nil {
+		c.JSON(http.StatusNotFound, ConvertErrToGinH(fmt.Errorf("run %d not found", runID)))
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+// CompareRuns will compare the run of the "head" URL param with the run of the "base" URL param and respond with a
+// RunComparison. Results are matched by their test definition, see RunComparison.Compare.
+// CompareRuns will respond with a http.StatusNotFound (404) status code if either run does not exist.
+func (rc *RunController) CompareRuns(c *gin.Context) {
+	baseRunID, err := parseRunID(c.Query("base"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(fmt.Errorf("invalid base: %w", err)))
+		return
+	}
+	headRunID, err := parseRunID(c.Query("head"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(fmt.Errorf("invalid head: %w", err)))
+		return
+	}
+
+	comparison, err := CompareRuns(rc.DBPool, baseRunID, headRunID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ConvertErrToGinH(err))
+		return
+	}
+	if comparison == nil {
+		c.JSON(http.StatusNotFound, ConvertErrToGinH(fmt.Errorf("run %d or %d not found", baseRunID, headRunID)))
+		return
+	}
+
+	c.JSON(http.StatusOK, comparison)
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx"
 	"github.com/magiconair/properties/assert"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -95,13 +100,369 @@ func TestTestController_CreateTest(t *testing.T) {
 	}
 }
 
+// TestTestController_TestByID will ensure that a single test can be read, patched and deleted by its ID and that
+// unknown IDs are not found
+func TestTestController_TestByID(t *testing.T) {
+	controller := Fake.testController()
+	testID, err := InsertTest(Fake.pgPool(), nil, Fake.test(), Fake.definitionFields())
+	if err != nil {
+		t.Error("setup error", err)
+	}
+
+	testIDParam := gin.Params{{Key: "id", Value: strconv.FormatUint(testID, 10)}}
+
+	t.Run("get test", func(t *testing.T) {
+		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodGet, "/test/"+testIDParam[0].Value, nil)
+		c.Params = testIDParam
+
+		controller.GetTest(c)
+		assert.Equal(t, w.Code, 200)
+
+		var test Test
+		if err = json.Unmarshal(w.Body.Bytes(), &test); err != nil {
+			t.Error("response error", err)
+		}
+		assert.Equal(t, test.ID, testID)
+	})
+
+	t.Run("patch test returns the updated test", func(t *testing.T) {
+		c, w := Fake.ginContext()
+		c.Request = Fake.testRequest(http.MethodPatch, &Test{Resolution: KnownIssue}, "/test/"+testIDParam[0].Value)
+		c.Params = testIDParam
+
+		controller.PatchTest(c)
+		assert.Equal(t, w.Code, 200)
+
+		var test Test
+		if err = json.Unmarshal(w.Body.Bytes(), &test); err != nil {
+			t.Error("response error", err)
+		}
+		assert.Equal(t, test.ID, testID)
+		assert.Equal(t, test.Resolution, KnownIssue)
+	})
+
+	t.Run("patch with a stale If-Match returns 412 with the current test", func(t *testing.T) {
+		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodGet, "/test/"+testIDParam[0].Value, nil)
+		c.Params = testIDParam
+		controller.GetTest(c)
+		etag := w.Header().Get("ETag")
+		if etag == "" {
+			t.Error("response error, no ETag")
+		}
+
+		// First enrichment with the ETag succeeds and changes the ETag
+		c, w = Fake.ginContext()
+		c.Request = Fake.testRequest(http.MethodPatch, &Test{Resolution: TicketCreated}, "/test/"+testIDParam[0].Value)
+		c.Request.Header.Set("If-Match", etag)
+		c.Params = testIDParam
+		controller.PatchTest(c)
+		assert.Equal(t, w.Code, 200)
+		newETag := w.Header().Get("ETag")
+		assert.Equal(t, newETag != etag, true)
+
+		// Second enrichment with the old ETag is rejected
+		c, w = Fake.ginContext()
+		c.Request = Fake.testRequest(http.MethodPatch, &Test{Resolution: QuickFix}, "/test/"+testIDParam[0].Value)
+		c.Request.Header.Set("If-Match", etag)
+		c.Params = testIDParam
+		controller.PatchTest(c)
+		assert.Equal(t, w.Code, 412)
+		assert.Equal(t, w.Header().Get("ETag"), newETag)
+
+		var conflictResponse struct {
+			Current Test `json:"current"`
+		}
+		if err = json.Unmarshal(w.Body.Bytes(), &conflictResponse); err != nil {
+			t.Error("response error", err)
+		}
+		assert.Equal(t, conflictResponse.Current.Resolution, TicketCreated)
+	})
+
+	t.Run("merge patch removes doc keys and resets the resolution", func(t *testing.T) {
+		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(
+			http.MethodPatch,
+			"/test/"+testIDParam[0].Value,
+			strings.NewReader(`{"resolution": null, "doc": {"owner": "qa", "env": null}}`),
+		)
+		c.Request.Header.Set("Content-Type", MergePatchContentType)
+		c.Params = testIDParam
+
+		controller.PatchTest(c)
+		assert.Equal(t, w.Code, 200)
+
+		var test Test
+		if err = json.Unmarshal(w.Body.Bytes(), &test); err != nil {
+			t.Error("response error", err)
+		}
+		assert.Equal(t, test.Resolution, Unresolved)
+		assert.Equal(t, test.Doc["owner"], "qa")
+		_, hasEnv := test.Doc["env"]
+		assert.Equal(t, hasEnv, false)
+	})
+
+	t.Run("json patch is applied or rejected as a whole", func(t *testing.T) {
+		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(
+			http.MethodPatch,
+			"/test/"+testIDParam[0].Value,
+			strings.NewReader(`[
+				{"op": "replace", "path": "/summary", "value": ""},
+				{"op": "remove", "path": "/doc/owner"}
+			]`),
+		)
+		c.Request.Header.Set("Content-Type", JSONPatchContentType)
+		c.Params = testIDParam
+
+		// The blank summary is invalid, so the doc key is not removed either
+		controller.PatchTest(c)
+		assert.Equal(t, w.Code, 400)
+
+		c, w = Fake.ginContext()
+		c.Request = httptest.NewRequest(
+			http.MethodPatch,
+			"/test/"+testIDParam[0].Value,
+			strings.NewReader(`[
+				{"op": "test", "path": "/doc/owner", "value": "qa"},
+				{"op": "remove", "path": "/doc/owner"}
+			]`),
+		)
+		c.Request.Header.Set("Content-Type", JSONPatchContentType)
+		c.Params = testIDParam
+
+		controller.PatchTest(c)
+		assert.Equal(t, w.Code, 200)
+
+		var test Test
+		if err = json.Unmarshal(w.Body.Bytes(), &test); err != nil {
+			t.Error("response error", err)
+		}
+		_, hasOwner := test.Doc["owner"]
+		assert.Equal(t, hasOwner, false)
+	})
+
+	t.Run("history has every patch with its audit", func(t *testing.T) {
+		c, w := Fake.ginContext()
+		c.Request = Fake.testRequest(http.MethodPatch, &Test{Resolution: QuickFix}, "/test/"+testIDParam[0].Value)
+		c.Params = testIDParam
+		c.Set(ActorKey, "history-actor")
+		c.Set(RequestIDKey, "history-request")
+		controller.PatchTest(c)
+		assert.Equal(t, w.Code, 200)
+
+		c, w = Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodGet, "/test/"+testIDParam[0].Value+"/history", nil)
+		c.Params = testIDParam
+
+		controller.GetTestHistory(c)
+		assert.Equal(t, w.Code, 200)
+
+		var historyResponse TestHistoryResponse
+		if err = json.Unmarshal(w.Body.Bytes(), &historyResponse); err != nil {
+			t.Error("response error", err)
+		}
+		assert.Equal(t, historyResponse.Count, len(historyResponse.History))
+		assert.Equal(t, historyResponse.History[0].Operation, TestInserted)
+		lastChange := historyResponse.History[len(historyResponse.History)-1]
+		assert.Equal(t, lastChange.Operation, TestUpdated)
+		assert.Equal(t, lastChange.Fields, []string{"resolution"})
+		assert.Equal(t, lastChange.Actor, "history-actor")
+		assert.Equal(t, lastChange.RequestID, "history-request")
+	})
+
+	t.Run("invalid patch returns 400", func(t *testing.T) {
+		c, w := Fake.ginContext()
+		c.Request = Fake.testRequest(http.MethodPatch, &Test{Outcome: "Skipped"}, "/test/"+testIDParam[0].Value)
+		c.Params = testIDParam
+
+		controller.PatchTest(c)
+		assert.Equal(t, w.Code, 400)
+	})
+
+	t.Run("delete with a stale If-Match returns 412", func(t *testing.T) {
+		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodDelete, "/test/"+testIDParam[0].Value, nil)
+		c.Request.Header.Set("If-Match", `"0-0"`)
+		c.Params = testIDParam
+
+		controller.DeleteTest(c)
+		assert.Equal(t, w.Code, 412)
+	})
+
+	t.Run("delete test", func(t *testing.T) {
+		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodDelete, "/test/"+testIDParam[0].Value, nil)
+		c.Params = testIDParam
+
+		controller.DeleteTest(c)
+		assert.Equal(t, w.Code, 200)
+
+		// A deleted test is not found anymore
+		c, w = Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodGet, "/test/"+testIDParam[0].Value, nil)
+		c.Params = testIDParam
+
+		controller.GetTest(c)
+		assert.Equal(t, w.Code, 404)
+	})
+
+	unknownIDParam := gin.Params{{Key: "id", Value: strconv.FormatUint(testID+1000000, 10)}}
+	for _, method := range []string{http.MethodGet, http.MethodPatch, http.MethodDelete} {
+		t.Run(method+" unknown test returns 404", func(t *testing.T) {
+			c, w := Fake.ginContext()
+			c.Request = Fake.testRequest(method, &Test{Summary: "Update"}, "/test/"+unknownIDParam[0].Value)
+			c.Params = unknownIDParam
+
+			map[string]gin.HandlerFunc{
+				http.MethodGet:    controller.GetTest,
+				http.MethodPatch:  controller.PatchTest,
+				http.MethodDelete: controller.DeleteTest,
+			}[method](c)
+			assert.Equal(t, w.Code, 404)
+		})
+	}
+
+	t.Run("invalid ID returns 400", func(t *testing.T) {
+		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodGet, "/test/abc", nil)
+		c.Params = gin.Params{{Key: "id", Value: "abc"}}
+
+		controller.GetTest(c)
+		assert.Equal(t, w.Code, 400)
+	})
+}
+
+// TestTestController_CreateTests will ensure that the CreateTests controller creates a whole batch of valid tests and
+// creates nothing if any test in the batch is invalid
+func TestTestController_CreateTests(t *testing.T) {
+	controller := Fake.testController()
+
+	t.Run("valid batch returns an ID for every test", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		tests := multiple(5, Fake.test)
+		c.Request = Fake.testBatchRequest(http.MethodPost, tests, "/tests")
+		controller.CreateTests(c)
+
+		assert.Equal(t, w.Code, 201)
+
+		var batchResponse TestBatchResponse
+		err := json.Unmarshal(w.Body.Bytes(), &batchResponse)
+		if err != nil {
+			t.Error("response error", err)
+		}
+
+		assert.Equal(t, batchResponse.Count, len(tests))
+		for i, result := range batchResponse.Results {
+			createdTests, err := SelectTests(Fake.pgPool(), "select * from oar_tests where id=$1", result.ID)
+			if err != nil || len(createdTests) != 1 {
+				t.Error("created test could not be selected", err)
+				continue
+			}
+			assert.Equal(t, createdTests[0].Summary, tests[i].Summary)
+		}
+	})
+
+	t.Run("ndjson batch is accepted", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		var body bytes.Buffer
+		for _, test := range multiple(3, Fake.test) {
+			jsonValue, err := json.Marshal(Fake.testBody(test))
+			if err != nil {
+				t.Error("setup error", err)
+			}
+			body.Write(append(jsonValue, '\n'))
+		}
+		req, err := http.NewRequest(http.MethodPost, "/tests", &body)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		c.Request = req
+		controller.CreateTests(c)
+
+		assert.Equal(t, w.Code, 201)
+	})
+
+	t.Run("invalid test in batch rejects the whole batch", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		tests := multiple(3, Fake.test)
+		tests[1].Summary = "    "
+		c.Request = Fake.testBatchRequest(http.MethodPost, tests, "/tests")
+		controller.CreateTests(c)
+
+		assert.Equal(t, w.Code, 400)
+
+		var batchResponse TestBatchResponse
+		err := json.Unmarshal(w.Body.Bytes(), &batchResponse)
+		if err != nil {
+			t.Error("response error", err)
+		}
+
+		assert.Equal(t, batchResponse.Count, 0)
+		assert.Equal(t, batchResponse.Results[0].Error, "")
+		assert.Equal(t, batchResponse.Results[1].Error != "", true)
+	})
+
+	t.Run("empty batch is rejected", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		c.Request = Fake.testBatchRequest(http.MethodPost, []*Test{}, "/tests")
+		controller.CreateTests(c)
+
+		assert.Equal(t, w.Code, 400)
+	})
+}
+
+// TestTestController_ImportJUnit will ensure that a JUnit XML report gets imported as a batch of tests
+func TestTestController_ImportJUnit(t *testing.T) {
+	controller := Fake.testController()
+
+	t.Run("valid report returns valid response", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		req, err := http.NewRequest(http.MethodPost, "/import/junit", strings.NewReader(junitReport))
+		if err != nil {
+			t.Error("setup error", err)
+		}
+		c.Request = req
+		controller.ImportJUnit(c)
+
+		assert.Equal(t, w.Code, 201)
+
+		var batchResponse TestBatchResponse
+		err = json.Unmarshal(w.Body.Bytes(), &batchResponse)
+		if err != nil {
+			t.Error("response error", err)
+		}
+		assert.Equal(t, batchResponse.Count, 3)
+	})
+
+	t.Run("invalid report returns 400", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		req, err := http.NewRequest(http.MethodPost, "/import/junit", strings.NewReader("not xml"))
+		if err != nil {
+			t.Error("setup error", err)
+		}
+		c.Request = req
+		controller.ImportJUnit(c)
+
+		assert.Equal(t, w.Code, 400)
+	})
+}
+
 // TestTestController_DeleteTests will ensure that you can delete tests and that deleting non-existing tests does not
 // throw an error
 func TestTestController_DeleteTests(t *testing.T) {
 	controller := Fake.testController()
 
-	testID, err := InsertTest(Fake.pgPool(), Fake.test())
-	testID2, err := InsertTest(Fake.pgPool(), Fake.test())
+	testID, err := InsertTest(Fake.pgPool(), nil, Fake.test(), Fake.definitionFields())
+	testID2, err := InsertTest(Fake.pgPool(), nil, Fake.test(), Fake.definitionFields())
 
 	query := TestQuery{
 		IDs:            []uint64{testID, testID2},
@@ -148,8 +509,13 @@ func TestTestController_DeleteTests(t *testing.T) {
 		assert.Equal(t, w.Code, http.StatusOK)
 	})
 
-	t.Run("delete with no query", func(t *testing.T) {
-		req, err := http.NewRequest(http.MethodDelete, "/tests", nil)
+	t.Run("delete tests with a text query", func(t *testing.T) {
+		testID3, err := InsertTest(Fake.pgPool(), nil, Fake.test(), Fake.definitionFields())
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("/tests?q=id:%d", testID3), nil)
 		if err != nil {
 			t.Error("setup error", err)
 		}
@@ -159,91 +525,316 @@ func TestTestController_DeleteTests(t *testing.T) {
 		c.Request = req
 		controller.DeleteTests(c)
 
-		assert.Equal(t, w.Code, 400)
+		assert.Equal(t, w.Code, 200)
 	})
-}
 
-// TestTestController_PatchTest will ensure that PatchTest works with valid tests and rejects invalid tests
-func TestTestController_PatchTest(t *testing.T) {
-	controller := Fake.testController()
-	testID, err := InsertTest(Fake.pgPool(), Fake.test())
-	if err != nil {
-		t.Error("setup error", err)
-	}
+	t.Run("dry run returns IDs without deleting", func(t *testing.T) {
+		dryRunIDs, err := InsertTests(Fake.pgPool(), nil, multiple(3, Fake.test), Fake.definitionFields())
+		if err != nil {
+			t.Error("setup error", err)
+		}
 
-	tests, err := SelectTests(Fake.pgPool(), "select * from oar_tests where id=$1", testID)
-	if err != nil {
-		t.Error("setup error", err)
-	}
-	test := tests[0]
+		encodedQuery, err := encodeToBase64(TestQuery{IDs: dryRunIDs})
+		if err != nil {
+			t.Error("setup error", err)
+		}
 
-	query := TestQuery{
-		IDs:            []uint64{testID},
-		Summaries:      nil,
-		Outcomes:       nil,
-		Analyses:       nil,
-		Resolutions:    nil,
-		CreatedBefore:  nil,
-		CreatedAfter:   nil,
-		ModifiedBefore: nil,
-		ModifiedAfter:  nil,
-		Docs:           nil,
-	}
-	encodedQuery, err := encodeToBase64(query)
-	if err != nil {
-		t.Error(err)
-	}
+		req, err := http.NewRequest(http.MethodDelete, "/tests?dryRun=true&query="+encodedQuery, nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
 
-	t.Run("valid test returns valid response", func(t *testing.T) {
 		c, w := Fake.ginContext()
 
-		c.Request = Fake.testRequest(http.MethodPatch, test, "/tests?query="+encodedQuery)
-		controller.PatchTests(c)
+		c.Request = req
+		controller.DeleteTests(c)
 
 		assert.Equal(t, w.Code, 200)
+
+		var deleteResponse TestDeleteResponse
+
+		err = json.Unmarshal(w.Body.Bytes(), &deleteResponse)
+		if err != nil {
+			t.Error("response error", err)
+		}
+
+		assert.Equal(t, deleteResponse.DryRun, true)
+		assert.Equal(t, deleteResponse.Count, uint64(3))
+		assert.Equal(t, deleteResponse.IDs, dryRunIDs)
+
+		tests, err := SelectTests(Fake.pgPool(), "select * from oar_tests where id = any($1)", dryRunIDs)
+		if err != nil {
+			t.Error("response error", err)
+		}
+		assert.Equal(t, len(tests), 3)
 	})
 
-	outcome := Fake.testOutcome()
-	analysis := Fake.testAnalysis(&outcome)
-	resolution := Fake.testResolution()
+	t.Run("delete over the max needs confirm", func(t *testing.T) {
+		limitedController := &TestController{DBPool: Fake.pgPool(), MaxDeleteRows: 2}
 
-	invalidTests := map[string]*Test{
-		"blank summary": {
-			ID:         test.ID,
-			Summary:    "    ",
-			Outcome:    outcome,
-			Analysis:   analysis,
-			Resolution: resolution,
-			Created:    time.Now(),
-			Modified:   time.Now(),
-			Doc:        nil,
-		},
-		"invalid outcome": {
-			ID:         test.ID,
-			Summary:    Fake.testSummary(),
-			Outcome:    "Skipped",
-			Analysis:   analysis,
-			Resolution: resolution,
-			Created:    time.Now(),
-			Modified:   time.Now(),
-			Doc:        nil,
-		},
-		"invalid analysis": {
-			ID:         test.ID,
-			Summary:    "    ",
-			Outcome:    outcome,
-			Analysis:   "Some Analysis",
-			Resolution: resolution,
-			Created:    time.Now(),
-			Modified:   time.Now(),
-			Doc:        nil,
-		},
-		"invalid resolution": {
-			ID:         test.ID,
-			Summary:    "    ",
-			Outcome:    outcome,
-			Analysis:   analysis,
-			Resolution: "Some resolution",
+		overMaxIDs, err := InsertTests(Fake.pgPool(), nil, multiple(3, Fake.test), Fake.definitionFields())
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		encodedQuery, err := encodeToBase64(TestQuery{IDs: overMaxIDs})
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		req, err := http.NewRequest(http.MethodDelete, "/tests?query="+encodedQuery, nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		c, w := Fake.ginContext()
+
+		c.Request = req
+		limitedController.DeleteTests(c)
+
+		assert.Equal(t, w.Code, 400)
+
+		tests, err := SelectTests(Fake.pgPool(), "select * from oar_tests where id = any($1)", overMaxIDs)
+		if err != nil {
+			t.Error("response error", err)
+		}
+		assert.Equal(t, len(tests), 3)
+
+		req, err = http.NewRequest(http.MethodDelete, "/tests?confirm=true&query="+encodedQuery, nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		c, w = Fake.ginContext()
+
+		c.Request = req
+		limitedController.DeleteTests(c)
+
+		assert.Equal(t, w.Code, 200)
+	})
+
+	t.Run("delete with an empty query needs confirm", func(t *testing.T) {
+		encodedQuery, err := encodeToBase64(TestQuery{})
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		req, err := http.NewRequest(http.MethodDelete, "/tests?query="+encodedQuery, nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		c, w := Fake.ginContext()
+
+		c.Request = req
+		controller.DeleteTests(c)
+
+		assert.Equal(t, w.Code, 400)
+	})
+
+	t.Run("delete with no query", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, "/tests", nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		c, w := Fake.ginContext()
+
+		c.Request = req
+		controller.DeleteTests(c)
+
+		assert.Equal(t, w.Code, 400)
+	})
+}
+
+// TestTestController_RestoreTests will ensure that deleted tests are hidden from queries until they are restored
+func TestTestController_RestoreTests(t *testing.T) {
+	controller := Fake.testController()
+
+	testIDs, err := InsertTests(Fake.pgPool(), nil, multiple(2, Fake.test), Fake.definitionFields())
+	if err != nil {
+		t.Error("setup error", err)
+	}
+	textQuery := fmt.Sprintf("id:%d,%d", testIDs[0], testIDs[1])
+
+	req, err := http.NewRequest(http.MethodDelete, "/tests?q="+url.QueryEscape(textQuery), nil)
+	if err != nil {
+		t.Error("setup error", err)
+	}
+	c, w := Fake.ginContext()
+	c.Request = req
+	controller.DeleteTests(c)
+	assert.Equal(t, w.Code, 200)
+
+	queryTestIDs := func(includeDeleted bool) []uint64 {
+		encodedQuery, err := encodeToBase64(TestQuery{IDs: testIDs, IncludeDeleted: includeDeleted})
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "/tests?query="+encodedQuery, nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+		c, w := Fake.ginContext()
+		c.Request = req
+		controller.GetTests(c)
+
+		var queryResponse TestQueryResponse
+		if err = json.Unmarshal(w.Body.Bytes(), &queryResponse); err != nil {
+			t.Error("response error", err)
+		}
+
+		var returnedTestIDs []uint64
+		for _, test := range queryResponse.Tests {
+			returnedTestIDs = append(returnedTestIDs, test.ID)
+		}
+		return returnedTestIDs
+	}
+
+	t.Run("deleted tests are hidden", func(t *testing.T) {
+		assert.Equal(t, len(queryTestIDs(false)), 0)
+		assert.Equal(t, len(queryTestIDs(true)), 2)
+	})
+
+	t.Run("restore tests", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, "/tests/restore?q="+url.QueryEscape(textQuery), nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+		c, w := Fake.ginContext()
+		c.Request = req
+		controller.RestoreTests(c)
+		assert.Equal(t, w.Code, 200)
+
+		var restoreResponse TestRestoreResponse
+		if err = json.Unmarshal(w.Body.Bytes(), &restoreResponse); err != nil {
+			t.Error("response error", err)
+		}
+		assert.Equal(t, restoreResponse.IDs, testIDs)
+		assert.Equal(t, len(queryTestIDs(false)), 2)
+	})
+
+	t.Run("restore with no query", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, "/tests/restore", nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+		c, w := Fake.ginContext()
+		c.Request = req
+		controller.RestoreTests(c)
+		assert.Equal(t, w.Code, 400)
+	})
+}
+
+// TestTestController_PatchTest will ensure that PatchTest works with valid tests and rejects invalid tests
+func TestTestController_PatchTest(t *testing.T) {
+	controller := Fake.testController()
+	testID, err := InsertTest(Fake.pgPool(), nil, Fake.test(), Fake.definitionFields())
+	if err != nil {
+		t.Error("setup error", err)
+	}
+
+	tests, err := SelectTests(Fake.pgPool(), "select * from oar_tests where id=$1", testID)
+	if err != nil {
+		t.Error("setup error", err)
+	}
+	test := tests[0]
+
+	query := TestQuery{
+		IDs:            []uint64{testID},
+		Summaries:      nil,
+		Outcomes:       nil,
+		Analyses:       nil,
+		Resolutions:    nil,
+		CreatedBefore:  nil,
+		CreatedAfter:   nil,
+		ModifiedBefore: nil,
+		ModifiedAfter:  nil,
+		Docs:           nil,
+	}
+	encodedQuery, err := encodeToBase64(query)
+	if err != nil {
+		t.Error(err)
+	}
+
+	t.Run("valid test returns valid response", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		c.Request = Fake.testRequest(http.MethodPatch, test, "/tests?query="+encodedQuery)
+		controller.PatchTests(c)
+
+		assert.Equal(t, w.Code, 200)
+	})
+
+	t.Run("dry run returns changes without writing", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		testPatch := Test{Summary: "Dry run update"}
+		c.Request = Fake.testRequest(http.MethodPatch, &testPatch, "/tests?dryRun=true&query="+encodedQuery)
+		controller.PatchTests(c)
+
+		assert.Equal(t, w.Code, 200)
+
+		var patchResponse TestPatchResponse
+
+		err = json.Unmarshal(w.Body.Bytes(), &patchResponse)
+		if err != nil {
+			t.Error("response error", err)
+		}
+
+		assert.Equal(t, patchResponse.DryRun, true)
+		assert.Equal(t, patchResponse.Count, uint64(1))
+		assert.Equal(t, patchResponse.Tests[0].Summary, "Dry run update")
+
+		tests, err := SelectTests(Fake.pgPool(), "select * from oar_tests where id=$1", testID)
+		if err != nil {
+			t.Error("response error", err)
+		}
+		assert.Equal(t, tests[0].Summary, test.Summary)
+	})
+
+	outcome := Fake.testOutcome()
+	analysis := Fake.testAnalysis(&outcome)
+	resolution := Fake.testResolution()
+
+	invalidTests := map[string]*Test{
+		"blank summary": {
+			ID:         test.ID,
+			Summary:    "    ",
+			Outcome:    outcome,
+			Analysis:   analysis,
+			Resolution: resolution,
+			Created:    time.Now(),
+			Modified:   time.Now(),
+			Doc:        nil,
+		},
+		"invalid outcome": {
+			ID:         test.ID,
+			Summary:    Fake.testSummary(),
+			Outcome:    "Skipped",
+			Analysis:   analysis,
+			Resolution: resolution,
+			Created:    time.Now(),
+			Modified:   time.Now(),
+			Doc:        nil,
+		},
+		"invalid analysis": {
+			ID:         test.ID,
+			Summary:    "    ",
+			Outcome:    outcome,
+			Analysis:   "Some Analysis",
+			Resolution: resolution,
+			Created:    time.Now(),
+			Modified:   time.Now(),
+			Doc:        nil,
+		},
+		"invalid resolution": {
+			ID:         test.ID,
+			Summary:    "    ",
+			Outcome:    outcome,
+			Analysis:   analysis,
+			Resolution: "Some resolution",
 			Created:    time.Now(),
 			Modified:   time.Now(),
 			Doc:        nil,
@@ -350,16 +941,479 @@ func TestTestController_GetTests(t *testing.T) {
 
 	for i := 0; i < numTests; i++ {
 		generatedTests = append(generatedTests, Fake.test())
-		testID, err := InsertTest(Fake.pgPool(), generatedTests[i])
+		testID, err := InsertTest(Fake.pgPool(), nil, generatedTests[i], Fake.definitionFields())
 		if err != nil {
 			t.Error("setup error", err)
 		}
 		testIDs = append(testIDs, testID)
 	}
 
-	t.Run("valid query returns valid response", func(t *testing.T) {
+	t.Run("valid query returns valid response", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		query := TestQuery{
+			IDs:            testIDs,
+			Summaries:      nil,
+			Outcomes:       nil,
+			Analyses:       nil,
+			Resolutions:    nil,
+			CreatedBefore:  nil,
+			CreatedAfter:   nil,
+			ModifiedBefore: nil,
+			ModifiedAfter:  nil,
+			Docs:           nil,
+		}
+		encodedQuery, err := encodeToBase64(query)
+		if err != nil {
+			t.Error("setup error")
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "/tests?query="+encodedQuery, nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		c.Request = req
+
+		controller.GetTests(c)
+		assert.Equal(t, w.Code, 200)
+
+		var queryResponse TestQueryResponse
+
+		err = json.Unmarshal(w.Body.Bytes(), &queryResponse)
+		if err != nil {
+			t.Error("response error", err)
+		}
+
+		returnedTestIDs := make([]uint64, numTests, numTests)
+		for i, test := range queryResponse.Tests {
+			returnedTestIDs[len(queryResponse.Tests)-1-i] = test.ID
+		}
+
+		assert.Equal(t, returnedTestIDs, testIDs)
+	})
+
+	t.Run("limit and offset work", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		query := TestQuery{
+			IDs:            testIDs,
+			Summaries:      nil,
+			Outcomes:       nil,
+			Analyses:       nil,
+			Resolutions:    nil,
+			CreatedBefore:  nil,
+			CreatedAfter:   nil,
+			ModifiedBefore: nil,
+			ModifiedAfter:  nil,
+			Docs:           nil,
+		}
+		encodedQuery, err := encodeToBase64(query)
+		if err != nil {
+			t.Error("setup error")
+		}
+
+		req, err := http.NewRequest(
+			http.MethodGet,
+			"/tests?query="+encodedQuery+"&limit="+strconv.Itoa(numTests-2)+"&offset=3",
+			nil,
+		)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		c.Request = req
+
+		controller.GetTests(c)
+		assert.Equal(t, w.Code, 200)
+
+		var queryResponse TestQueryResponse
+
+		err = json.Unmarshal(w.Body.Bytes(), &queryResponse)
+		if err != nil {
+			t.Error("response error", err)
+		}
+
+		assert.Equal(t, len(queryResponse.Tests), numTests-3)
+		assert.Equal(t, queryResponse.Count, uint64(numTests-3))
+	})
+
+	t.Run("total counts every match", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		encodedQuery, err := encodeToBase64(TestQuery{IDs: testIDs})
+		if err != nil {
+			t.Error("setup error")
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "/tests?limit=2&query="+encodedQuery, nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		c.Request = req
+
+		controller.GetTests(c)
+		assert.Equal(t, w.Code, 200)
+
+		var queryResponse TestQueryResponse
+
+		err = json.Unmarshal(w.Body.Bytes(), &queryResponse)
+		if err != nil {
+			t.Error("response error", err)
+		}
+
+		assert.Equal(t, queryResponse.Count, uint64(2))
+		assert.Equal(t, queryResponse.Total, uint64(numTests))
+		assert.Equal(t, queryResponse.TotalEstimated, false)
+	})
+
+	t.Run("cursor pagination works", func(t *testing.T) {
+		encodedQuery, err := encodeToBase64(TestQuery{IDs: testIDs})
+		if err != nil {
+			t.Error("setup error")
+		}
+
+		var pagedTestIDs []uint64
+		cursor := ""
+		for page := 0; page < numTests; page++ {
+			c, w := Fake.ginContext()
+
+			req, err := http.NewRequest(
+				http.MethodGet,
+				"/tests?limit=2&query="+encodedQuery+"&cursor="+url.QueryEscape(cursor),
+				nil,
+			)
+			if err != nil {
+				t.Error("setup error", err)
+			}
+
+			c.Request = req
+
+			controller.GetTests(c)
+			assert.Equal(t, w.Code, 200)
+
+			var queryResponse TestQueryResponse
+
+			err = json.Unmarshal(w.Body.Bytes(), &queryResponse)
+			if err != nil {
+				t.Error("response error", err)
+			}
+
+			for _, test := range queryResponse.Tests {
+				pagedTestIDs = append(pagedTestIDs, test.ID)
+			}
+
+			cursor = queryResponse.NextCursor
+			if cursor == "" {
+				break
+			}
+		}
+
+		returnedTestIDs := make([]uint64, len(pagedTestIDs))
+		for i, testID := range pagedTestIDs {
+			returnedTestIDs[len(pagedTestIDs)-1-i] = testID
+		}
+		assert.Equal(t, returnedTestIDs, testIDs)
+	})
+
+	t.Run("sort works", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		encodedQuery, err := encodeToBase64(TestQuery{
+			IDs:  testIDs,
+			Sort: []TestSort{{Key: "id", Direction: "asc"}},
+		})
+		if err != nil {
+			t.Error("setup error")
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "/tests?query="+encodedQuery, nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		c.Request = req
+
+		controller.GetTests(c)
+		assert.Equal(t, w.Code, 200)
+
+		var queryResponse TestQueryResponse
+
+		err = json.Unmarshal(w.Body.Bytes(), &queryResponse)
+		if err != nil {
+			t.Error("response error", err)
+		}
+
+		returnedTestIDs := make([]uint64, 0, numTests)
+		for _, test := range queryResponse.Tests {
+			returnedTestIDs = append(returnedTestIDs, test.ID)
+		}
+		assert.Equal(t, returnedTestIDs, testIDs)
+	})
+
+	t.Run("invalid sort returns 400", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		encodedQuery, err := encodeToBase64(TestQuery{Sort: []TestSort{{Key: "1; DROP TABLE oar_tests"}}})
+		if err != nil {
+			t.Error("setup error")
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "/tests?query="+encodedQuery, nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		c.Request = req
+
+		controller.GetTests(c)
+		assert.Equal(t, w.Code, 400)
+	})
+
+	t.Run("invalid cursor returns 400", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		req, err := http.NewRequest(http.MethodGet, "/tests?cursor=notACursor", nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		c.Request = req
+
+		controller.GetTests(c)
+		assert.Equal(t, w.Code, 400)
+	})
+
+	t.Run("oar filtering works", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		outcomeFilter := generatedTests[0].Outcome
+		analysisFilter := generatedTests[0].Analysis
+		resolutionFilter := generatedTests[0].Resolution
+
+		query := TestQuery{
+			IDs:            testIDs,
+			Summaries:      nil,
+			Outcomes:       []string{string(outcomeFilter)},
+			Analyses:       []string{string(analysisFilter)},
+			Resolutions:    []string{string(resolutionFilter)},
+			CreatedBefore:  nil,
+			CreatedAfter:   nil,
+			ModifiedBefore: nil,
+			ModifiedAfter:  nil,
+			Docs:           nil,
+		}
+		encodedQuery, err := encodeToBase64(query)
+		if err != nil {
+			t.Error("setup error")
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "/tests?query="+encodedQuery, nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		c.Request = req
+
+		controller.GetTests(c)
+		assert.Equal(t, w.Code, 200)
+
+		var queryResponse TestQueryResponse
+
+		err = json.Unmarshal(w.Body.Bytes(), &queryResponse)
+		if err != nil {
+			t.Error("response error", err)
+		}
+
+		for _, test := range queryResponse.Tests {
+			assert.Equal(t, test.Outcome, outcomeFilter)
+			assert.Equal(t, test.Analysis, analysisFilter)
+			assert.Equal(t, test.Resolution, resolutionFilter)
+		}
+	})
+
+	t.Run("oar summary filtering works", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		summaryFilter := generatedTests[0].Summary
+
+		query := TestQuery{
+			IDs:            testIDs,
+			Summaries:      []string{generatedTests[0].Summary},
+			Outcomes:       nil,
+			Analyses:       nil,
+			Resolutions:    nil,
+			CreatedBefore:  nil,
+			CreatedAfter:   nil,
+			ModifiedBefore: nil,
+			ModifiedAfter:  nil,
+			Docs:           nil,
+		}
+		encodedQuery, err := encodeToBase64(query)
+		if err != nil {
+			t.Error("setup error")
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "/tests?query="+encodedQuery, nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		c.Request = req
+
+		controller.GetTests(c)
+		assert.Equal(t, w.Code, 200)
+
+		var queryResponse TestQueryResponse
+
+		err = json.Unmarshal(w.Body.Bytes(), &queryResponse)
+		if err != nil {
+			t.Error("response error", err)
+		}
+
+		for _, test := range queryResponse.Tests {
+			assert.Equal(t, test.Summary, summaryFilter)
+		}
+	})
+
+	t.Run("summary with a quote doesn't fail", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		encodedQuery, err := encodeToBase64(TestQuery{IDs: testIDs, Summaries: []string{"user's test"}})
+		if err != nil {
+			t.Error("setup error")
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "/tests?query="+encodedQuery, nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		c.Request = req
+
+		controller.GetTests(c)
+		assert.Equal(t, w.Code, 200)
+	})
+
+	t.Run("search works", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		searchedTest := Fake.test()
+		searchedTest.Doc = map[string]any{"notes": "flamingo quartz"}
+		searchedTestID, err := InsertTest(Fake.pgPool(), nil, searchedTest, Fake.definitionFields())
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		encodedQuery, err := encodeToBase64(TestQuery{
+			IDs:    append([]uint64{searchedTestID}, testIDs...),
+			Search: "flamingo",
+		})
+		if err != nil {
+			t.Error("setup error")
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "/tests?query="+encodedQuery, nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		c.Request = req
+
+		controller.GetTests(c)
+		assert.Equal(t, w.Code, 200)
+
+		var queryResponse TestQueryResponse
+
+		err = json.Unmarshal(w.Body.Bytes(), &queryResponse)
+		if err != nil {
+			t.Error("response error", err)
+		}
+
+		assert.Equal(t, len(queryResponse.Tests), 1)
+		assert.Equal(t, queryResponse.Tests[0].ID, searchedTestID)
+	})
+
+	t.Run("filter limit works", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		query := TestQuery{
+			IDs:            testIDs,
+			Summaries:      nil,
+			Outcomes:       nil,
+			Analyses:       nil,
+			Resolutions:    nil,
+			CreatedBefore:  nil,
+			CreatedAfter:   nil,
+			ModifiedBefore: nil,
+			ModifiedAfter:  nil,
+			Docs:           nil,
+		}
+		encodedQuery, err := encodeToBase64(query)
+		if err != nil {
+			t.Error("setup error")
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "/tests?limit=1001&query="+encodedQuery, nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		c.Request = req
+
+		controller.GetTests(c)
+		assert.Equal(t, w.Code, 400)
+	})
+
+	t.Run("created before filter work", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		tomorrow := time.Now().AddDate(0, 0, 1)
+
+		query := TestQuery{
+			IDs:            testIDs,
+			Summaries:      nil,
+			Outcomes:       nil,
+			Analyses:       nil,
+			Resolutions:    nil,
+			CreatedBefore:  &tomorrow,
+			CreatedAfter:   nil,
+			ModifiedBefore: nil,
+			ModifiedAfter:  nil,
+			Docs:           nil,
+		}
+		encodedQuery, err := encodeToBase64(query)
+		if err != nil {
+			t.Error("setup error")
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "/tests?query="+encodedQuery, nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		c.Request = req
+
+		controller.GetTests(c)
+		assert.Equal(t, w.Code, 200)
+
+		var queryResponse TestQueryResponse
+
+		err = json.Unmarshal(w.Body.Bytes(), &queryResponse)
+		if err != nil {
+			t.Error("response error", err)
+		}
+
+		assert.Equal(t, len(queryResponse.Tests), numTests)
+		assert.Equal(t, queryResponse.Count, uint64(numTests))
+	})
+
+	t.Run("created after filter work", func(t *testing.T) {
 		c, w := Fake.ginContext()
 
+		yesterday := time.Now().AddDate(0, 0, -1)
+
 		query := TestQuery{
 			IDs:            testIDs,
 			Summaries:      nil,
@@ -367,7 +1421,7 @@ func TestTestController_GetTests(t *testing.T) {
 			Analyses:       nil,
 			Resolutions:    nil,
 			CreatedBefore:  nil,
-			CreatedAfter:   nil,
+			CreatedAfter:   &yesterday,
 			ModifiedBefore: nil,
 			ModifiedAfter:  nil,
 			Docs:           nil,
@@ -394,17 +1448,15 @@ func TestTestController_GetTests(t *testing.T) {
 			t.Error("response error", err)
 		}
 
-		returnedTestIDs := make([]uint64, numTests, numTests)
-		for i, test := range queryResponse.Tests {
-			returnedTestIDs[len(queryResponse.Tests)-1-i] = test.ID
-		}
-
-		assert.Equal(t, returnedTestIDs, testIDs)
+		assert.Equal(t, len(queryResponse.Tests), numTests)
+		assert.Equal(t, queryResponse.Count, uint64(numTests))
 	})
 
-	t.Run("limit and offset work", func(t *testing.T) {
+	t.Run("modified before filter work", func(t *testing.T) {
 		c, w := Fake.ginContext()
 
+		tomorrow := time.Now().AddDate(0, 0, 1)
+
 		query := TestQuery{
 			IDs:            testIDs,
 			Summaries:      nil,
@@ -413,7 +1465,7 @@ func TestTestController_GetTests(t *testing.T) {
 			Resolutions:    nil,
 			CreatedBefore:  nil,
 			CreatedAfter:   nil,
-			ModifiedBefore: nil,
+			ModifiedBefore: &tomorrow,
 			ModifiedAfter:  nil,
 			Docs:           nil,
 		}
@@ -422,11 +1474,7 @@ func TestTestController_GetTests(t *testing.T) {
 			t.Error("setup error")
 		}
 
-		req, err := http.NewRequest(
-			http.MethodGet,
-			"/tests?query="+encodedQuery+"&limit="+strconv.Itoa(numTests-2)+"&offset=3",
-			nil,
-		)
+		req, err := http.NewRequest(http.MethodGet, "/tests?query="+encodedQuery, nil)
 		if err != nil {
 			t.Error("setup error", err)
 		}
@@ -443,27 +1491,25 @@ func TestTestController_GetTests(t *testing.T) {
 			t.Error("response error", err)
 		}
 
-		assert.Equal(t, len(queryResponse.Tests), numTests-3)
-		assert.Equal(t, queryResponse.Count, uint64(numTests-3))
+		assert.Equal(t, len(queryResponse.Tests), numTests)
+		assert.Equal(t, queryResponse.Count, uint64(numTests))
 	})
 
-	t.Run("oar filtering works", func(t *testing.T) {
+	t.Run("modified after filter work", func(t *testing.T) {
 		c, w := Fake.ginContext()
 
-		outcomeFilter := generatedTests[0].Outcome
-		analysisFilter := generatedTests[0].Analysis
-		resolutionFilter := generatedTests[0].Resolution
+		yesterday := time.Now().AddDate(0, 0, -1)
 
 		query := TestQuery{
 			IDs:            testIDs,
 			Summaries:      nil,
-			Outcomes:       []string{string(outcomeFilter)},
-			Analyses:       []string{string(analysisFilter)},
-			Resolutions:    []string{string(resolutionFilter)},
+			Outcomes:       nil,
+			Analyses:       nil,
+			Resolutions:    nil,
 			CreatedBefore:  nil,
 			CreatedAfter:   nil,
 			ModifiedBefore: nil,
-			ModifiedAfter:  nil,
+			ModifiedAfter:  &yesterday,
 			Docs:           nil,
 		}
 		encodedQuery, err := encodeToBase64(query)
@@ -488,21 +1534,19 @@ func TestTestController_GetTests(t *testing.T) {
 			t.Error("response error", err)
 		}
 
-		for _, test := range queryResponse.Tests {
-			assert.Equal(t, test.Outcome, outcomeFilter)
-			assert.Equal(t, test.Analysis, analysisFilter)
-			assert.Equal(t, test.Resolution, resolutionFilter)
-		}
+		assert.Equal(t, len(queryResponse.Tests), numTests)
+		assert.Equal(t, queryResponse.Count, uint64(numTests))
 	})
 
-	t.Run("oar summary filtering works", func(t *testing.T) {
+	t.Run("docs filter work", func(t *testing.T) {
 		c, w := Fake.ginContext()
 
-		summaryFilter := generatedTests[0].Summary
+		genTest := generatedTests[0]
+		genTest2 := generatedTests[1]
 
 		query := TestQuery{
 			IDs:            testIDs,
-			Summaries:      []string{generatedTests[0].Summary},
+			Summaries:      nil,
 			Outcomes:       nil,
 			Analyses:       nil,
 			Resolutions:    nil,
@@ -510,7 +1554,7 @@ func TestTestController_GetTests(t *testing.T) {
 			CreatedAfter:   nil,
 			ModifiedBefore: nil,
 			ModifiedAfter:  nil,
-			Docs:           nil,
+			Docs:           []map[string]any{genTest.Doc, genTest2.Doc},
 		}
 		encodedQuery, err := encodeToBase64(query)
 		if err != nil {
@@ -535,31 +1579,154 @@ func TestTestController_GetTests(t *testing.T) {
 		}
 
 		for _, test := range queryResponse.Tests {
-			assert.Equal(t, test.Summary, summaryFilter)
+			passed := fmt.Sprint(test.Doc) == fmt.Sprint(genTest.Doc) || fmt.Sprint(test.Doc) == fmt.Sprint(genTest2.Doc)
+			assert.Equal(t, passed, true)
 		}
 	})
 
-	t.Run("filter limit works", func(t *testing.T) {
+	t.Run("doc filters work", func(t *testing.T) {
 		c, w := Fake.ginContext()
 
-		query := TestQuery{
-			IDs:            testIDs,
-			Summaries:      nil,
-			Outcomes:       nil,
-			Analyses:       nil,
-			Resolutions:    nil,
-			CreatedBefore:  nil,
-			CreatedAfter:   nil,
-			ModifiedBefore: nil,
-			ModifiedAfter:  nil,
-			Docs:           nil,
+		filteredTest := Fake.test()
+		filteredTest.Doc = map[string]any{"env": "staging", "duration": 45, "browsers": []string{"chrome", "edge"}}
+		filteredTestID, err := InsertTest(Fake.pgPool(), nil, filteredTest, Fake.definitionFields())
+		if err != nil {
+			t.Error("setup error", err)
 		}
-		encodedQuery, err := encodeToBase64(query)
+
+		encodedQuery, err := encodeToBase64(TestQuery{
+			IDs: append([]uint64{filteredTestID}, testIDs...),
+			DocFilters: []DocFilter{
+				{Path: "doc.env", Op: DocIn, Values: []any{"staging", "prod"}},
+				{Path: "doc.duration", Op: DocGreaterThan, Value: 30},
+				{Path: "doc.browsers", Op: DocContains, Value: "chrome"},
+				{Path: "doc.owner", Op: DocMissing},
+			},
+		})
 		if err != nil {
 			t.Error("setup error")
 		}
 
-		req, err := http.NewRequest(http.MethodGet, "/tests?limit=1001&query="+encodedQuery, nil)
+		req, err := http.NewRequest(http.MethodGet, "/tests?query="+encodedQuery, nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		c.Request = req
+
+		controller.GetTests(c)
+		assert.Equal(t, w.Code, 200)
+
+		var queryResponse TestQueryResponse
+
+		err = json.Unmarshal(w.Body.Bytes(), &queryResponse)
+		if err != nil {
+			t.Error("response error", err)
+		}
+
+		assert.Equal(t, len(queryResponse.Tests), 1)
+		assert.Equal(t, queryResponse.Tests[0].ID, filteredTestID)
+	})
+
+	t.Run("filter tree works", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		// Every generated test that is not the first test's outcome, or is the first test
+		encodedQuery, err := encodeToBase64(TestQuery{
+			IDs: testIDs,
+			Filter: &TestFilter{Or: []*TestFilter{
+				{Not: &TestFilter{Query: &TestQuery{Outcomes: []string{string(generatedTests[0].Outcome)}}}},
+				{Query: &TestQuery{IDs: []uint64{testIDs[0]}}},
+			}},
+		})
+		if err != nil {
+			t.Error("setup error")
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "/tests?query="+encodedQuery, nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		c.Request = req
+
+		controller.GetTests(c)
+		assert.Equal(t, w.Code, 200)
+
+		var queryResponse TestQueryResponse
+
+		err = json.Unmarshal(w.Body.Bytes(), &queryResponse)
+		if err != nil {
+			t.Error("response error", err)
+		}
+
+		for _, test := range queryResponse.Tests {
+			passed := test.ID == testIDs[0] || test.Outcome != generatedTests[0].Outcome
+			assert.Equal(t, passed, true)
+		}
+	})
+
+	t.Run("text query works", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		textQuery := fmt.Sprintf("id:%d,%d outcome:%s", testIDs[0], testIDs[1], generatedTests[0].Outcome)
+		req, err := http.NewRequest(http.MethodGet, "/tests?q="+url.QueryEscape(textQuery), nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		c.Request = req
+
+		controller.GetTests(c)
+		assert.Equal(t, w.Code, 200)
+
+		var queryResponse TestQueryResponse
+
+		err = json.Unmarshal(w.Body.Bytes(), &queryResponse)
+		if err != nil {
+			t.Error("response error", err)
+		}
+
+		if queryResponse.Count == 0 {
+			t.Error("text query did not return the first test")
+		}
+		for _, test := range queryResponse.Tests {
+			assert.Equal(t, test.ID == testIDs[0] || test.ID == testIDs[1], true)
+			assert.Equal(t, test.Outcome, generatedTests[0].Outcome)
+		}
+	})
+
+	t.Run("invalid text query returns 400 with position", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		req, err := http.NewRequest(http.MethodGet, "/tests?q="+url.QueryEscape("outcome:Failed colour:red"), nil)
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		c.Request = req
+
+		controller.GetTests(c)
+		assert.Equal(t, w.Code, 400)
+
+		var errResponse map[string]any
+
+		err = json.Unmarshal(w.Body.Bytes(), &errResponse)
+		if err != nil {
+			t.Error("response error", err)
+		}
+		assert.Equal(t, errResponse["position"], float64(15))
+	})
+
+	t.Run("text query and encoded query returns 400", func(t *testing.T) {
+		c, w := Fake.ginContext()
+
+		encodedQuery, err := encodeToBase64(TestQuery{IDs: testIDs})
+		if err != nil {
+			t.Error("setup error")
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "/tests?q=outcome:Failed&query="+encodedQuery, nil)
 		if err != nil {
 			t.Error("setup error", err)
 		}
@@ -570,7 +1737,7 @@ func TestTestController_GetTests(t *testing.T) {
 		assert.Equal(t, w.Code, 400)
 	})
 
-	t.Run("created before filter work", func(t *testing.T) {
+	t.Run("empty query doesn't fail", func(t *testing.T) {
 		c, w := Fake.ginContext()
 
 		tomorrow := time.Now().AddDate(0, 0, 1)
@@ -581,8 +1748,8 @@ func TestTestController_GetTests(t *testing.T) {
 			Outcomes:       nil,
 			Analyses:       nil,
 			Resolutions:    nil,
-			CreatedBefore:  &tomorrow,
-			CreatedAfter:   nil,
+			CreatedBefore:  nil,
+			CreatedAfter:   &tomorrow,
 			ModifiedBefore: nil,
 			ModifiedAfter:  nil,
 			Docs:           nil,
@@ -597,236 +1764,758 @@ func TestTestController_GetTests(t *testing.T) {
 			t.Error("setup error", err)
 		}
 
-		c.Request = req
+		c.Request = req
+
+		controller.GetTests(c)
+		assert.Equal(t, w.Code, 200)
+
+		var queryResponse TestQueryResponse
+
+		err = json.Unmarshal(w.Body.Bytes(), &queryResponse)
+		if err != nil {
+			t.Error("response error", err)
+		}
+
+		assert.Equal(t, queryResponse.Count, uint64(0))
+	})
+}
+
+// TestTestController_GetTestStats will ensure that the GetTestStats controller counts the tests that match a query
+// by group
+func TestTestController_GetTestStats(t *testing.T) {
+	controller := Fake.testController()
+	statsRun := strconv.FormatInt(time.Now().UnixNano(), 10) // Only the tests of this run match the query
+
+	testDetails := []struct {
+		outcome Outcome
+		env     string
+	}{{Failed, "prod"}, {Failed, "prod"}, {Passed, "prod"}, {Failed, "dev"}}
+	for _, details := range testDetails {
+		test := Fake.test()
+		test.Outcome = details.outcome
+		test.Analysis = NotAnalyzed
+		test.Doc = map[string]any{"statsRun": statsRun, "env": details.env}
+		if _, err := InsertTest(Fake.pgPool(), nil, test, Fake.definitionFields()); err != nil {
+			t.Fatal("setup error", err)
+		}
+	}
+
+	encodedQuery, err := encodeToBase64(TestQuery{Docs: []map[string]any{{"statsRun": statsRun}}})
+	if err != nil {
+		t.Fatal("setup error", err)
+	}
+
+	getStats := func(params string) (*httptest.ResponseRecorder, *TestStatsResponse) {
+		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodGet, "/stats?query="+encodedQuery+params, nil)
+		controller.GetTestStats(c)
+
+		statsResponse := &TestStatsResponse{}
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), statsResponse); err != nil {
+				t.Error("response error", err)
+			}
+		}
+		return w, statsResponse
+	}
+
+	t.Run("no group by has a single group", func(t *testing.T) {
+		w, statsResponse := getStats("")
+		assert.Equal(t, w.Code, 200)
+		assert.Equal(t, statsResponse.Total, uint64(4))
+		assert.Equal(t, statsResponse.Count, 1)
+		assert.Equal(t, statsResponse.Groups[0].Count, uint64(4))
+		assert.Equal(t, statsResponse.Groups[0].Failed, uint64(3))
+		assert.Equal(t, statsResponse.Groups[0].FailureRate, 0.75)
+	})
+
+	t.Run("group by a doc path", func(t *testing.T) {
+		w, statsResponse := getStats("&groupBy=doc.env")
+		assert.Equal(t, w.Code, 200)
+		assert.Equal(t, statsResponse.Total, uint64(4))
+		assert.Equal(t, statsResponse.GroupBy, []string{"doc.env"})
+		assert.Equal(t, statsResponse.Count, 2)
+
+		dev, prod := statsResponse.Groups[0], statsResponse.Groups[1]
+		assert.Equal(t, dev.Key, map[string]any{"doc.env": "dev"})
+		assert.Equal(t, dev.Count, uint64(1))
+		assert.Equal(t, dev.Ratio, 0.25)
+		assert.Equal(t, prod.Key, map[string]any{"doc.env": "prod"})
+		assert.Equal(t, prod.Count, uint64(3))
+		assert.Equal(t, prod.Passed, uint64(1))
+		assert.Equal(t, prod.Failed, uint64(2))
+	})
+
+	t.Run("group by outcome and time bucket", func(t *testing.T) {
+		w, statsResponse := getStats("&groupBy=outcome,created:year")
+		assert.Equal(t, w.Code, 200)
+		assert.Equal(t, statsResponse.Count, 2)
+
+		year := time.Now().UTC().Format("2006") + "-01-01T00:00:00Z"
+		assert.Equal(t, statsResponse.Groups[0].Key, map[string]any{"outcome": "Failed", "created:year": year})
+		assert.Equal(t, statsResponse.Groups[0].Count, uint64(3))
+		assert.Equal(t, statsResponse.Groups[1].Key, map[string]any{"outcome": "Passed", "created:year": year})
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		w, statsResponse := getStats("&groupBy=doc.env&limit=1")
+		assert.Equal(t, w.Code, 200)
+		assert.Equal(t, statsResponse.Total, uint64(4))
+		assert.Equal(t, statsResponse.Count, 1)
+	})
+
+	t.Run("invalid group by returns 400", func(t *testing.T) {
+		w, _ := getStats("&groupBy=password")
+		assert.Equal(t, w.Code, 400)
+	})
+
+	t.Run("limit too large returns 400", func(t *testing.T) {
+		w, _ := getStats("&limit=10001")
+		assert.Equal(t, w.Code, 400)
+	})
+}
+
+// TestTestController_GetTestQuality will ensure that the GetTestQuality controller computes the quality metrics of
+// the tests that match a query by group and flags the noisiest tests
+func TestTestController_GetTestQuality(t *testing.T) {
+	controller := Fake.testController()
+	qualityRun := strconv.FormatInt(time.Now().UnixNano(), 10) // Only the tests of this run match the query
+
+	testDetails := []struct {
+		summary  string
+		outcome  Outcome
+		analysis Analysis
+	}{
+		{"login works", Failed, FalsePositive},
+		{"login works", Failed, FalsePositive},
+		{"login works", Failed, TruePositive},
+		{"checkout works", Failed, FalsePositive},
+		{"checkout works", Passed, TrueNegative},
+		{"checkout works", Passed, FalseNegative},
+		{"search works", Passed, NotAnalyzed},
+	}
+	for _, details := range testDetails {
+		test := &Test{
+			Summary:  details.summary,
+			Outcome:  details.outcome,
+			Analysis: details.analysis,
+			Doc:      map[string]any{"qualityRun": qualityRun},
+		}
+		test.Clean()
+		if _, err := InsertTest(Fake.pgPool(), nil, test, Fake.definitionFields()); err != nil {
+			t.Fatal("setup error", err)
+		}
+	}
+
+	encodedQuery, err := encodeToBase64(TestQuery{Docs: []map[string]any{{"qualityRun": qualityRun}}})
+	if err != nil {
+		t.Fatal("setup error", err)
+	}
+
+	getQuality := func(params string) (*httptest.ResponseRecorder, *TestQualityResponse) {
+		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodGet, "/quality?query="+encodedQuery+params, nil)
+		controller.GetTestQuality(c)
+
+		qualityResponse := &TestQualityResponse{}
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), qualityResponse); err != nil {
+				t.Error("response error", err)
+			}
+		}
+		return w, qualityResponse
+	}
+
+	t.Run("overall", func(t *testing.T) {
+		w, qualityResponse := getQuality("")
+		assert.Equal(t, w.Code, 200)
+		assert.Equal(t, qualityResponse.Total, uint64(7))
+		assert.Equal(t, qualityResponse.Count, 1)
+
+		overall := qualityResponse.Groups[0]
+		assert.Equal(t, overall.ConfusionMatrix, ConfusionMatrix{
+			TruePositive:  1,
+			FalsePositive: 3,
+			TrueNegative:  1,
+			FalseNegative: 1,
+		})
+		assert.Equal(t, overall.Analyzed, uint64(6))
+		assert.Equal(t, overall.NotAnalyzed, uint64(1))
+		assert.Equal(t, *overall.Precision, 0.25)
+		assert.Equal(t, *overall.Recall, 0.5)
+		assert.Equal(t, *overall.FalsePositiveRate, 0.75)
+	})
+
+	t.Run("by summary", func(t *testing.T) {
+		w, qualityResponse := getQuality("&groupBy=summary")
+		assert.Equal(t, w.Code, 200)
+		assert.Equal(t, qualityResponse.Count, 3)
+
+		search := qualityResponse.Groups[2]
+		assert.Equal(t, search.Key, map[string]any{"summary": "search works"})
+		assert.Equal(t, search.Precision == nil, true)
+		assert.Equal(t, *search.Coverage, 0.0)
+	})
+
+	t.Run("noisiest tests", func(t *testing.T) {
+		w, qualityResponse := getQuality("&noisiest=1")
+		assert.Equal(t, w.Code, 200)
+		assert.Equal(t, len(qualityResponse.Noisiest), 1)
+		assert.Equal(t, *qualityResponse.Noisiest[0], NoisyTest{
+			Summary:        "login works",
+			Count:          3,
+			Failed:         3,
+			FalsePositives: 2,
+			FalseAlarmRate: 2.0 / 3.0,
+		})
+
+		w, qualityResponse = getQuality("")
+		assert.Equal(t, len(qualityResponse.Noisiest), 2)
+		assert.Equal(t, qualityResponse.Noisiest[1].Summary, "checkout works")
+	})
+
+	t.Run("noisiest too large returns 400", func(t *testing.T) {
+		w, _ := getQuality("&noisiest=101")
+		assert.Equal(t, w.Code, 400)
+	})
+}
+
+// TestTestController_FlakyTests will ensure that the GetFlakyTests controller finds the tests that flip between Passed
+// and Failed, and that the MarkFlakyTests controller adds a flaky marker to their results
+func TestTestController_FlakyTests(t *testing.T) {
+	controller := Fake.testController()
+	flakyRun := strconv.FormatInt(time.Now().UnixNano(), 10) // Only the tests of this run match the query
 
-		controller.GetTests(c)
-		assert.Equal(t, w.Code, 200)
+	outcomes := map[string][]Outcome{
+		"login works":    {Passed, Failed, Passed, Failed, Passed, Failed},
+		"checkout works": {Passed, Passed, Passed, Passed, Passed, Passed},
+		"search works":   {Failed, Passed, Failed},
+	}
+	for _, summary := range []string{"login works", "checkout works", "search works"} {
+		for _, outcome := range outcomes[summary] {
+			test := &Test{Summary: summary, Outcome: outcome, Doc: map[string]any{"flakyRun": flakyRun}}
+			test.Clean()
+			if _, err := InsertTest(Fake.pgPool(), nil, test, Fake.definitionFields()); err != nil {
+				t.Fatal("setup error", err)
+			}
+		}
+	}
 
-		var queryResponse TestQueryResponse
+	encodedQuery, err := encodeToBase64(TestQuery{Docs: []map[string]any{{"flakyRun": flakyRun}}})
+	if err != nil {
+		t.Fatal("setup error", err)
+	}
 
-		err = json.Unmarshal(w.Body.Bytes(), &queryResponse)
-		if err != nil {
-			t.Error("response error", err)
+	analyze := func(
+		method string,
+		handler gin.HandlerFunc,
+		params string,
+	) (*httptest.ResponseRecorder, *FlakyTestsResponse) {
+		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(method, "/flaky?query="+encodedQuery+params, nil)
+		handler(c)
+
+		flakyResponse := &FlakyTestsResponse{}
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), flakyResponse); err != nil {
+				t.Error("response error", err)
+			}
 		}
+		return w, flakyResponse
+	}
 
-		assert.Equal(t, len(queryResponse.Tests), numTests)
-		assert.Equal(t, queryResponse.Count, uint64(numTests))
+	t.Run("get flaky tests", func(t *testing.T) {
+		w, flakyResponse := analyze(http.MethodGet, controller.GetFlakyTests, "")
+		assert.Equal(t, w.Code, 200)
+		assert.Equal(t, flakyResponse.Count, 1)
+		assert.Equal(t, flakyResponse.Marked, uint64(0))
+
+		login := flakyResponse.Tests[0]
+		assert.Equal(t, login.Identity, map[string]any{"summary": "login works"})
+		assert.Equal(t, login.Runs, uint64(6))
+		assert.Equal(t, login.Failures, uint64(3))
+		assert.Equal(t, login.Flips, uint64(5))
+		assert.Equal(t, login.Score, 1.0)
+		assert.Equal(t, login.LastOutcome, Failed)
+		assert.Equal(t, len(login.TestIDs), 6)
 	})
 
-	t.Run("created after filter work", func(t *testing.T) {
-		c, w := Fake.ginContext()
+	t.Run("fewer runs than min runs", func(t *testing.T) {
+		w, flakyResponse := analyze(http.MethodGet, controller.GetFlakyTests, "&minRuns=3")
+		assert.Equal(t, w.Code, 200)
+		assert.Equal(t, flakyResponse.Count, 2)
+		assert.Equal(t, flakyResponse.Tests[1].Identity, map[string]any{"summary": "search works"})
+	})
 
-		yesterday := time.Now().AddDate(0, 0, -1)
+	t.Run("only the most recent runs", func(t *testing.T) {
+		w, flakyResponse := analyze(http.MethodGet, controller.GetFlakyTests, "&runs=5&minRuns=5")
+		assert.Equal(t, w.Code, 200)
+		assert.Equal(t, flakyResponse.Tests[0].Runs, uint64(5))
+		assert.Equal(t, flakyResponse.Tests[0].Failures, uint64(3))
+	})
 
-		query := TestQuery{
-			IDs:            testIDs,
-			Summaries:      nil,
-			Outcomes:       nil,
-			Analyses:       nil,
-			Resolutions:    nil,
-			CreatedBefore:  nil,
-			CreatedAfter:   &yesterday,
-			ModifiedBefore: nil,
-			ModifiedAfter:  nil,
-			Docs:           nil,
+	t.Run("invalid identity returns 400", func(t *testing.T) {
+		w, _ := analyze(http.MethodGet, controller.GetFlakyTests, "&identity=outcome")
+		assert.Equal(t, w.Code, 400)
+	})
+
+	t.Run("mark flaky tests", func(t *testing.T) {
+		w, flakyResponse := analyze(http.MethodPost, controller.MarkFlakyTests, "")
+		assert.Equal(t, w.Code, 200)
+		assert.Equal(t, flakyResponse.Marked, uint64(6))
+
+		for _, id := range flakyResponse.Tests[0].TestIDs {
+			test, err := SelectTest(Fake.pgPool(), id, false)
+			if err != nil {
+				t.Fatal("response error", err)
+			}
+			assert.Equal(t, test.Doc["flaky"], true)
 		}
-		encodedQuery, err := encodeToBase64(query)
-		if err != nil {
-			t.Error("setup error")
+	})
+}
+
+// TestTestController_TestDefinitions will ensure that results are linked to a test definition by their identity
+// fields, and that the GetTestDefinitions, GetTestDefinition and GetTestDefinitionTests controllers return the
+// definitions with their latest and historical results
+func TestTestController_TestDefinitions(t *testing.T) {
+	controller := Fake.testController()
+	controller.DefinitionFields = []string{"summary", "doc.nodeid"}
+	definitionRun := strconv.FormatInt(time.Now().UnixNano(), 10) // Only the definitions of this run match the summary
+
+	newTest := func(nodeID string, outcome Outcome) *Test {
+		test := &Test{
+			Summary: "login works " + definitionRun,
+			Outcome: outcome,
+			Doc:     map[string]any{"nodeid": nodeID},
 		}
+		test.Clean()
+		return test
+	}
+	testIDs, err := InsertTests(Fake.pgPool(), nil, []*Test{
+		newTest("test_login.py::test_login", Passed),
+		newTest("test_login.py::test_login", Failed),
+		newTest("test_sso.py::test_login", Passed),
+	}, controller.DefinitionFields)
+	if err != nil {
+		t.Fatal("setup error", err)
+	}
+	latestTestID, err := InsertTest(
+		Fake.pgPool(),
+		nil,
+		newTest("test_login.py::test_login", Passed),
+		controller.DefinitionFields,
+	)
+	if err != nil {
+		t.Fatal("setup error", err)
+	}
 
-		req, err := http.NewRequest(http.MethodGet, "/tests?query="+encodedQuery, nil)
-		if err != nil {
-			t.Error("setup error", err)
+	var definitionIDs []uint64
+	for _, testID := range append(testIDs, latestTestID) {
+		test, err := SelectTest(Fake.pgPool(), testID, false)
+		if err != nil || test.DefinitionID == nil {
+			t.Fatal("setup error", err)
 		}
+		definitionIDs = append(definitionIDs, *test.DefinitionID)
+	}
+	loginID, ssoID := definitionIDs[0], definitionIDs[2]
 
-		c.Request = req
+	t.Run("results are linked by identity", func(t *testing.T) {
+		assert.Equal(t, definitionIDs, []uint64{loginID, loginID, ssoID, loginID})
+		if loginID == ssoID {
+			t.Error("results with a different identity are linked to the same definition")
+		}
+	})
 
-		controller.GetTests(c)
+	t.Run("get test definitions", func(t *testing.T) {
+		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodGet, "/definitions?summary="+definitionRun, nil)
+		controller.GetTestDefinitions(c)
 		assert.Equal(t, w.Code, 200)
 
-		var queryResponse TestQueryResponse
-
-		err = json.Unmarshal(w.Body.Bytes(), &queryResponse)
-		if err != nil {
-			t.Error("response error", err)
+		definitionsResponse := &TestDefinitionsResponse{}
+		if err := json.Unmarshal(w.Body.Bytes(), definitionsResponse); err != nil {
+			t.Fatal("response error", err)
 		}
+		assert.Equal(t, definitionsResponse.Count, 2)
+
+		// The login definition was seen last
+		login := definitionsResponse.Definitions[0]
+		assert.Equal(t, login.ID, loginID)
+		assert.Equal(t, login.Identity, map[string]any{
+			"summary":    "login works " + definitionRun,
+			"doc.nodeid": "test_login.py::test_login",
+		})
+		assert.Equal(t, login.Results, uint64(3))
+		assert.Equal(t, login.Latest.ID, latestTestID)
+		assert.Equal(t, definitionsResponse.Definitions[1].Results, uint64(1))
+	})
 
-		assert.Equal(t, len(queryResponse.Tests), numTests)
-		assert.Equal(t, queryResponse.Count, uint64(numTests))
+	t.Run("get test definition", func(t *testing.T) {
+		c, w := Fake.ginContext()
+		c.Params = gin.Params{{Key: "id", Value: strconv.FormatUint(ssoID, 10)}}
+		controller.GetTestDefinition(c)
+		assert.Equal(t, w.Code, 200)
+
+		definition := &TestDefinition{}
+		if err := json.Unmarshal(w.Body.Bytes(), definition); err != nil {
+			t.Fatal("response error", err)
+		}
+		assert.Equal(t, definition.Results, uint64(1))
+		assert.Equal(t, definition.Latest.ID, testIDs[2])
 	})
 
-	t.Run("modified before filter work", func(t *testing.T) {
+	t.Run("unknown test definition returns 404", func(t *testing.T) {
 		c, w := Fake.ginContext()
+		c.Params = gin.Params{{Key: "id", Value: strconv.FormatUint(loginID+1000000, 10)}}
+		controller.GetTestDefinition(c)
+		assert.Equal(t, w.Code, 404)
+
+		c, w = Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodGet, "/definition/0/tests", nil)
+		c.Params = gin.Params{{Key: "id", Value: strconv.FormatUint(loginID+1000000, 10)}}
+		controller.GetTestDefinitionTests(c)
+		assert.Equal(t, w.Code, 404)
+	})
 
-		tomorrow := time.Now().AddDate(0, 0, 1)
+	t.Run("get test definition tests", func(t *testing.T) {
+		getTests := func(params string) *TestQueryResponse {
+			c, w := Fake.ginContext()
+			c.Request = httptest.NewRequest(http.MethodGet, "/definition/0/tests"+params, nil)
+			c.Params = gin.Params{{Key: "id", Value: strconv.FormatUint(loginID, 10)}}
+			controller.GetTestDefinitionTests(c)
+			assert.Equal(t, w.Code, 200)
+
+			queryResponse := &TestQueryResponse{}
+			if err := json.Unmarshal(w.Body.Bytes(), queryResponse); err != nil {
+				t.Fatal("response error", err)
+			}
+			return queryResponse
+		}
 
-		query := TestQuery{
-			IDs:            testIDs,
-			Summaries:      nil,
-			Outcomes:       nil,
-			Analyses:       nil,
-			Resolutions:    nil,
-			CreatedBefore:  nil,
-			CreatedAfter:   nil,
-			ModifiedBefore: &tomorrow,
-			ModifiedAfter:  nil,
-			Docs:           nil,
+		queryResponse := getTests("")
+		assert.Equal(t, queryResponse.Total, uint64(3))
+		assert.Equal(t, queryResponse.Tests[0].ID, latestTestID)
+		assert.Equal(t, queryResponse.Tests[2].ID, testIDs[0])
+
+		queryResponse = getTests("?q=outcome:Failed")
+		assert.Equal(t, queryResponse.Total, uint64(1))
+		assert.Equal(t, queryResponse.Tests[0].ID, testIDs[1])
+	})
+
+	t.Run("enriched results are not linked again", func(t *testing.T) {
+		definition, err := QueryTestDefinition(Fake.pgPool(), ssoID)
+		if err != nil {
+			t.Fatal("setup error", err)
 		}
-		encodedQuery, err := encodeToBase64(query)
+		test, err := SelectTest(Fake.pgPool(), testIDs[2], false)
 		if err != nil {
-			t.Error("setup error")
+			t.Fatal("setup error", err)
+		}
+		test.Analysis = TrueNegative
+		if err = UpdateTest(Fake.pgPool(), nil, test, controller.DefinitionFields); err != nil {
+			t.Fatal(err)
 		}
 
-		req, err := http.NewRequest(http.MethodGet, "/tests?query="+encodedQuery, nil)
+		enrichedDefinition, err := QueryTestDefinition(Fake.pgPool(), ssoID)
 		if err != nil {
-			t.Error("setup error", err)
+			t.Fatal("response error", err)
 		}
+		assert.Equal(t, enrichedDefinition.LastSeen, definition.LastSeen)
+		assert.Equal(t, *enrichedDefinition.Latest.DefinitionID, ssoID)
+	})
 
-		c.Request = req
-
-		controller.GetTests(c)
-		assert.Equal(t, w.Code, 200)
-
-		var queryResponse TestQueryResponse
+	t.Run("updated results are linked again", func(t *testing.T) {
+		test, err := SelectTest(Fake.pgPool(), latestTestID, false)
+		if err != nil {
+			t.Fatal("setup error", err)
+		}
+		test.Doc["nodeid"] = "test_sso.py::test_login"
+		if err = UpdateTest(Fake.pgPool(), nil, test, controller.DefinitionFields); err != nil {
+			t.Fatal(err)
+		}
 
-		err = json.Unmarshal(w.Body.Bytes(), &queryResponse)
+		test, err = SelectTest(Fake.pgPool(), latestTestID, false)
 		if err != nil {
-			t.Error("response error", err)
+			t.Fatal("response error", err)
 		}
+		assert.Equal(t, *test.DefinitionID, ssoID)
+	})
+}
 
-		assert.Equal(t, len(queryResponse.Tests), numTests)
-		assert.Equal(t, queryResponse.Count, uint64(numTests))
+// TestAPIKeyController will ensure that API keys can be created, listed and revoked, and that the key itself is only
+// returned when it is created
+func TestAPIKeyController(t *testing.T) {
+	controller := &APIKeyController{DBPool: Fake.pgPool()}
+
+	c, w := Fake.ginContext()
+	c.Request = httptest.NewRequest(
+		http.MethodPost,
+		"/keys",
+		strings.NewReader(`{"name": "nightly ci", "scopes": ["ingest", "read"]}`),
+	)
+	controller.CreateAPIKey(c)
+	assert.Equal(t, w.Code, 201)
+
+	var createResponse APIKeyCreateResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &createResponse); err != nil {
+		t.Error("response error", err)
+	}
+	assert.Equal(t, createResponse.Name, "nightly ci")
+	assert.Equal(t, createResponse.Scopes, []Scope{ScopeIngest, ScopeRead})
+	assert.Equal(t, strings.HasPrefix(createResponse.Key, createResponse.Prefix), true)
+
+	t.Run("invalid scope returns 400", func(t *testing.T) {
+		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(
+			http.MethodPost,
+			"/keys",
+			strings.NewReader(`{"name": "bad", "scopes": ["superuser"]}`),
+		)
+		controller.CreateAPIKey(c)
+		assert.Equal(t, w.Code, 400)
 	})
 
-	t.Run("modified after filter work", func(t *testing.T) {
+	t.Run("list does not return the key", func(t *testing.T) {
 		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodGet, "/keys", nil)
+		controller.GetAPIKeys(c)
+		assert.Equal(t, w.Code, 200)
+		assert.Equal(t, strings.Contains(w.Body.String(), createResponse.Key), false)
+		assert.Equal(t, strings.Contains(w.Body.String(), createResponse.Prefix), true)
+	})
 
-		yesterday := time.Now().AddDate(0, 0, -1)
+	t.Run("revoke", func(t *testing.T) {
+		keyIDParam := gin.Params{{Key: "id", Value: strconv.FormatUint(createResponse.ID, 10)}}
 
-		query := TestQuery{
-			IDs:            testIDs,
-			Summaries:      nil,
-			Outcomes:       nil,
-			Analyses:       nil,
-			Resolutions:    nil,
-			CreatedBefore:  nil,
-			CreatedAfter:   nil,
-			ModifiedBefore: nil,
-			ModifiedAfter:  &yesterday,
-			Docs:           nil,
-		}
-		encodedQuery, err := encodeToBase64(query)
-		if err != nil {
-			t.Error("setup error")
+		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodDelete, "/keys/"+keyIDParam[0].Value, nil)
+		c.Params = keyIDParam
+		controller.RevokeAPIKey(c)
+		assert.Equal(t, w.Code, 200)
+
+		var revokedKey APIKey
+		if err := json.Unmarshal(w.Body.Bytes(), &revokedKey); err != nil {
+			t.Error("response error", err)
 		}
+		assert.Equal(t, revokedKey.Revoked != nil, true)
+
+		// Revoked keys are only listed with includeRevoked
+		c, w = Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodGet, "/keys", nil)
+		controller.GetAPIKeys(c)
+		assert.Equal(t, strings.Contains(w.Body.String(), createResponse.Prefix), false)
+
+		c, w = Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodGet, "/keys?includeRevoked=true", nil)
+		controller.GetAPIKeys(c)
+		assert.Equal(t, strings.Contains(w.Body.String(), createResponse.Prefix), true)
+
+		// Revoking again is not found
+		c, w = Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodDelete, "/keys/"+keyIDParam[0].Value, nil)
+		c.Params = keyIDParam
+		controller.RevokeAPIKey(c)
+		assert.Equal(t, w.Code, 404)
+	})
+}
 
-		req, err := http.NewRequest(http.MethodGet, "/tests?query="+encodedQuery, nil)
-		if err != nil {
-			t.Error("setup error", err)
+// TestRunController will ensure that results can be attached to a run until it is finished, that the run summary has
+// the totals of its results and that two runs can be compared
+func TestRunController(t *testing.T) {
+	controller := &RunController{DBPool: Fake.pgPool()}
+	testController := Fake.testController()
+	runMarker := strconv.FormatInt(time.Now().UnixNano(), 10) // Only the runs of this test run match the commit
+
+	createRun := func(name string) *Run {
+		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(
+			http.MethodPost,
+			"/run",
+			strings.NewReader(fmt.Sprintf(
+				`{"name": " %s ", "branch": "main", "commit": "%s", "environment": "ci", "doc": {"build": 1234}}`,
+				name,
+				runMarker,
+			)),
+		)
+		controller.CreateRun(c)
+		assert.Equal(t, w.Code, 201)
+
+		run := &Run{}
+		if err := json.Unmarshal(w.Body.Bytes(), run); err != nil {
+			t.Fatal("response error", err)
+		}
+		return run
+	}
+	createTests := func(runID uint64, outcomes map[string]Outcome) int {
+		var tests []string
+		for summary, outcome := range outcomes {
+			tests = append(tests, fmt.Sprintf(
+				`{"summary": "%s %s", "outcome": "%s", "runId": %d}`,
+				summary,
+				runMarker,
+				outcome,
+				runID,
+			))
 		}
 
-		c.Request = req
+		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodPost, "/tests", strings.NewReader("["+strings.Join(tests, ",")+"]"))
+		testController.CreateTests(c)
+		return w.Code
+	}
+	runParam := func(runID uint64) gin.Params {
+		return gin.Params{{Key: "id", Value: strconv.FormatUint(runID, 10)}}
+	}
 
-		controller.GetTests(c)
-		assert.Equal(t, w.Code, 200)
+	baseRun := createRun("nightly")
+	assert.Equal(t, baseRun.Name, "nightly")
+	assert.Equal(t, baseRun.Doc, map[string]any{"build": float64(1234)})
+	assert.Equal(t, baseRun.Finished == nil, true)
+	headRun := createRun("nightly")
 
-		var queryResponse TestQueryResponse
+	t.Run("blank name returns 400", func(t *testing.T) {
+		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodPost, "/run", strings.NewReader(`{"name": " "}`))
+		controller.CreateRun(c)
+		assert.Equal(t, w.Code, 400)
+	})
 
-		err = json.Unmarshal(w.Body.Bytes(), &queryResponse)
-		if err != nil {
-			t.Error("response error", err)
-		}
+	code := createTests(baseRun.ID, map[string]Outcome{"checkout": Passed, "login": Failed, "search": Passed})
+	assert.Equal(t, code, 201)
+	code = createTests(headRun.ID, map[string]Outcome{"checkout": Failed, "login": Passed, "profile": Passed})
+	assert.Equal(t, code, 201)
 
-		assert.Equal(t, len(queryResponse.Tests), numTests)
-		assert.Equal(t, queryResponse.Count, uint64(numTests))
+	t.Run("unknown run returns 400", func(t *testing.T) {
+		assert.Equal(t, createTests(headRun.ID+1000000, map[string]Outcome{"login": Passed}), 400)
 	})
 
-	t.Run("docs filter work", func(t *testing.T) {
+	t.Run("import into a run", func(t *testing.T) {
 		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(
+			http.MethodPost,
+			"/import/junit?runId="+strconv.FormatUint(headRun.ID, 10),
+			strings.NewReader(junitReport),
+		)
+		testController.ImportJUnit(c)
+		assert.Equal(t, w.Code, 201)
 
-		genTest := generatedTests[0]
-		genTest2 := generatedTests[1]
+		c, w = Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodPost, "/import/junit?runId=abc", strings.NewReader(junitReport))
+		testController.ImportJUnit(c)
+		assert.Equal(t, w.Code, 400)
+	})
 
-		query := TestQuery{
-			IDs:            testIDs,
-			Summaries:      nil,
-			Outcomes:       nil,
-			Analyses:       nil,
-			Resolutions:    nil,
-			CreatedBefore:  nil,
-			CreatedAfter:   nil,
-			ModifiedBefore: nil,
-			ModifiedAfter:  nil,
-			Docs:           []map[string]any{genTest.Doc, genTest2.Doc},
-		}
-		encodedQuery, err := encodeToBase64(query)
-		if err != nil {
-			t.Error("setup error")
-		}
+	t.Run("get run", func(t *testing.T) {
+		c, w := Fake.ginContext()
+		c.Params = runParam(baseRun.ID)
+		controller.GetRun(c)
+		assert.Equal(t, w.Code, 200)
 
-		req, err := http.NewRequest(http.MethodGet, "/tests?query="+encodedQuery, nil)
-		if err != nil {
-			t.Error("setup error", err)
+		run := &RunSummary{}
+		if err := json.Unmarshal(w.Body.Bytes(), run); err != nil {
+			t.Fatal("response error", err)
 		}
+		assert.Equal(t, run.Total, uint64(3))
+		assert.Equal(t, run.Passed, uint64(2))
+		assert.Equal(t, run.Failed, uint64(1))
+		assert.Equal(t, run.NotAnalyzed, uint64(3))
+		assert.Equal(t, run.Branch, "main")
+
+		c, w = Fake.ginContext()
+		c.Params = runParam(headRun.ID + 1000000)
+		controller.GetRun(c)
+		assert.Equal(t, w.Code, 404)
+	})
 
-		c.Request = req
-
-		controller.GetTests(c)
+	t.Run("get runs", func(t *testing.T) {
+		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodGet, "/runs?branch=main&commit="+runMarker, nil)
+		controller.GetRuns(c)
 		assert.Equal(t, w.Code, 200)
 
-		var queryResponse TestQueryResponse
+		runsResponse := &RunsResponse{}
+		if err := json.Unmarshal(w.Body.Bytes(), runsResponse); err != nil {
+			t.Fatal("response error", err)
+		}
+		assert.Equal(t, runsResponse.Count, 2)
+		assert.Equal(t, runsResponse.Runs[0].ID, headRun.ID)
+		// The 3 tests and the 3 JUnit test cases that are not skipped
+		assert.Equal(t, runsResponse.Runs[0].Total, uint64(6))
+	})
 
-		err = json.Unmarshal(w.Body.Bytes(), &queryResponse)
+	t.Run("query tests by run", func(t *testing.T) {
+		encodedQuery, err := encodeToBase64(&TestQuery{RunIDs: []uint64{baseRun.ID}})
 		if err != nil {
-			t.Error("response error", err)
+			t.Fatal("setup error", err)
 		}
 
+		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodGet, "/tests?query="+encodedQuery, nil)
+		testController.GetTests(c)
+		assert.Equal(t, w.Code, 200)
+
+		queryResponse := &TestQueryResponse{}
+		if err := json.Unmarshal(w.Body.Bytes(), queryResponse); err != nil {
+			t.Fatal("response error", err)
+		}
+		assert.Equal(t, queryResponse.Total, uint64(3))
 		for _, test := range queryResponse.Tests {
-			passed := fmt.Sprint(test.Doc) == fmt.Sprint(genTest.Doc) || fmt.Sprint(test.Doc) == fmt.Sprint(genTest2.Doc)
-			assert.Equal(t, passed, true)
+			assert.Equal(t, *test.RunID, baseRun.ID)
 		}
 	})
 
-	t.Run("empty query doesn't fail", func(t *testing.T) {
+	t.Run("finish run", func(t *testing.T) {
 		c, w := Fake.ginContext()
+		c.Params = runParam(baseRun.ID)
+		controller.FinishRun(c)
+		assert.Equal(t, w.Code, 200)
 
-		tomorrow := time.Now().AddDate(0, 0, 1)
-
-		query := TestQuery{
-			IDs:            testIDs,
-			Summaries:      nil,
-			Outcomes:       nil,
-			Analyses:       nil,
-			Resolutions:    nil,
-			CreatedBefore:  nil,
-			CreatedAfter:   &tomorrow,
-			ModifiedBefore: nil,
-			ModifiedAfter:  nil,
-			Docs:           nil,
-		}
-		encodedQuery, err := encodeToBase64(query)
-		if err != nil {
-			t.Error("setup error")
+		run := &RunSummary{}
+		if err := json.Unmarshal(w.Body.Bytes(), run); err != nil {
+			t.Fatal("response error", err)
 		}
+		assert.Equal(t, run.Finished != nil, true)
+		assert.Equal(t, run.Total, uint64(3))
 
-		req, err := http.NewRequest(http.MethodGet, "/tests?query="+encodedQuery, nil)
-		if err != nil {
-			t.Error("setup error", err)
-		}
+		// Finished runs cannot be finished again or have results attached to them
+		c, w = Fake.ginContext()
+		c.Params = runParam(baseRun.ID)
+		controller.FinishRun(c)
+		assert.Equal(t, w.Code, 404)
 
-		c.Request = req
+		assert.Equal(t, createTests(baseRun.ID, map[string]Outcome{"signup": Passed}), 400)
+	})
 
-		controller.GetTests(c)
+	t.Run("compare runs", func(t *testing.T) {
+		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(
+			http.MethodGet,
+			fmt.Sprintf("/runs/compare?base=%d&head=%d", baseRun.ID, headRun.ID),
+			nil,
+		)
+		controller.CompareRuns(c)
 		assert.Equal(t, w.Code, 200)
 
-		var queryResponse TestQueryResponse
-
-		err = json.Unmarshal(w.Body.Bytes(), &queryResponse)
-		if err != nil {
-			t.Error("response error", err)
+		comparison := &RunComparison{}
+		if err := json.Unmarshal(w.Body.Bytes(), comparison); err != nil {
+			t.Fatal("response error", err)
 		}
-
-		assert.Equal(t, queryResponse.Count, uint64(0))
+		assert.Equal(t, comparison.Base.ID, baseRun.ID)
+		assert.Equal(t, comparison.Head.ID, headRun.ID)
+		assert.Equal(t, len(comparison.Regressions), 1)
+		assert.Equal(t, comparison.Regressions[0].Summary, "checkout "+runMarker)
+		assert.Equal(t, len(comparison.Fixed), 1)
+		assert.Equal(t, comparison.Fixed[0].Summary, "login "+runMarker)
+		assert.Equal(t, len(comparison.Removed), 1)
+		assert.Equal(t, len(comparison.Added), 4) // profile and the 3 JUnit test cases
+
+		c, w = Fake.ginContext()
+		c.Request = httptest.NewRequest(
+			http.MethodGet,
+			fmt.Sprintf("/runs/compare?base=%d&head=%d", baseRun.ID, headRun.ID+1000000),
+			nil,
+		)
+		controller.CompareRuns(c)
+		assert.Equal(t, w.Code, 404)
 	})
 }
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// CORS applies the CORSConfig to route groups. Requests from an allowed origin get the CORS headers of the policy of
+// their route group, and preflight requests are answered with the policy of the route group of the method they ask
+// for, as a path like /tests can be in several route groups.
+type CORS struct {
+	config *CORSConfig
+	// routes is the policy of every method of every path, by path then method
+	routes map[string]map[string]*CORSPolicy
+}
+
+// NewCORS will return a CORS for a CORSConfig. Will return an error if a policy is not valid.
+func NewCORS(config *CORSConfig) (*CORS, error) {
+	if err := config.CORSPolicy.Validate(); err != nil {
+		return nil, err
+	}
+	for name, policy := range config.Groups {
+		if err := policy.Validate(); err != nil {
+			return nil, fmt.Errorf("%s CORS policy: %w", name, err)
+		}
+	}
+
+	return &CORS{config: config, routes: map[string]map[string]*CORSPolicy{}}, nil
+}
+
+// Group will create a route group of the router with the CORS policy of the name. The policy is applied before the
+// handlers of the group, so that responses that are rejected by them can still be read by the browser.
+func (cors *CORS) Group(router *gin.Engine, name string, handlers ...gin.HandlerFunc) *CORSGroup {
+	policy := &cors.config.CORSPolicy
+	if groupPolicy, ok := cors.config.Groups[name]; ok {
+		policy = groupPolicy
+	}
+
+	handlers = append([]gin.HandlerFunc{policy.Handler()}, handlers...)
+	return &CORSGroup{RouterGroup: router.Group("", handlers...), router: router, cors: cors, policy: policy}
+}
+
+// addRoute will record the policy of a route, and handle the preflight requests of its path
+func (cors *CORS) addRoute(router *gin.Engine, method string, path string, policy *CORSPolicy) {
+	if _, ok := cors.routes[path]; !ok {
+		cors.routes[path] = map[string]*CORSPolicy{}
+		router.OPTIONS(path, cors.preflight)
+	}
+	cors.routes[path][method] = policy
+}
+
+// preflight is the handler of OPTIONS requests. A preflight request is answered with the policy of the route that it
+// asks for. It will respond with a http.StatusForbidden (403) status code if the origin, method or headers are not
+// allowed, or a http.StatusMethodNotAllowed (405) status code if the path does not have the method.
+// OPTIONS requests that are not preflight requests get the methods of the path.
+func (cors *CORS) preflight(c *gin.Context) {
+	routes := cors.routes[c.FullPath()]
+	allowedMethods := append(maps.Keys(routes), http.MethodOptions)
+	slices.Sort(allowedMethods)
+
+	origin := c.GetHeader("Origin")
+	method := c.GetHeader("Access-Control-Request-Method")
+	if origin == "" || method == "" {
+		c.Header("Allow", strings.Join(allowedMethods, ", "))
+		c.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+
+	// The response depends on which route group the method is in
+	vary(c, "Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers")
+	policy, ok := routes[method]
+	if !ok {
+		c.Header("Allow", strings.Join(allowedMethods, ", "))
+		c.AbortWithStatus(http.StatusMethodNotAllowed)
+		return
+	}
+	allowOrigin, ok := policy.AllowOrigin(origin)
+	if !ok || !policy.AllowsMethod(method) || !policy.AllowsHeaders(c.GetHeader("Access-Control-Request-Headers")) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	c.Header("Access-Control-Allow-Origin", allowOrigin)
+	c.Header("Access-Control-Allow-Methods", strings.Join(policy.AllowMethods, ", "))
+	if len(policy.AllowHeaders) > 0 {
+		c.Header("Access-Control-Allow-Headers", strings.Join(policy.AllowHeaders, ", "))
+	}
+	if policy.AllowCredentials {
+		c.Header("Access-Control-Allow-Credentials", "true")
+	}
+	if policy.MaxAge > 0 {
+		c.Header("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+	}
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+// CORSGroup is a route group with a CORS policy. Its routes are recorded, so that their preflight requests are
+// answered with the policy of the group.
+type CORSGroup struct {
+	*gin.RouterGroup
+	router *gin.Engine
+	cors   *CORS
+	policy *CORSPolicy
+}
+
+// Handle will add a route to the group, see gin.RouterGroup.Handle
+func (group *CORSGroup) Handle(method string, relativePath string, handlers ...gin.HandlerFunc) gin.IRoutes {
+	group.cors.addRoute(group.router, method, path.Join(group.BasePath(), relativePath), group.policy)
+	return group.RouterGroup.Handle(method, relativePath, handlers...)
+}
+
+// GET will add a GET route to the group
+func (group *CORSGroup) GET(relativePath string, handlers ...gin.HandlerFunc) gin.IRoutes {
+	return group.Handle(http.MethodGet, relativePath, handlers...)
+}
+
+// POST will add a POST route to the group
+func (group *CORSGroup) POST(relativePath string, handlers ...gin.HandlerFunc) gin.IRoutes {
+	return group.Handle(http.MethodPost, relativePath, handlers...)
+}
+
+// PATCH will add a PATCH route to the group
+func (group *CORSGroup) PATCH(relativePath string, handlers ...gin.HandlerFunc) gin.IRoutes {
+	return group.Handle(http.MethodPatch, relativePath, handlers...)
+}
+
+// DELETE will add a DELETE route to the group
+func (group *CORSGroup) DELETE(relativePath string, handlers ...gin.HandlerFunc) gin.IRoutes {
+	return group.Handle(http.MethodDelete, relativePath, handlers...)
+}
+
+// Validate will return an error if the policy is not valid. Allowing any origin with credentials would let any
+// website make requests as the user, so browsers do not allow it.
+func (p *CORSPolicy) Validate() error {
+	for _, origin := range p.AllowOrigins {
+		if origin == "*" && p.AllowCredentials {
+			return errors.New("allowed origins cannot be * when credentials are allowed")
+		}
+		if _, err := path.Match(origin, ""); err != nil {
+			return fmt.Errorf("invalid allowed origin %s: %w", origin, err)
+		}
+	}
+	return nil
+}
+
+// AllowOrigin will return the Access-Control-Allow-Origin of an origin, and whether it is allowed
+func (p *CORSPolicy) AllowOrigin(origin string) (string, bool) {
+	if slices.Contains(p.AllowOrigins, "*") {
+		return "*", true
+	}
+	for _, allowedOrigin := range p.AllowOrigins {
+		if matched, _ := path.Match(strings.ToLower(allowedOrigin), strings.ToLower(origin)); matched {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// AllowsMethod will return true if the method can be called
+func (p *CORSPolicy) AllowsMethod(method string) bool {
+	return slices.Contains(p.AllowMethods, method)
+}
+
+// AllowsHeaders will return true if every header of an Access-Control-Request-Headers can be sent
+func (p *CORSPolicy) AllowsHeaders(requestHeaders string) bool {
+	for _, header := range strings.Split(requestHeaders, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+		allowed := slices.ContainsFunc(p.AllowHeaders, func(allowedHeader string) bool {
+			return strings.EqualFold(allowedHeader, header)
+		})
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// Handler is a middleware that will set the CORS headers of the policy on the responses to requests from an allowed
+// origin. Requests from other origins are still handled, the browser will not let them read the response.
+func (p *CORSPolicy) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Unless any origin is allowed, the response depends on the origin, even if the request does not have one
+		if !slices.Contains(p.AllowOrigins, "*") {
+			vary(c, "Origin")
+		}
+
+		origin := c.GetHeader("Origin")
+		if allowOrigin, ok := p.AllowOrigin(origin); ok && origin != "" {
+			c.Header("Access-Control-Allow-Origin", allowOrigin)
+			if p.AllowCredentials {
+				c.Header("Access-Control-Allow-Credentials", "true")
+			}
+			if len(p.ExposeHeaders) > 0 {
+				c.Header("Access-Control-Expose-Headers", strings.Join(p.ExposeHeaders, ", "))
+			}
+		}
+		c.Next()
+	}
+}
+
+// vary will add headers to the Vary header of a response, so caches keep a response for each of their values
+func vary(c *gin.Context, headers ...string) {
+	for _, header := range headers {
+		c.Writer.Header().Add("Vary", header)
+	}
+}
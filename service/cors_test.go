@@ -0,0 +1,210 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/magiconair/properties/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newCORSTestRouter will return a router with a read and an admin group on the same path, where the admin group has
+// its own policy
+func newCORSTestRouter(t *testing.T) *gin.Engine {
+	cors, err := NewCORS(&CORSConfig{
+		CORSPolicy: CORSPolicy{
+			AllowOrigins:  []string{"https://oar.example.com", "https://*.preview.example.com"},
+			AllowMethods:  []string{http.MethodGet, http.MethodPost, http.MethodDelete},
+			AllowHeaders:  []string{"Content-Type", "Authorization"},
+			ExposeHeaders: []string{"ETag", "X-Request-ID"},
+			MaxAge:        10 * time.Minute,
+		},
+		Groups: map[string]*CORSPolicy{
+			"admin": {
+				AllowOrigins:     []string{"https://admin.example.com"},
+				AllowMethods:     []string{http.MethodDelete},
+				AllowCredentials: true,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal("setup error", err)
+	}
+
+	router := gin.New()
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	cors.Group(router, "read").GET("/tests", ok)
+	cors.Group(router, "admin").DELETE("/tests", ok)
+	return router
+}
+
+// corsRequest will make a request to a router with headers and return the response
+func corsRequest(router *gin.Engine, method string, headers map[string]string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, "/tests", nil)
+	for header, value := range headers {
+		req.Header.Set(header, value)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// TestCORSPolicy_Validate will ensure that any origin cannot be allowed with credentials
+func TestCORSPolicy_Validate(t *testing.T) {
+	validPolicies := []*CORSPolicy{
+		{AllowOrigins: []string{"*"}},
+		{AllowOrigins: []string{"https://*.example.com"}, AllowCredentials: true},
+		{},
+	}
+	for _, policy := range validPolicies {
+		if err := policy.Validate(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	invalidPolicies := []*CORSPolicy{
+		{AllowOrigins: []string{"*"}, AllowCredentials: true},
+		{AllowOrigins: []string{"https://[example.com"}},
+	}
+	for _, policy := range invalidPolicies {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("invalid policy %+v did not throw error", policy)
+		}
+	}
+
+	if _, err := NewCORS(&CORSConfig{Groups: map[string]*CORSPolicy{"read": invalidPolicies[0]}}); err == nil {
+		t.Error("invalid group policy did not throw error")
+	}
+}
+
+// TestCORSPolicy_AllowOrigin will ensure that origins match exactly or by pattern
+func TestCORSPolicy_AllowOrigin(t *testing.T) {
+	policy := &CORSPolicy{AllowOrigins: []string{"https://oar.example.com", "https://*.preview.example.com"}}
+	origins := map[string]bool{
+		"https://oar.example.com":           true,
+		"https://OAR.example.com":           true,
+		"https://pr-1.preview.example.com":  true,
+		"http://oar.example.com":            false,
+		"https://oar.example.com.evil.com":  false,
+		"https://preview.example.com":       false,
+		"https://a/b.preview.example.com":   false,
+		"https://pr-1.preview.example.com/": false,
+	}
+	for origin, expectedAllowed := range origins {
+		allowOrigin, allowed := policy.AllowOrigin(origin)
+		assert.Equal(t, allowed, expectedAllowed, origin)
+		if allowed {
+			assert.Equal(t, allowOrigin, origin)
+		}
+	}
+
+	allowOrigin, allowed := (&CORSPolicy{AllowOrigins: []string{"*"}}).AllowOrigin("https://any.example.com")
+	assert.Equal(t, allowOrigin, "*")
+	assert.Equal(t, allowed, true)
+}
+
+// TestCORS_Group will ensure that requests get the CORS headers of the policy of their route group
+func TestCORS_Group(t *testing.T) {
+	router := newCORSTestRouter(t)
+
+	t.Run("allowed origin", func(t *testing.T) {
+		w := corsRequest(router, http.MethodGet, map[string]string{"Origin": "https://pr-1.preview.example.com"})
+		assert.Equal(t, w.Code, http.StatusOK)
+		assert.Equal(t, w.Header().Get("Access-Control-Allow-Origin"), "https://pr-1.preview.example.com")
+		assert.Equal(t, w.Header().Get("Access-Control-Allow-Credentials"), "")
+		assert.Equal(t, w.Header().Get("Access-Control-Expose-Headers"), "ETag, X-Request-ID")
+		assert.Equal(t, w.Header().Values("Vary"), []string{"Origin"})
+	})
+
+	t.Run("other origin", func(t *testing.T) {
+		w := corsRequest(router, http.MethodGet, map[string]string{"Origin": "https://evil.example.com"})
+		assert.Equal(t, w.Code, http.StatusOK)
+		assert.Equal(t, w.Header().Get("Access-Control-Allow-Origin"), "")
+		assert.Equal(t, w.Header().Values("Vary"), []string{"Origin"})
+	})
+
+	t.Run("no origin", func(t *testing.T) {
+		w := corsRequest(router, http.MethodGet, nil)
+		assert.Equal(t, w.Header().Get("Access-Control-Allow-Origin"), "")
+		assert.Equal(t, w.Header().Values("Vary"), []string{"Origin"})
+	})
+
+	t.Run("group policy", func(t *testing.T) {
+		w := corsRequest(router, http.MethodDelete, map[string]string{"Origin": "https://admin.example.com"})
+		assert.Equal(t, w.Header().Get("Access-Control-Allow-Origin"), "https://admin.example.com")
+		assert.Equal(t, w.Header().Get("Access-Control-Allow-Credentials"), "true")
+
+		w = corsRequest(router, http.MethodDelete, map[string]string{"Origin": "https://oar.example.com"})
+		assert.Equal(t, w.Header().Get("Access-Control-Allow-Origin"), "")
+	})
+}
+
+// TestCORS_Preflight will ensure that preflight requests are answered with the policy of the route group of the method
+// they ask for
+func TestCORS_Preflight(t *testing.T) {
+	router := newCORSTestRouter(t)
+
+	t.Run("allowed", func(t *testing.T) {
+		w := corsRequest(router, http.MethodOptions, map[string]string{
+			"Origin":                         "https://oar.example.com",
+			"Access-Control-Request-Method":  http.MethodGet,
+			"Access-Control-Request-Headers": "authorization, content-type",
+		})
+		assert.Equal(t, w.Code, http.StatusNoContent)
+		assert.Equal(t, w.Header().Get("Access-Control-Allow-Origin"), "https://oar.example.com")
+		assert.Equal(t, w.Header().Get("Access-Control-Allow-Methods"), "GET, POST, DELETE")
+		assert.Equal(t, w.Header().Get("Access-Control-Allow-Headers"), "Content-Type, Authorization")
+		assert.Equal(t, w.Header().Get("Access-Control-Allow-Credentials"), "")
+		assert.Equal(t, w.Header().Get("Access-Control-Max-Age"), "600")
+		assert.Equal(
+			t,
+			w.Header().Values("Vary"),
+			[]string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+		)
+	})
+
+	t.Run("group policy", func(t *testing.T) {
+		w := corsRequest(router, http.MethodOptions, map[string]string{
+			"Origin":                        "https://admin.example.com",
+			"Access-Control-Request-Method": http.MethodDelete,
+		})
+		assert.Equal(t, w.Code, http.StatusNoContent)
+		assert.Equal(t, w.Header().Get("Access-Control-Allow-Origin"), "https://admin.example.com")
+		assert.Equal(t, w.Header().Get("Access-Control-Allow-Methods"), "DELETE")
+		assert.Equal(t, w.Header().Get("Access-Control-Allow-Credentials"), "true")
+		assert.Equal(t, w.Header().Get("Access-Control-Max-Age"), "")
+
+		w = corsRequest(router, http.MethodOptions, map[string]string{
+			"Origin":                        "https://oar.example.com",
+			"Access-Control-Request-Method": http.MethodDelete,
+		})
+		assert.Equal(t, w.Code, http.StatusForbidden)
+		assert.Equal(t, w.Header().Get("Access-Control-Allow-Origin"), "")
+	})
+
+	t.Run("header not allowed", func(t *testing.T) {
+		w := corsRequest(router, http.MethodOptions, map[string]string{
+			"Origin":                         "https://oar.example.com",
+			"Access-Control-Request-Method":  http.MethodGet,
+			"Access-Control-Request-Headers": "X-Secret",
+		})
+		assert.Equal(t, w.Code, http.StatusForbidden)
+	})
+
+	t.Run("method not routed", func(t *testing.T) {
+		w := corsRequest(router, http.MethodOptions, map[string]string{
+			"Origin":                        "https://oar.example.com",
+			"Access-Control-Request-Method": http.MethodPost,
+		})
+		assert.Equal(t, w.Code, http.StatusMethodNotAllowed)
+		assert.Equal(t, w.Header().Get("Allow"), "DELETE, GET, OPTIONS")
+	})
+
+	t.Run("not a preflight request", func(t *testing.T) {
+		w := corsRequest(router, http.MethodOptions, nil)
+		assert.Equal(t, w.Code, http.StatusNoContent)
+		assert.Equal(t, w.Header().Get("Allow"), "DELETE, GET, OPTIONS")
+		assert.Equal(t, w.Header().Get("Access-Control-Allow-Origin"), "")
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// cucumberFeature is a feature of a Cucumber JSON report
+type cucumberFeature struct {
+	URI      string            `json:"uri"`
+	Name     string            `json:"name"`
+	Tags     []cucumberTag     `json:"tags"`
+	Elements []cucumberElement `json:"elements"`
+}
+
+// cucumberElement is either a scenario or a background of a Cucumber JSON feature
+type cucumberElement struct {
+	Type   string         `json:"type"`
+	Name   string         `json:"name"`
+	Line   int            `json:"line"`
+	Tags   []cucumberTag  `json:"tags"`
+	Before []cucumberStep `json:"before"`
+	Steps  []cucumberStep `json:"steps"`
+	After  []cucumberStep `json:"after"`
+}
+
+// cucumberTag is a tag on a Cucumber JSON feature or scenario
+type cucumberTag struct {
+	Name string `json:"name"`
+}
+
+// cucumberStep is a step or hook of a Cucumber JSON scenario
+type cucumberStep struct {
+	Keyword string `json:"keyword"`
+	Name    string `json:"name"`
+	Result  struct {
+		Status       string `json:"status"`
+		Duration     int64  `json:"duration"` // Nanoseconds
+		ErrorMessage string `json:"error_message"`
+	} `json:"result"`
+}
+
+// ParseCucumberJSON will read a Cucumber JSON report and convert each scenario into a Test with the scenario name as
+// the Summary. A scenario with a failed, undefined, pending or ambiguous step or hook will be Failed, the same as
+// Cucumber's strict mode. Scenarios where every step was skipped have no OAR outcome and are dropped. Background
+// steps are counted as part of the scenario that follows them.
+//
+// The feature name, scenario name, tags, step results and the failing step's error message will be stored in the
+// Doc. Note that the tests will not be validated or cleaned.
+func ParseCucumberJSON(r io.Reader) ([]*Test, error) {
+	var features []cucumberFeature
+	if err := json.NewDecoder(r).Decode(&features); err != nil {
+		return nil, err
+	}
+
+	tests := []*Test{}
+	for _, feature := range features {
+		var backgroundSteps []cucumberStep
+
+		for _, element := range feature.Elements {
+			if element.Type == "background" {
+				backgroundSteps = element.Steps
+				continue
+			}
+
+			steps := append(append([]cucumberStep{}, backgroundSteps...), element.Steps...)
+			backgroundSteps = nil
+
+			test := cucumberScenarioToTest(feature, element, steps)
+			if test != nil {
+				tests = append(tests, test)
+			}
+		}
+	}
+
+	return tests, nil
+}
+
+// cucumberScenarioToTest will convert a single scenario and its steps into a Test. Returns nil if the scenario was
+// skipped.
+func cucumberScenarioToTest(feature cucumberFeature, scenario cucumberElement, steps []cucumberStep) *Test {
+	test := &Test{
+		Summary: scenario.Name,
+		Outcome: Passed,
+		Doc: map[string]any{
+			"source":   "cucumber",
+			"feature":  feature.Name,
+			"scenario": scenario.Name,
+		},
+	}
+
+	if feature.URI != "" {
+		test.Doc["featureUri"] = feature.URI
+	}
+	if scenario.Line > 0 {
+		test.Doc["line"] = scenario.Line
+	}
+
+	var tags []string
+	for _, tag := range feature.Tags {
+		tags = append(tags, tag.Name)
+	}
+	for _, tag := range scenario.Tags {
+		tags = append(tags, tag.Name)
+	}
+	if len(tags) > 0 {
+		test.Doc["tags"] = tags
+	}
+
+	var stepResults []map[string]any
+	var duration int64
+	skipped := true
+	stepsAndHooks := append(append(append([]cucumberStep{}, steps...), scenario.Before...), scenario.After...)
+	for i, step := range stepsAndHooks {
+		duration += step.Result.Duration
+
+		switch step.Result.Status {
+		case "failed", "undefined", "pending", "ambiguous":
+			if test.Outcome != Failed {
+				test.Outcome = Failed
+				test.Doc["failedStep"] = strings.TrimSpace(step.Keyword + step.Name)
+				if step.Result.ErrorMessage != "" {
+					test.Doc["errorMessage"] = step.Result.ErrorMessage
+				}
+			}
+			skipped = false
+		case "skipped":
+		default:
+			// A passed hook on its own does not mean the scenario ran
+			if i < len(steps) {
+				skipped = false
+			}
+		}
+
+		// Only steps are recorded, hooks are only checked for failures
+		if i < len(steps) {
+			stepResults = append(stepResults, map[string]any{
+				"step":   strings.TrimSpace(step.Keyword + step.Name),
+				"status": step.Result.Status,
+			})
+		}
+	}
+
+	if skipped {
+		return nil
+	}
+
+	if len(stepResults) > 0 {
+		test.Doc["steps"] = stepResults
+	}
+	test.Doc["duration"] = float64(duration) / 1e9
+
+	return test
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"github.com/magiconair/properties/assert"
+	"strings"
+	"testing"
+)
+
+const cucumberReport = `[
+  {
+    "uri": "features/login.feature",
+    "name": "Login",
+    "tags": [{"name": "@auth"}],
+    "elements": [
+      {
+        "type": "background",
+        "name": "",
+        "steps": [
+          {"keyword": "Given ", "name": "the login page is open", "result": {"status": "passed", "duration": 1000000000}}
+        ]
+      },
+      {
+        "type": "scenario",
+        "name": "Valid user can log in",
+        "line": 7,
+        "tags": [{"name": "@smoke"}],
+        "steps": [
+          {"keyword": "When ", "name": "a valid user logs in", "result": {"status": "passed", "duration": 500000000}},
+          {"keyword": "Then ", "name": "the dashboard is shown", "result": {"status": "passed", "duration": 500000000}}
+        ]
+      },
+      {
+        "type": "scenario",
+        "name": "Locked user cannot log in",
+        "steps": [
+          {"keyword": "When ", "name": "a locked user logs in", "result": {"status": "failed", "error_message": "expected 403, got 200"}},
+          {"keyword": "Then ", "name": "an error is shown", "result": {"status": "skipped"}}
+        ]
+      },
+      {
+        "type": "scenario",
+        "name": "Password reset",
+        "steps": [
+          {"keyword": "When ", "name": "a user resets their password", "result": {"status": "undefined"}}
+        ]
+      },
+      {
+        "type": "scenario",
+        "name": "Skipped scenario",
+        "steps": [
+          {"keyword": "When ", "name": "nothing runs", "result": {"status": "skipped"}}
+        ]
+      }
+    ]
+  }
+]`
+
+// TestParseCucumberJSON will ensure that Cucumber scenarios are converted into tests with the correct outcomes and
+// details
+func TestParseCucumberJSON(t *testing.T) {
+	tests, err := ParseCucumberJSON(strings.NewReader(cucumberReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("skipped scenarios are dropped", func(t *testing.T) {
+		assert.Equal(t, len(tests), 3)
+	})
+
+	t.Run("passed scenario", func(t *testing.T) {
+		assert.Equal(t, tests[0].Summary, "Valid user can log in")
+		assert.Equal(t, tests[0].Outcome, Passed)
+		assert.Equal(t, tests[0].Doc["feature"], "Login")
+		assert.Equal(t, tests[0].Doc["tags"], []string{"@auth", "@smoke"})
+		assert.Equal(t, tests[0].Doc["duration"], 2.0)
+		assert.Equal(t, len(tests[0].Doc["steps"].([]map[string]any)), 3) // Includes the background step
+	})
+
+	t.Run("failed scenarios", func(t *testing.T) {
+		assert.Equal(t, tests[1].Outcome, Failed)
+		assert.Equal(t, tests[1].Doc["failedStep"], "When a locked user logs in")
+		assert.Equal(t, tests[1].Doc["errorMessage"], "expected 403, got 200")
+		assert.Equal(t, tests[2].Outcome, Failed)
+	})
+
+	t.Run("invalid reports throw errors", func(t *testing.T) {
+		if _, err := ParseCucumberJSON(strings.NewReader(`{"name": "not an array"}`)); err == nil {
+			t.Error("invalid report did not throw error")
+		}
+	})
+}
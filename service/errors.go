@@ -1,8 +1,16 @@
 package main
 
-import "github.com/gin-gonic/gin"
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+)
 
-// ConvertErrToGinH will convert any go error into a gin.H response body to return back to the caller
+// ConvertErrToGinH will convert any go error into a gin.H response body to return back to the caller. Errors from
+// parsing a textual query also include the position of the problem in the query.
 func ConvertErrToGinH(err error) gin.H {
+	var queryParseErr *QueryParseError
+	if errors.As(err, &queryParseErr) {
+		return gin.H{"error": err.Error(), "position": queryParseErr.Pos}
+	}
 	return gin.H{"error": err.Error()}
 }
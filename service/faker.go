@@ -191,14 +191,23 @@ func (fake *Faker) pgPool() *pgx.ConnPool {
 	return pgPool
 }
 
+// definitionFields will return the identity fields that link tests to their test definition
+func (fake *Faker) definitionFields() []string {
+	return fake.envConfig.Definitions.Fields
+}
+
 // testController will return a fake TestController with a pgPool connection
 func (fake *Faker) testController() *TestController {
-	controller := &TestController{DBPool: fake.pgPool()}
+	controller := &TestController{
+		DBPool:           fake.pgPool(),
+		MaxDeleteRows:    EnvConfig.Delete.MaxRows,
+		DefinitionFields: fake.definitionFields(),
+	}
 	return controller
 }
 
-// testRequest will return a fake Test http.Request that can be sent through a testController
-func (fake *Faker) testRequest(method string, test *Test, endpoint string) *http.Request {
+// testBody will return the request body representation of a Test, with the Doc flattened into the top level
+func (fake *Faker) testBody(test *Test) gin.H {
 	body := gin.H{
 		"summary":    test.Summary,
 		"outcome":    test.Outcome,
@@ -212,6 +221,29 @@ func (fake *Faker) testRequest(method string, test *Test, endpoint string) *http
 			body[k] = v
 		}
 	}
+	return body
+}
+
+// testRequest will return a fake Test http.Request that can be sent through a testController
+func (fake *Faker) testRequest(method string, test *Test, endpoint string) *http.Request {
+	jsonValue, err := json.Marshal(fake.testBody(test))
+	if err != nil {
+		panic(err)
+	}
+	req, err := http.NewRequest(method, endpoint, bytes.NewBuffer(jsonValue))
+	if err != nil {
+		panic(err)
+	}
+	return req
+}
+
+// testBatchRequest will return a fake http.Request with a JSON array of tests that can be sent through a
+// testController
+func (fake *Faker) testBatchRequest(method string, tests []*Test, endpoint string) *http.Request {
+	body := make([]gin.H, len(tests))
+	for i, test := range tests {
+		body[i] = fake.testBody(test)
+	}
 
 	jsonValue, err := json.Marshal(body)
 	if err != nil {
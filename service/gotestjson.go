@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx"
+	"io"
+	"strings"
+	"time"
+)
+
+// goTestEvent is a single event of a `go test -json` stream.
+// For more information, see: https://pkg.go.dev/cmd/test2json
+type goTestEvent struct {
+	Time    *time.Time `json:"Time"`
+	Action  string     `json:"Action"`
+	Package string     `json:"Package"`
+	Test    string     `json:"Test"`
+	Elapsed *float64   `json:"Elapsed"`
+	Output  string     `json:"Output"`
+}
+
+// goTestResult is the result of a single test (or subtest) rebuilt from its events
+type goTestResult struct {
+	pkg      string
+	name     string
+	action   string
+	elapsed  *float64
+	output   []string
+	subtests []string
+}
+
+// ParseGoTestJSON will read a `go test -json` event stream and rebuild the result of every test and subtest into a
+// Test. Passed and failed tests keep their outcome, skipped tests have no OAR outcome and are dropped. A test that
+// never reported a result (because of a panic or timeout) will be Failed.
+//
+// The package, test name, elapsed time, output lines and subtest hierarchy will be stored in the Doc. Lines of the
+// stream that are not JSON, like build output, are ignored. Note that the tests will not be validated or cleaned.
+func ParseGoTestJSON(r io.Reader) ([]*Test, error) {
+	reader := bufio.NewReader(r)
+	results := map[string]*goTestResult{}
+	var resultKeys []string // Keeps the tests in the order they were first seen
+
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 && line[0] == '{' {
+			var event goTestEvent
+			if jsonErr := json.Unmarshal(line, &event); jsonErr != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, jsonErr)
+			}
+
+			// Package level events do not belong to a test
+			if event.Test != "" {
+				key := event.Package + " " + event.Test
+				result, ok := results[key]
+				if !ok {
+					result = &goTestResult{pkg: event.Package, name: event.Test}
+					results[key] = result
+					resultKeys = append(resultKeys, key)
+				}
+
+				switch event.Action {
+				case "output":
+					result.output = append(result.output, strings.TrimRight(event.Output, "\n"))
+				case "pass", "fail", "skip":
+					result.action = event.Action
+					result.elapsed = event.Elapsed
+				}
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	// Links every subtest to its parent
+	for _, key := range resultKeys {
+		result := results[key]
+		if parent, ok := results[result.pkg+" "+goTestParent(result.name)]; ok && parent != result {
+			parent.subtests = append(parent.subtests, result.name)
+		}
+	}
+
+	tests := []*Test{}
+	for _, key := range resultKeys {
+		result := results[key]
+		if result.action == "skip" {
+			continue
+		}
+
+		test := &Test{
+			Summary: result.name,
+			Outcome: Passed,
+			Doc: map[string]any{
+				"source":  "gotest",
+				"package": result.pkg,
+				"test":    result.name,
+			},
+		}
+
+		switch result.action {
+		case "fail":
+			test.Outcome = Failed
+		case "":
+			test.Outcome = Failed
+			test.Doc["incomplete"] = true
+		}
+
+		if result.elapsed != nil {
+			test.Doc["duration"] = *result.elapsed
+		}
+		if len(result.output) > 0 {
+			test.Doc["output"] = result.output
+		}
+		if parent := goTestParent(result.name); parent != result.name {
+			test.Doc["parent"] = parent
+		}
+		if len(result.subtests) > 0 {
+			test.Doc["subtests"] = result.subtests
+		}
+
+		tests = append(tests, test)
+	}
+
+	return tests, nil
+}
+
+// ImportGoTestJSON will parse a `go test -json` event stream with ParseGoTestJSON, then clean, validate and insert all
+// the tests in a single transaction. Returns the IDs of the created tests. See InsertTests for the definitionFields.
+func ImportGoTestJSON(pgPool *pgx.ConnPool, audit *Audit, r io.Reader, definitionFields []string) ([]uint64, error) {
+	tests, err := ParseGoTestJSON(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, test := range tests {
+		test.Clean()
+	}
+
+	return InsertTests(pgPool, audit, tests, definitionFields)
+}
+
+// goTestParent will return the name of the parent of a subtest, or the same name if it is a top-level test
+func goTestParent(name string) string {
+	i := strings.LastIndex(name, "/")
+	if i < 0 {
+		return name
+	}
+	return name[:i]
+}
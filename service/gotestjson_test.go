@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/magiconair/properties/assert"
+	"strings"
+	"testing"
+)
+
+const goTestJSONStream = `{"Action":"start","Package":"example.com/users"}
+{"Action":"run","Package":"example.com/users","Test":"TestInsert"}
+{"Action":"output","Package":"example.com/users","Test":"TestInsert","Output":"=== RUN   TestInsert\n"}
+{"Action":"run","Package":"example.com/users","Test":"TestInsert/valid_user"}
+{"Action":"output","Package":"example.com/users","Test":"TestInsert/valid_user","Output":"    users_test.go:12: inserted\n"}
+{"Action":"pass","Package":"example.com/users","Test":"TestInsert/valid_user","Elapsed":0.01}
+{"Action":"run","Package":"example.com/users","Test":"TestInsert/invalid_user"}
+{"Action":"output","Package":"example.com/users","Test":"TestInsert/invalid_user","Output":"    users_test.go:20: expected error\n"}
+{"Action":"fail","Package":"example.com/users","Test":"TestInsert/invalid_user","Elapsed":0.02}
+{"Action":"fail","Package":"example.com/users","Test":"TestInsert","Elapsed":0.03}
+{"Action":"run","Package":"example.com/users","Test":"TestLoad"}
+{"Action":"skip","Package":"example.com/users","Test":"TestLoad","Elapsed":0}
+{"Action":"run","Package":"example.com/users","Test":"TestHangs"}
+FAIL	example.com/users	0.040s
+{"Action":"fail","Package":"example.com/users","Elapsed":0.04}`
+
+// TestParseGoTestJSON will ensure that go test events are rebuilt into tests with the correct outcomes and details
+func TestParseGoTestJSON(t *testing.T) {
+	tests, err := ParseGoTestJSON(strings.NewReader(goTestJSONStream))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("skipped tests are dropped", func(t *testing.T) {
+		assert.Equal(t, len(tests), 4)
+	})
+
+	t.Run("subtest hierarchy is kept", func(t *testing.T) {
+		assert.Equal(t, tests[0].Summary, "TestInsert")
+		assert.Equal(t, tests[0].Doc["subtests"], []string{"TestInsert/valid_user", "TestInsert/invalid_user"})
+		assert.Equal(t, tests[1].Doc["parent"], "TestInsert")
+		assert.Equal(t, tests[1].Doc["package"], "example.com/users")
+	})
+
+	t.Run("outcomes and details are rebuilt", func(t *testing.T) {
+		assert.Equal(t, tests[0].Outcome, Failed)
+		assert.Equal(t, tests[1].Outcome, Passed)
+		assert.Equal(t, tests[1].Doc["duration"], 0.01)
+		assert.Equal(t, tests[1].Doc["output"], []string{"    users_test.go:12: inserted"})
+		assert.Equal(t, tests[2].Outcome, Failed)
+	})
+
+	t.Run("tests without a result are failed", func(t *testing.T) {
+		assert.Equal(t, tests[3].Summary, "TestHangs")
+		assert.Equal(t, tests[3].Outcome, Failed)
+		assert.Equal(t, tests[3].Doc["incomplete"], true)
+	})
+
+	t.Run("malformed events throw errors", func(t *testing.T) {
+		if _, err := ParseGoTestJSON(strings.NewReader(`{"Action":`)); err == nil {
+			t.Error("malformed event did not throw error")
+		}
+	})
+}
+
+// TestImportGoTestJSON will ensure that a go test event stream gets inserted into the DB
+func TestImportGoTestJSON(t *testing.T) {
+	testIDs, err := ImportGoTestJSON(Fake.pgPool(), nil, strings.NewReader(goTestJSONStream), Fake.definitionFields())
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, len(testIDs), 4)
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MergePatchContentType is the Content-Type of a JSON Merge Patch. See: https://www.rfc-editor.org/rfc/rfc7386
+	MergePatchContentType = "application/merge-patch+json"
+	// JSONPatchContentType is the Content-Type of a JSON Patch. See: https://www.rfc-editor.org/rfc/rfc6902
+	JSONPatchContentType = "application/json-patch+json"
+)
+
+// A TestPatcher will patch a test in place. The test should be validated after it is patched.
+type TestPatcher func(test *Test) error
+
+// RightMergePatcher will return a TestPatcher that right-merges a partial test into the test with Test.Merge. It can
+// only add or overwrite the OAR fields and top-level Doc keys.
+func RightMergePatcher(testPatch *Test) TestPatcher {
+	return func(test *Test) error {
+		test.Merge(testPatch)
+		return nil
+	}
+}
+
+// JSONMergePatcher will return a TestPatcher that applies a JSON Merge Patch to the JSON document of the test, the same
+// document that is returned by the API with the dynamic attributes under "doc". A null value removes a key, and nested
+// objects are merged recursively.
+func JSONMergePatcher(mergePatch map[string]any) TestPatcher {
+	return func(test *Test) error {
+		return patchTestDocument(test, func(document any) (any, error) {
+			return applyMergePatch(document, deepCopyJSON(mergePatch)), nil
+		})
+	}
+}
+
+// JSONPatcher will return a TestPatcher that applies the operations of a JSON Patch to the JSON document of the test,
+// the same document that is returned by the API with the dynamic attributes under "doc". For example, to remove a Doc
+// key: {"op": "remove", "path": "/doc/env"}. Either every operation is applied or none of them are.
+func JSONPatcher(operations []JSONPatchOperation) TestPatcher {
+	return func(test *Test) error {
+		return patchTestDocument(test, func(document any) (any, error) {
+			return applyJSONPatch(document, operations)
+		})
+	}
+}
+
+// JSONPatchOperation is a single operation of a JSON Patch. Op is one of add, remove, replace, move, copy or test.
+// Path and From are JSON Pointers, see: https://www.rfc-editor.org/rfc/rfc6901
+type JSONPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// ParseJSONPatch will read a JSON Patch document and check that every operation is well-formed
+func ParseJSONPatch(body []byte) ([]JSONPatchOperation, error) {
+	var operations []JSONPatchOperation
+	if err := json.Unmarshal(body, &operations); err != nil {
+		return nil, fmt.Errorf("a JSON Patch must be an array of operations: %w", err)
+	}
+
+	for i, operation := range operations {
+		if _, err := parseJSONPointer(operation.Path); err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+
+		switch operation.Op {
+		case "add", "replace", "test":
+			if operation.Value == nil {
+				return nil, fmt.Errorf("operation %d: '%s' must have a value", i, operation.Op)
+			}
+		case "move", "copy":
+			if operation.From == "" {
+				return nil, fmt.Errorf("operation %d: '%s' must have a from", i, operation.Op)
+			}
+			if _, err := parseJSONPointer(operation.From); err != nil {
+				return nil, fmt.Errorf("operation %d: from: %w", i, err)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf(
+				"operation %d: invalid op: '%s', must be one of add, remove, replace, move, copy or test",
+				i,
+				operation.Op,
+			)
+		}
+	}
+
+	return operations, nil
+}
+
+// patchTestDocument will convert a test into its JSON document, patch the document and convert it back into the test.
+// The id, created, modified, deleted, definitionId and runId fields cannot be changed and only the known fields can be
+// at the top level.
+// A removed analysis or resolution is reset to its default, the same as when a test is created.
+func patchTestDocument(test *Test, patch func(document any) (any, error)) error {
+	originalTest := *test
+	if originalTest.Doc == nil {
+		originalTest.Doc = map[string]any{}
+	}
+
+	body, err := json.Marshal(&originalTest)
+	if err != nil {
+		return err
+	}
+	var document any
+	if err = json.Unmarshal(body, &document); err != nil {
+		return err
+	}
+
+	patchedDocument, err := patch(document)
+	if err != nil {
+		return err
+	}
+
+	patchedBody, err := json.Marshal(patchedDocument)
+	if err != nil {
+		return err
+	}
+	patchedTest := &Test{}
+	decoder := json.NewDecoder(bytes.NewReader(patchedBody))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(patchedTest); err != nil {
+		return fmt.Errorf("patched test is invalid, dynamic attributes must be under doc: %w", err)
+	}
+
+	switch {
+	case patchedTest.ID != test.ID:
+		return errors.New("id cannot be patched")
+	case !patchedTest.Created.Equal(test.Created):
+		return errors.New("created cannot be patched")
+	case !patchedTest.Modified.Equal(test.Modified):
+		return errors.New("modified cannot be patched")
+	case (patchedTest.Deleted == nil) != (test.Deleted == nil),
+		patchedTest.Deleted != nil && !patchedTest.Deleted.Equal(*test.Deleted):
+		return errors.New("deleted cannot be patched")
+	case (patchedTest.DefinitionID == nil) != (test.DefinitionID == nil),
+		patchedTest.DefinitionID != nil && *patchedTest.DefinitionID != *test.DefinitionID:
+		return errors.New("definitionId cannot be patched")
+	case (patchedTest.RunID == nil) != (test.RunID == nil),
+		patchedTest.RunID != nil && *patchedTest.RunID != *test.RunID:
+		return errors.New("runId cannot be patched")
+	}
+
+	patchedTest.Clean()
+	*test = *patchedTest
+	return nil
+}
+
+// applyMergePatch will apply a JSON Merge Patch to a JSON document, following the algorithm of RFC 7386
+func applyMergePatch(target any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = applyMergePatch(targetObject[key], value)
+		}
+	}
+	return targetObject
+}
+
+// applyJSONPatch will apply every operation of a JSON Patch to a JSON document, following RFC 6902
+func applyJSONPatch(document any, operations []JSONPatchOperation) (any, error) {
+	for i, operation := range operations {
+		var err error
+		document, err = applyJSONPatchOperation(document, operation)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, operation.Op, operation.Path, err)
+		}
+	}
+	return document, nil
+}
+
+// applyJSONPatchOperation will apply a single JSON Patch operation to a JSON document
+func applyJSONPatchOperation(document any, operation JSONPatchOperation) (any, error) {
+	path, err := parseJSONPointer(operation.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+	if operation.Value != nil {
+		if err = json.Unmarshal(operation.Value, &value); err != nil {
+			return nil, err
+		}
+	}
+
+	switch operation.Op {
+	case "add":
+		return jsonPointerAdd(document, path, value)
+	case "remove":
+		return jsonPointerRemove(document, path)
+	case "replace":
+		if _, err = jsonPointerGet(document, path); err != nil {
+			return nil, err
+		}
+		if document, err = jsonPointerRemove(document, path); err != nil {
+			return nil, err
+		}
+		return jsonPointerAdd(document, path, value)
+	case "move", "copy":
+		from, err := parseJSONPointer(operation.From)
+		if err != nil {
+			return nil, err
+		}
+		fromValue, err := jsonPointerGet(document, from)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+
+		if operation.Op == "copy" {
+			return jsonPointerAdd(document, path, deepCopyJSON(fromValue))
+		}
+		if strings.HasPrefix(operation.Path, operation.From+"/") {
+			return nil, errors.New("cannot move a value into one of its children")
+		}
+		if document, err = jsonPointerRemove(document, from); err != nil {
+			return nil, err
+		}
+		return jsonPointerAdd(document, path, fromValue)
+	case "test":
+		currentValue, err := jsonPointerGet(document, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(currentValue, value) {
+			return nil, errors.New("test failed, the value is different")
+		}
+		return document, nil
+	}
+
+	return nil, fmt.Errorf("invalid op: '%s'", operation.Op)
+}
+
+// parseJSONPointer will split a JSON Pointer, like "/doc/a~1b", into its unescaped reference tokens
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON Pointer: '%s', must start with '/'", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// jsonPointerGet will return the value at a JSON Pointer
+func jsonPointerGet(document any, path []string) (any, error) {
+	for _, token := range path {
+		child, err := jsonPointerChild(document, token)
+		if err != nil {
+			return nil, err
+		}
+		document = child
+	}
+	return document, nil
+}
+
+// jsonPointerAdd will add a value at a JSON Pointer. An existing object member is replaced, while a value added into an
+// array is inserted before the index, or appended if the index is "-".
+func jsonPointerAdd(document any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return jsonPointerUpdate(document, path, func(parent any, token string) (any, error) {
+		switch parent := parent.(type) {
+		case map[string]any:
+			parent[token] = value
+			return parent, nil
+		case []any:
+			if token == "-" {
+				return append(parent, value), nil
+			}
+			i, err := jsonArrayIndex(token, len(parent)+1)
+			if err != nil {
+				return nil, err
+			}
+			return append(parent[:i], append([]any{value}, parent[i:]...)...), nil
+		}
+		return nil, fmt.Errorf("cannot add '%s' to a value that is not an object or array", token)
+	})
+}
+
+// jsonPointerRemove will remove the value at a JSON Pointer, which must exist
+func jsonPointerRemove(document any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+
+	return jsonPointerUpdate(document, path, func(parent any, token string) (any, error) {
+		switch parent := parent.(type) {
+		case map[string]any:
+			if _, ok := parent[token]; !ok {
+				return nil, fmt.Errorf("'%s' does not exist", token)
+			}
+			delete(parent, token)
+			return parent, nil
+		case []any:
+			i, err := jsonArrayIndex(token, len(parent))
+			if err != nil {
+				return nil, err
+			}
+			return append(parent[:i], parent[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("cannot remove '%s' from a value that is not an object or array", token)
+	})
+}
+
+// jsonPointerUpdate will walk to the parent of a JSON Pointer, replace the parent with the result of update and
+// return the updated document. Arrays can change length, so every parent is set back into its own parent.
+func jsonPointerUpdate(
+	document any,
+	path []string,
+	update func(parent any, token string) (any, error),
+) (any, error) {
+	if len(path) == 1 {
+		return update(document, path[0])
+	}
+
+	child, err := jsonPointerChild(document, path[0])
+	if err != nil {
+		return nil, err
+	}
+	updatedChild, err := jsonPointerUpdate(child, path[1:], update)
+	if err != nil {
+		return nil, err
+	}
+
+	switch parent := document.(type) {
+	case map[string]any:
+		parent[path[0]] = updatedChild
+	case []any:
+		i, _ := jsonArrayIndex(path[0], len(parent))
+		parent[i] = updatedChild
+	}
+	return document, nil
+}
+
+// jsonPointerChild will return the member of an object or the element of an array referenced by a token
+func jsonPointerChild(document any, token string) (any, error) {
+	switch parent := document.(type) {
+	case map[string]any:
+		child, ok := parent[token]
+		if !ok {
+			return nil, fmt.Errorf("'%s' does not exist", token)
+		}
+		return child, nil
+	case []any:
+		i, err := jsonArrayIndex(token, len(parent))
+		if err != nil {
+			return nil, err
+		}
+		return parent[i], nil
+	}
+	return nil, fmt.Errorf("'%s' does not exist, the parent is not an object or array", token)
+}
+
+// jsonArrayIndex will parse an array index token, which must be less than the max
+func jsonArrayIndex(token string, max int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index: '%s'", token)
+	}
+	if i >= max {
+		return 0, fmt.Errorf("array index out of bounds: %d", i)
+	}
+	return i, nil
+}
+
+// deepCopyJSON will copy a decoded JSON value, so that a patch never shares objects or arrays with a document
+func deepCopyJSON(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(value))
+		for key, child := range value {
+			copied[key] = deepCopyJSON(child)
+		}
+		return copied
+	case []any:
+		copied := make([]any, len(value))
+		for i, child := range value {
+			copied[i] = deepCopyJSON(child)
+		}
+		return copied
+	}
+	return value
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"github.com/magiconair/properties/assert"
+	"testing"
+	"time"
+)
+
+// newPatchableTest returns a test with every field set, to patch in the JSON Patch tests
+func newPatchableTest() *Test {
+	return &Test{
+		ID:         5,
+		Summary:    "patchable test",
+		Outcome:    Failed,
+		Analysis:   TruePositive,
+		Resolution: TicketCreated,
+		Created:    time.Date(2023, 1, 2, 3, 4, 5, 6000, time.UTC),
+		Modified:   time.Date(2023, 1, 3, 3, 4, 5, 6000, time.UTC),
+		Doc:        map[string]any{"env": "dev", "tags": []any{"a", "b"}, "app": map[string]any{"name": "oar"}},
+	}
+}
+
+// TestJSONMergePatcher will ensure that a JSON Merge Patch sets, removes and merges fields following RFC 7386
+func TestJSONMergePatcher(t *testing.T) {
+	test := newPatchableTest()
+	err := JSONMergePatcher(map[string]any{
+		"analysis":   TrueNegative,
+		"outcome":    Passed,
+		"resolution": nil,
+		"doc":        map[string]any{"env": nil, "owner": "qa", "app": map[string]any{"version": "1.0"}},
+	})(test)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, test.Outcome, Passed)
+	assert.Equal(t, test.Analysis, TrueNegative)
+	assert.Equal(t, test.Resolution, Unresolved)
+	assert.Equal(t, test.Summary, "patchable test")
+	assert.Equal(t, test.Doc, map[string]any{
+		"owner": "qa",
+		"tags":  []any{"a", "b"},
+		"app":   map[string]any{"name": "oar", "version": "1.0"},
+	})
+
+	t.Run("test without a doc", func(t *testing.T) {
+		test := newPatchableTest()
+		test.Doc = nil
+		if err := JSONMergePatcher(map[string]any{"doc": map[string]any{"env": "dev"}})(test); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, test.Doc, map[string]any{"env": "dev"})
+	})
+
+	errorPatches := map[string]map[string]any{
+		"id":                   {"id": 6},
+		"created":              {"created": "2020-01-01T00:00:00Z"},
+		"deleted":              {"deleted": "2020-01-01T00:00:00Z"},
+		"definitionId":         {"definitionId": 3},
+		"runId":                {"runId": 4},
+		"top-level doc key":    {"env": "prod"},
+		"invalid outcome type": {"outcome": 1},
+	}
+	for name, mergePatch := range errorPatches {
+		t.Run(name, func(t *testing.T) {
+			test := newPatchableTest()
+			if err := JSONMergePatcher(mergePatch)(test); err == nil {
+				t.Error("expected merge patch to fail")
+			}
+			assert.Equal(t, test, newPatchableTest())
+		})
+	}
+}
+
+// TestJSONPatcher will ensure that every JSON Patch operation works following RFC 6902
+func TestJSONPatcher(t *testing.T) {
+	operations, err := ParseJSONPatch([]byte(`[
+		{"op": "test", "path": "/outcome", "value": "Failed"},
+		{"op": "replace", "path": "/summary", "value": "patched test"},
+		{"op": "remove", "path": "/doc/env"},
+		{"op": "add", "path": "/doc/tags/1", "value": "c"},
+		{"op": "add", "path": "/doc/tags/-", "value": "d"},
+		{"op": "copy", "from": "/doc/app", "path": "/doc/copied"},
+		{"op": "move", "from": "/doc/app/name", "path": "/doc/name"},
+		{"op": "add", "path": "/doc/a~1b", "value": true}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test := newPatchableTest()
+	if err = JSONPatcher(operations)(test); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, test.Summary, "patched test")
+	assert.Equal(t, test.Doc, map[string]any{
+		"tags":   []any{"a", "c", "b", "d"},
+		"app":    map[string]any{},
+		"copied": map[string]any{"name": "oar"},
+		"name":   "oar",
+		"a/b":    true,
+	})
+
+	errorPatches := map[string]string{
+		"failed test":                `[{"op": "test", "path": "/outcome", "value": "Passed"}]`,
+		"remove missing key":         `[{"op": "remove", "path": "/doc/missing"}]`,
+		"replace missing key":        `[{"op": "replace", "path": "/doc/missing", "value": 1}]`,
+		"add to missing parent":      `[{"op": "add", "path": "/doc/missing/key", "value": 1}]`,
+		"array index out of bounds":  `[{"op": "add", "path": "/doc/tags/3", "value": "c"}]`,
+		"move into its own child":    `[{"op": "move", "from": "/doc", "path": "/doc/app"}]`,
+		"patch id":                   `[{"op": "replace", "path": "/id", "value": 6}]`,
+		"remove doc key after error": `[{"op": "remove", "path": "/doc/env"}, {"op": "remove", "path": "/nope"}]`,
+	}
+	for name, patch := range errorPatches {
+		t.Run(name, func(t *testing.T) {
+			operations, err := ParseJSONPatch([]byte(patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			test := newPatchableTest()
+			if err = JSONPatcher(operations)(test); err == nil {
+				t.Error("expected JSON Patch to fail")
+			}
+			assert.Equal(t, test, newPatchableTest())
+		})
+	}
+}
+
+// TestParseJSONPatch will ensure that malformed JSON Patch documents are rejected before they are applied
+func TestParseJSONPatch(t *testing.T) {
+	invalidPatches := map[string]string{
+		"not an array":   `{"op": "remove", "path": "/summary"}`,
+		"invalid op":     `[{"op": "delete", "path": "/summary"}]`,
+		"missing value":  `[{"op": "add", "path": "/doc/env"}]`,
+		"invalid path":   `[{"op": "remove", "path": "summary"}]`,
+		"invalid from":   `[{"op": "copy", "from": "doc", "path": "/doc/env"}]`,
+		"missing from":   `[{"op": "move", "path": "/doc/env"}]`,
+		"invalid syntax": `[{"op": "remove",`,
+	}
+	for name, patch := range invalidPatches {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseJSONPatch([]byte(patch)); err == nil {
+				t.Error("expected JSON Patch to be invalid")
+			}
+		})
+	}
+
+	operations, err := ParseJSONPatch([]byte(`[{"op": "add", "path": "/doc/env", "value": null}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(operations), 1)
+}
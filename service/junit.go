@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// junitTestSuite is a <testsuite> element of a JUnit XML report. Some xUnit tools nest suites within suites.
+type junitTestSuite struct {
+	Name   string           `xml:"name,attr"`
+	Suites []junitTestSuite `xml:"testsuite"`
+	Cases  []junitTestCase  `xml:"testcase"`
+}
+
+// junitTestCase is a <testcase> element of a JUnit XML report
+type junitTestCase struct {
+	Name      string         `xml:"name,attr"`
+	ClassName string         `xml:"classname,attr"`
+	Time      string         `xml:"time,attr"`
+	Failures  []junitFailure `xml:"failure"`
+	Errors    []junitFailure `xml:"error"`
+	Skipped   *junitFailure  `xml:"skipped"`
+	SystemOut string         `xml:"system-out"`
+	SystemErr string         `xml:"system-err"`
+}
+
+// junitFailure is a <failure>, <error> or <skipped> element of a JUnit XML test case
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// ParseJUnitXML will read a JUnit/xUnit XML report and convert each <testcase> into a Test. The root element can
+// either be <testsuites> or a single <testsuite>. Test cases with a <failure> or <error> will be Failed and all others
+// will be Passed, except for skipped test cases, which have no OAR outcome and are dropped.
+//
+// The class name, time, system-out, system-err and failure details of each test case will be stored in the Doc. Note
+// that the tests will not be validated or cleaned.
+func ParseJUnitXML(r io.Reader) ([]*Test, error) {
+	decoder := xml.NewDecoder(r)
+
+	var suites []junitTestSuite
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no <testsuites> or <testsuite> element found")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		root, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch root.Name.Local {
+		case "testsuites":
+			var rootSuite junitTestSuite
+			if err = decoder.DecodeElement(&rootSuite, &root); err != nil {
+				return nil, err
+			}
+			suites = rootSuite.Suites
+		case "testsuite":
+			var rootSuite junitTestSuite
+			if err = decoder.DecodeElement(&rootSuite, &root); err != nil {
+				return nil, err
+			}
+			suites = []junitTestSuite{rootSuite}
+		default:
+			return nil, fmt.Errorf("unrecognized root element: <%s>", root.Name.Local)
+		}
+		break
+	}
+
+	tests := []*Test{}
+	for _, suite := range suites {
+		tests = append(tests, junitSuiteToTests(suite)...)
+	}
+	return tests, nil
+}
+
+// junitSuiteToTests will convert every test case in a suite, and any suites nested in it, into a Test
+func junitSuiteToTests(suite junitTestSuite) []*Test {
+	var tests []*Test
+
+	for _, testCase := range suite.Cases {
+		if testCase.Skipped != nil {
+			continue
+		}
+
+		test := &Test{
+			Summary: testCase.Name,
+			Outcome: Passed,
+			Doc:     map[string]any{"source": "junit"},
+		}
+
+		if suite.Name != "" {
+			test.Doc["suite"] = suite.Name
+		}
+		if testCase.ClassName != "" {
+			test.Doc["className"] = testCase.ClassName
+		}
+		if duration, err := strconv.ParseFloat(strings.ReplaceAll(testCase.Time, ",", ""), 64); err == nil {
+			test.Doc["duration"] = duration
+		}
+		if systemOut := strings.TrimSpace(testCase.SystemOut); systemOut != "" {
+			test.Doc["systemOut"] = systemOut
+		}
+		if systemErr := strings.TrimSpace(testCase.SystemErr); systemErr != "" {
+			test.Doc["systemErr"] = systemErr
+		}
+
+		// Errors are treated the same as failures, an error is just a failure that was not an assertion
+		var failure *junitFailure
+		if len(testCase.Failures) > 0 {
+			failure = &testCase.Failures[0]
+		} else if len(testCase.Errors) > 0 {
+			failure = &testCase.Errors[0]
+		}
+
+		if failure != nil {
+			test.Outcome = Failed
+			test.Doc["failureMessage"] = failure.Message
+			if failure.Type != "" {
+				test.Doc["failureType"] = failure.Type
+			}
+			if text := strings.TrimSpace(failure.Text); text != "" {
+				test.Doc["failureText"] = text
+			}
+		}
+
+		tests = append(tests, test)
+	}
+
+	for _, nestedSuite := range suite.Suites {
+		tests = append(tests, junitSuiteToTests(nestedSuite)...)
+	}
+
+	return tests
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"github.com/magiconair/properties/assert"
+	"strings"
+	"testing"
+)
+
+const junitReport = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="user-service" tests="4">
+    <testcase name="Test user insert query is functional" classname="users.InsertTest" time="0.25">
+      <system-out>inserted 1 row</system-out>
+    </testcase>
+    <testcase name="Ensures a bad input returns a correct error message" classname="users.InputTest" time="1,200.5">
+      <failure message="expected 400, got 500" type="AssertionError">stack trace</failure>
+    </testcase>
+    <testcase name="Ensures the /metadata endpoint is functional" classname="users.MetadataTest">
+      <error message="connection refused"/>
+    </testcase>
+    <testcase name="User service load test" classname="users.LoadTest">
+      <skipped message="load tests disabled"/>
+    </testcase>
+  </testsuite>
+</testsuites>`
+
+// TestParseJUnitXML will ensure that JUnit test cases are converted into tests with the correct outcomes and details
+func TestParseJUnitXML(t *testing.T) {
+	tests, err := ParseJUnitXML(strings.NewReader(junitReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("skipped test cases are dropped", func(t *testing.T) {
+		assert.Equal(t, len(tests), 3)
+	})
+
+	t.Run("passed test case", func(t *testing.T) {
+		assert.Equal(t, tests[0].Summary, "Test user insert query is functional")
+		assert.Equal(t, tests[0].Outcome, Passed)
+		assert.Equal(t, tests[0].Doc["className"], "users.InsertTest")
+		assert.Equal(t, tests[0].Doc["suite"], "user-service")
+		assert.Equal(t, tests[0].Doc["duration"], 0.25)
+		assert.Equal(t, tests[0].Doc["systemOut"], "inserted 1 row")
+	})
+
+	t.Run("failure and error test cases are failed", func(t *testing.T) {
+		assert.Equal(t, tests[1].Outcome, Failed)
+		assert.Equal(t, tests[1].Doc["failureMessage"], "expected 400, got 500")
+		assert.Equal(t, tests[1].Doc["failureType"], "AssertionError")
+		assert.Equal(t, tests[1].Doc["duration"], 1200.5)
+		assert.Equal(t, tests[2].Outcome, Failed)
+		assert.Equal(t, tests[2].Doc["failureMessage"], "connection refused")
+	})
+
+	t.Run("converted tests are valid", func(t *testing.T) {
+		for _, test := range tests {
+			test.Clean()
+			if err := test.Validate(); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+
+	t.Run("single testsuite root is accepted", func(t *testing.T) {
+		tests, err := ParseJUnitXML(strings.NewReader(
+			`<testsuite name="suite"><testcase name="a"/><testcase name="b"><failure/></testcase></testsuite>`,
+		))
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, len(tests), 2)
+		assert.Equal(t, tests[1].Outcome, Failed)
+	})
+
+	t.Run("invalid reports throw errors", func(t *testing.T) {
+		for _, report := range []string{"", "<html></html>", "<testsuites><testsuite>"} {
+			if _, err := ParseJUnitXML(strings.NewReader(report)); err == nil {
+				t.Errorf("invalid report %q did not throw error", report)
+			}
+		}
+	})
+}
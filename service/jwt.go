@@ -0,0 +1,388 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksRefetchInterval is the least time between fetches of a JWKS URL for a token signed by an unknown key, so that
+// tokens with made up key IDs cannot flood the identity provider
+const jwksRefetchInterval = time.Minute
+
+// jwtAlgorithms are the signing algorithms that a JWT can use, with the hash of each. Symmetric algorithms and "none"
+// are never accepted.
+var jwtAlgorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"PS256": crypto.SHA256,
+	"PS384": crypto.SHA384,
+	"PS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// A JWTVerifier verifies the signed JWT bearer tokens of an OIDC identity provider, with the public keys of a JWKS
+// (JSON Web Key Set) from a local file or a URL. Keys from a URL are fetched again every JWKSRefresh, or when a token
+// is signed by a key that is not known yet, so that the identity provider can rotate its keys.
+type JWTVerifier struct {
+	config *JWTConfig
+	client *http.Client
+	now    func() time.Time
+	mu     sync.RWMutex
+	keys   map[string]crypto.PublicKey
+	// fetched is when the keys were last loaded, and attempted is when they were last tried to be loaded
+	fetched   time.Time
+	attempted time.Time
+}
+
+// NewJWTVerifier will return a JWTVerifier with the keys of the configured JWKS already loaded
+func NewJWTVerifier(config *JWTConfig) (*JWTVerifier, error) {
+	if config.Issuer == "" || config.Audience == "" {
+		return nil, errors.New("JWT issuer and audience must be configured")
+	}
+
+	verifier := &JWTVerifier{config: config, client: &http.Client{Timeout: 10 * time.Second}, now: time.Now}
+	if err := verifier.loadKeys(); err != nil {
+		return nil, err
+	}
+	return verifier, nil
+}
+
+// Verify will check the signature, issuer, audience and lifetime of a JWT and return its claims
+func (v *JWTVerifier) Verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("JWT must have 3 parts")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid JWT header: %w", err)
+	}
+	hash, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("JWT algorithm is not allowed: '%s'", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT signature: %w", err)
+	}
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err = verifyJWTSignature(header.Alg, hash, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err = decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid JWT claims: %w", err)
+	}
+	if err = v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// Principal will return the Principal of the claims of a verified JWT. The actor is the ActorClaim, or the subject if
+// the token does not have it. The scopes are the known scopes in the ScopesClaim, which can be a space separated string
+// or an array, or the DefaultScopes if the claim does not have any known scopes. Identity providers put their own
+// scopes in the standard "scope" claim, like "openid profile", so a claim without OAR scopes is the same as no claim.
+func (v *JWTVerifier) Principal(claims map[string]any) (*Principal, error) {
+	actor, _ := claims[v.config.ActorClaim].(string)
+	if actor == "" {
+		actor, _ = claims["sub"].(string)
+	}
+	if actor == "" {
+		return nil, fmt.Errorf("JWT does not have a '%s' or 'sub' claim", v.config.ActorClaim)
+	}
+
+	var claimedScopes []string
+	switch scopes := claims[v.config.ScopesClaim].(type) {
+	case string:
+		claimedScopes = strings.Fields(scopes)
+	case []any:
+		for _, scope := range scopes {
+			if scope, ok := scope.(string); ok {
+				claimedScopes = append(claimedScopes, scope)
+			}
+		}
+	}
+
+	principal := &Principal{Actor: actor, Scopes: knownScopes(claimedScopes)}
+	if len(principal.Scopes) == 0 {
+		principal.Scopes = knownScopes(v.config.DefaultScopes)
+	}
+	return principal, nil
+}
+
+// knownScopes will return the OAR scopes of a list of scopes, in order. Any other scope is left out.
+func knownScopes(scopes []string) []Scope {
+	var known []Scope
+	for _, scope := range scopes {
+		switch Scope(scope) {
+		case ScopeIngest, ScopeEnrich, ScopeRead, ScopeAdmin:
+			known = append(known, Scope(scope))
+		}
+	}
+	return known
+}
+
+// validateClaims will check the registered claims of a JWT. Expiration is required, while not before is optional. The
+// Leeway allows for clock skew between the OAR service and the identity provider.
+func (v *JWTVerifier) validateClaims(claims map[string]any) error {
+	if issuer, _ := claims["iss"].(string); issuer != v.config.Issuer {
+		return fmt.Errorf("JWT issuer is not trusted: '%s'", issuer)
+	}
+
+	audienceMatches := false
+	switch audience := claims["aud"].(type) {
+	case string:
+		audienceMatches = audience == v.config.Audience
+	case []any:
+		for _, aud := range audience {
+			audienceMatches = audienceMatches || aud == v.config.Audience
+		}
+	}
+	if !audienceMatches {
+		return errors.New("JWT is not for this audience")
+	}
+
+	now := v.now()
+	expires, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("JWT does not expire")
+	}
+	if now.Add(-v.config.Leeway).After(time.Unix(int64(expires), 0)) {
+		return errors.New("JWT is expired")
+	}
+	notBefore, ok := claims["nbf"].(float64)
+	if ok && now.Add(v.config.Leeway).Before(time.Unix(int64(notBefore), 0)) {
+		return errors.New("JWT is not valid yet")
+	}
+
+	return nil
+}
+
+// key will return the public key with a key ID. If the JWKS is from a URL, it is fetched again when it is older than
+// the JWKSRefresh, or when the key ID is not known, at most once every jwksRefetchInterval. A token without a key ID
+// can only be verified if the JWKS has a single key.
+func (v *JWTVerifier) key(kid string) (crypto.PublicKey, error) {
+	v.mu.RLock()
+	key, ok := v.lookupKey(kid)
+	stale := v.config.JWKSRefresh > 0 && v.now().Sub(v.fetched) > v.config.JWKSRefresh
+	recentlyAttempted := v.now().Sub(v.attempted) < jwksRefetchInterval
+	v.mu.RUnlock()
+
+	if isURL(v.config.JWKS) && !recentlyAttempted && (!ok || stale) {
+		if err := v.loadKeys(); err != nil && !ok {
+			return nil, err
+		}
+		v.mu.RLock()
+		key, ok = v.lookupKey(kid)
+		v.mu.RUnlock()
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("JWT signing key is not known: '%s'", kid)
+	}
+	return key, nil
+}
+
+// lookupKey will find a loaded key by key ID. The read lock must be held.
+func (v *JWTVerifier) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, true
+		}
+	}
+	key, ok := v.keys[kid]
+	return key, ok
+}
+
+// loadKeys will read the JWKS from its file or URL and replace the loaded keys
+func (v *JWTVerifier) loadKeys() error {
+	v.mu.Lock()
+	v.attempted = v.now()
+	v.mu.Unlock()
+
+	var body []byte
+	var err error
+	if isURL(v.config.JWKS) {
+		body, err = v.fetchJWKS()
+	} else {
+		body, err = os.ReadFile(v.config.JWKS)
+	}
+	if err != nil {
+		return fmt.Errorf("could not load JWKS: %w", err)
+	}
+
+	keys, err := ParseJWKS(body)
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.fetched = v.now()
+	v.mu.Unlock()
+	return nil
+}
+
+// fetchJWKS will GET the JWKS URL
+func (v *JWTVerifier) fetchJWKS() ([]byte, error) {
+	response, err := v.client.Get(v.config.JWKS)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", response.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, 1<<20))
+}
+
+// ParseJWKS will read the RSA and EC public signing keys of a JSON Web Key Set by their key ID. Keys of other types,
+// or that are not for signatures, are skipped. See: https://www.rfc-editor.org/rfc/rfc7517
+func ParseJWKS(body []byte) (map[string]crypto.PublicKey, error) {
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &jwks); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		switch jwk.Kty {
+		case "RSA":
+			n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+			if err != nil {
+				return nil, fmt.Errorf("invalid JWK '%s': %w", jwk.Kid, err)
+			}
+			e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+			if err != nil || len(e) == 0 || len(e) > 4 {
+				return nil, fmt.Errorf("invalid JWK '%s': invalid exponent", jwk.Kid)
+			}
+			keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			curve := map[string]elliptic.Curve{
+				"P-256": elliptic.P256(),
+				"P-384": elliptic.P384(),
+				"P-521": elliptic.P521(),
+			}[jwk.Crv]
+			if curve == nil {
+				return nil, fmt.Errorf("invalid JWK '%s': unsupported curve: '%s'", jwk.Kid, jwk.Crv)
+			}
+			x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+			if err != nil {
+				return nil, fmt.Errorf("invalid JWK '%s': %w", jwk.Kid, err)
+			}
+			y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if err != nil {
+				return nil, fmt.Errorf("invalid JWK '%s': %w", jwk.Kid, err)
+			}
+			key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !curve.IsOnCurve(key.X, key.Y) {
+				return nil, fmt.Errorf("invalid JWK '%s': point is not on the curve", jwk.Kid)
+			}
+			keys[jwk.Kid] = key
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS does not have any RSA or EC signing keys")
+	}
+	return keys, nil
+}
+
+// verifyJWTSignature will verify the signature of the signed part of a JWT with a public key. The key type must match
+// the algorithm, so that a token cannot pick how its own signature is checked.
+func verifyJWTSignature(alg string, hash crypto.Hash, key crypto.PublicKey, signed string, signature []byte) error {
+	hasher := hash.New()
+	hasher.Write([]byte(signed))
+	digest := hasher.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		var err error
+		switch alg[:2] {
+		case "RS":
+			err = rsa.VerifyPKCS1v15(key, hash, digest, signature)
+		case "PS":
+			err = rsa.VerifyPSS(key, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		default:
+			return fmt.Errorf("JWT algorithm '%s' cannot be used with an RSA key", alg)
+		}
+		if err != nil {
+			return errors.New("invalid JWT signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		bitSize := key.Curve.Params().BitSize
+		if alg != map[int]string{256: "ES256", 384: "ES384", 521: "ES512"}[bitSize] {
+			return fmt.Errorf("JWT algorithm '%s' cannot be used with a P-%d key", alg, bitSize)
+		}
+		size := (bitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid JWT signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errors.New("invalid JWT signature")
+		}
+		return nil
+	}
+
+	return errors.New("unsupported JWT signing key")
+}
+
+// decodeJWTPart will decode a base64url encoded JSON part of a JWT
+func decodeJWTPart(part string, v any) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(decoded, v)
+}
+
+// isURL will return true if a JWKS location is an HTTP(S) URL instead of a file path
+func isURL(location string) bool {
+	return strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://")
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/magiconair/properties/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// jwtTestKeys is a locally generated key pair of each type to sign test JWTs with
+type jwtTestKeys struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+// newJWTTestKeys will generate an RSA and a P-256 key pair
+func newJWTTestKeys(t *testing.T) *jwtTestKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal("setup error", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("setup error", err)
+	}
+	return &jwtTestKeys{rsa: rsaKey, ec: ecKey}
+}
+
+// jwks will return the JWKS of the public keys, with the key IDs "rsa" and "ec"
+func (keys *jwtTestKeys) jwks() []byte {
+	encode := base64.RawURLEncoding.EncodeToString
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{
+			"kty": "RSA",
+			"kid": "rsa",
+			"use": "sig",
+			"n":   encode(keys.rsa.N.Bytes()),
+			"e":   encode(big.NewInt(int64(keys.rsa.E)).Bytes()),
+		},
+		{
+			"kty": "EC",
+			"kid": "ec",
+			"crv": "P-256",
+			"x":   encode(keys.ec.X.FillBytes(make([]byte, 32))),
+			"y":   encode(keys.ec.Y.FillBytes(make([]byte, 32))),
+		},
+	}})
+	return jwks
+}
+
+// sign will create a JWT with the claims, signed with the key of the key ID by the algorithm
+func (keys *jwtTestKeys) sign(t *testing.T, alg string, kid string, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(signed))
+	var signature []byte
+	var err error
+	switch alg {
+	case "RS256":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, keys.rsa, crypto.SHA256, digest.Sum(nil))
+	case "PS256":
+		pss := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+		signature, err = rsa.SignPSS(rand.Reader, keys.rsa, crypto.SHA256, digest.Sum(nil), pss)
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, keys.ec, digest.Sum(nil))
+		if err == nil {
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	default:
+		signature = []byte("signature")
+	}
+	if err != nil {
+		t.Fatal("setup error", err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// newJWTTestVerifier will write the JWKS of the keys to a file and return a JWTVerifier that loads it
+func newJWTTestVerifier(t *testing.T, keys *jwtTestKeys) *JWTVerifier {
+	jwksPath := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(jwksPath, keys.jwks(), 0o600); err != nil {
+		t.Fatal("setup error", err)
+	}
+
+	verifier, err := NewJWTVerifier(&JWTConfig{
+		Issuer:        "https://sso.example.com",
+		Audience:      "oar-enrich-ui",
+		JWKS:          jwksPath,
+		ActorClaim:    "email",
+		ScopesClaim:   "scope",
+		DefaultScopes: []string{"read", "enrich"},
+		Leeway:        time.Minute,
+	})
+	if err != nil {
+		t.Fatal("setup error", err)
+	}
+	return verifier
+}
+
+// validJWTClaims will return claims that pass verification
+func validJWTClaims() map[string]any {
+	return map[string]any{
+		"iss":   "https://sso.example.com",
+		"aud":   "oar-enrich-ui",
+		"sub":   "user-1",
+		"email": "jane@example.com",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nbf":   time.Now().Add(-time.Minute).Unix(),
+	}
+}
+
+// TestJWTVerifier_Verify will ensure that only JWTs with a valid signature, issuer, audience and lifetime are verified
+func TestJWTVerifier_Verify(t *testing.T) {
+	keys := newJWTTestKeys(t)
+	verifier := newJWTTestVerifier(t, keys)
+
+	for _, alg := range []string{"RS256", "PS256", "ES256"} {
+		t.Run("valid "+alg, func(t *testing.T) {
+			kid := "rsa"
+			if alg == "ES256" {
+				kid = "ec"
+			}
+			claims, err := verifier.Verify(keys.sign(t, alg, kid, validJWTClaims()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, claims["email"], "jane@example.com")
+		})
+	}
+
+	t.Run("audience array", func(t *testing.T) {
+		claims := validJWTClaims()
+		claims["aud"] = []string{"other", "oar-enrich-ui"}
+		if _, err := verifier.Verify(keys.sign(t, "RS256", "rsa", claims)); err != nil {
+			t.Error(err)
+		}
+	})
+
+	invalidClaims := map[string]func(claims map[string]any){
+		"expired":         func(claims map[string]any) { claims["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"does not expire": func(claims map[string]any) { delete(claims, "exp") },
+		"not valid yet":   func(claims map[string]any) { claims["nbf"] = time.Now().Add(time.Hour).Unix() },
+		"wrong issuer":    func(claims map[string]any) { claims["iss"] = "https://evil.example.com" },
+		"wrong audience":  func(claims map[string]any) { claims["aud"] = "other" },
+	}
+	for name, invalidate := range invalidClaims {
+		t.Run(name, func(t *testing.T) {
+			claims := validJWTClaims()
+			invalidate(claims)
+			if _, err := verifier.Verify(keys.sign(t, "RS256", "rsa", claims)); err == nil {
+				t.Error("invalid JWT was verified")
+			}
+		})
+	}
+
+	t.Run("within the leeway", func(t *testing.T) {
+		claims := validJWTClaims()
+		claims["exp"] = time.Now().Add(-30 * time.Second).Unix()
+		if _, err := verifier.Verify(keys.sign(t, "RS256", "rsa", claims)); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("tampered claims", func(t *testing.T) {
+		parts := strings.Split(keys.sign(t, "RS256", "rsa", validJWTClaims()), ".")
+		claims := validJWTClaims()
+		claims["email"] = "admin@example.com"
+		payload, _ := json.Marshal(claims)
+		parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+		if _, err := verifier.Verify(strings.Join(parts, ".")); err == nil {
+			t.Error("tampered JWT was verified")
+		}
+	})
+
+	t.Run("signed by another key", func(t *testing.T) {
+		otherKeys := newJWTTestKeys(t)
+		if _, err := verifier.Verify(otherKeys.sign(t, "RS256", "rsa", validJWTClaims())); err == nil {
+			t.Error("JWT signed by another key was verified")
+		}
+	})
+
+	unsigned := strings.Join(strings.Split(keys.sign(t, "RS256", "rsa", validJWTClaims()), ".")[:2], ".")
+	invalidTokens := map[string]string{
+		"none algorithm":  keys.sign(t, "none", "rsa", validJWTClaims()),
+		"HMAC algorithm":  keys.sign(t, "HS256", "rsa", validJWTClaims()),
+		"unknown key":     keys.sign(t, "RS256", "unknown", validJWTClaims()),
+		"wrong key type":  keys.sign(t, "ES256", "rsa", validJWTClaims()),
+		"not a JWT":       "not.a.jwt",
+		"too many parts":  "a.b.c.d",
+		"empty signature": unsigned + ".",
+	}
+	for name, token := range invalidTokens {
+		t.Run(name, func(t *testing.T) {
+			if _, err := verifier.Verify(token); err == nil {
+				t.Error("invalid JWT was verified")
+			}
+		})
+	}
+}
+
+// TestJWTVerifier_Principal will ensure that the actor and scopes of a user come from the configured claims
+func TestJWTVerifier_Principal(t *testing.T) {
+	verifier := newJWTTestVerifier(t, newJWTTestKeys(t))
+
+	principal, err := verifier.Principal(validJWTClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, principal.Actor, "jane@example.com")
+	assert.Equal(t, principal.Scopes, []Scope{ScopeRead, ScopeEnrich})
+
+	claims := validJWTClaims()
+	delete(claims, "email")
+	claims["scope"] = "openid read admin"
+	principal, err = verifier.Principal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, principal.Actor, "user-1")
+	assert.Equal(t, principal.Scopes, []Scope{ScopeRead, ScopeAdmin})
+
+	claims["scope"] = []any{"ingest", 1}
+	principal, err = verifier.Principal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, principal.Scopes, []Scope{ScopeIngest})
+
+	// The scopes of a standard OIDC token are not OAR scopes
+	claims["scope"] = "openid profile"
+	principal, err = verifier.Principal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, principal.Scopes, []Scope{ScopeRead, ScopeEnrich})
+
+	delete(claims, "sub")
+	if _, err = verifier.Principal(claims); err == nil {
+		t.Error("JWT without an actor did not throw error")
+	}
+}
+
+// TestJWTVerifier_JWKSURL will ensure that a JWKS can be loaded from a URL and is fetched again for rotated keys
+func TestJWTVerifier_JWKSURL(t *testing.T) {
+	keys := newJWTTestKeys(t)
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		_, _ = w.Write(keys.jwks())
+	}))
+	defer server.Close()
+
+	verifier, err := NewJWTVerifier(&JWTConfig{
+		Issuer:      "https://sso.example.com",
+		Audience:    "oar-enrich-ui",
+		JWKS:        server.URL,
+		JWKSRefresh: time.Hour,
+		ActorClaim:  "email",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, fetches, 1)
+
+	if _, err = verifier.Verify(keys.sign(t, "ES256", "ec", validJWTClaims())); err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, fetches, 1)
+
+	// The identity provider rotates its keys, which are fetched once the refetch interval has passed
+	keys = newJWTTestKeys(t)
+	token := keys.sign(t, "ES256", "ec", validJWTClaims())
+	if _, err = verifier.Verify(token); err == nil {
+		t.Error("JWT signed by a rotated key was verified before the JWKS was fetched again")
+	}
+
+	verifier.now = func() time.Time { return time.Now().Add(jwksRefetchInterval + time.Second) }
+	if _, err = verifier.Verify(keys.sign(t, "ES256", "rotated", validJWTClaims())); err == nil {
+		t.Error("JWT signed by an unknown key was verified")
+	}
+	assert.Equal(t, fetches, 2)
+	if _, err = verifier.Verify(token); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestAuthenticator_RequireJWT will ensure that bearer JWTs authenticate users and put them on the gin context as the
+// actor
+func TestAuthenticator_RequireJWT(t *testing.T) {
+	keys := newJWTTestKeys(t)
+	auth := &Authenticator{Config: &AuthConfig{Enabled: true}, JWT: newJWTTestVerifier(t, keys)}
+	token := keys.sign(t, "RS256", "rsa", validJWTClaims())
+
+	c, _ := requireScope(auth, ScopeEnrich, map[string]string{"Authorization": "Bearer " + token})
+	assert.Equal(t, c.IsAborted(), false)
+	assert.Equal(t, c.GetString(ActorKey), "jane@example.com")
+	assert.Equal(t, BindAudit(c).Actor, "jane@example.com")
+
+	c, w := requireScope(auth, ScopeAdmin, map[string]string{"Authorization": "Bearer " + token})
+	assert.Equal(t, c.IsAborted(), true)
+	assert.Equal(t, w.Code, 403)
+
+	claims := validJWTClaims()
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	expiredToken := keys.sign(t, "RS256", "rsa", claims)
+	c, w = requireScope(auth, ScopeRead, map[string]string{"Authorization": "Bearer " + expiredToken})
+	assert.Equal(t, c.IsAborted(), true)
+	assert.Equal(t, w.Code, 401)
+	assert.Equal(t, strings.Contains(w.Body.String(), "JWT is expired"), true)
+}
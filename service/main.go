@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
 	"log"
 	"net/http"
@@ -22,33 +23,76 @@ func GetRouter() *gin.Engine {
 	if err != nil {
 		log.Fatal(err)
 	}
-	testController := TestController{DBPool: pgPool}
+	testController := TestController{
+		DBPool:           pgPool,
+		MaxDeleteRows:    EnvConfig.Delete.MaxRows,
+		DefinitionFields: EnvConfig.Definitions.Fields,
+	}
+	apiKeyController := APIKeyController{DBPool: pgPool}
+	runController := RunController{DBPool: pgPool}
+	auth := &Authenticator{DBPool: pgPool, Config: EnvConfig.Auth}
+	if EnvConfig.Auth.JWT.JWKS != "" {
+		if auth.JWT, err = NewJWTVerifier(EnvConfig.Auth.JWT); err != nil {
+			log.Fatal(err)
+		}
+	}
+	cors, err := NewCORS(EnvConfig.CORS)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = EnvConfig.Definitions.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	go RunTestPurger(context.Background(), pgPool, EnvConfig.Delete.Retention, EnvConfig.Delete.PurgeInterval)
 
 	r := gin.Default()
-	r.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PATCH, DELETE")
-
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
+	r.Use(RequestID())
 
-		c.Next()
-	})
+	// Every endpoint except /health needs the scope of its group, if authentication is enabled. Each group has its own
+	// CORS policy, see CORSConfig.
+	read := cors.Group(r, "read", auth.Require(ScopeRead))
+	read.GET("/tests", testController.GetTests)
+	read.GET("/test/:id", testController.GetTest)
+	read.GET("/test/:id/history", testController.GetTestHistory)
+	read.GET("/stats", testController.GetTestStats)
+	read.GET("/quality", testController.GetTestQuality)
+	read.GET("/flaky", testController.GetFlakyTests)
+	read.GET("/definitions", testController.GetTestDefinitions)
+	read.GET("/definition/:id", testController.GetTestDefinition)
+	read.GET("/definition/:id/tests", testController.GetTestDefinitionTests)
+	read.GET("/runs", runController.GetRuns)
+	read.GET("/runs/compare", runController.CompareRuns)
+	read.GET("/run/:id", runController.GetRun)
+	read.POST("/query", EncodeSearchQuery)
+
+	ingest := cors.Group(r, "ingest", auth.Require(ScopeIngest))
+	ingest.POST("/test", testController.CreateTest)
+	ingest.POST("/tests", testController.CreateTests)
+	ingest.POST("/import/junit", testController.ImportJUnit)
+	ingest.POST("/import/gotest", testController.ImportGoTest)
+	ingest.POST("/import/cucumber", testController.ImportCucumber)
+	ingest.POST("/run", runController.CreateRun)
+	ingest.POST("/run/:id/finish", runController.FinishRun)
+
+	enrich := cors.Group(r, "enrich", auth.Require(ScopeEnrich))
+	enrich.PATCH("/tests", testController.PatchTests)
+	enrich.PATCH("/test/:id", testController.PatchTest)
+	enrich.POST("/flaky", testController.MarkFlakyTests)
+
+	admin := cors.Group(r, "admin", auth.Require(ScopeAdmin))
+	admin.DELETE("/tests", testController.DeleteTests)
+	admin.DELETE("/test/:id", testController.DeleteTest)
+	admin.POST("/tests/restore", testController.RestoreTests)
+	admin.POST("/keys", apiKeyController.CreateAPIKey)
+	admin.GET("/keys", apiKeyController.GetAPIKeys)
+	admin.DELETE("/keys/:id", apiKeyController.RevokeAPIKey)
 
-	r.GET("/tests", testController.GetTests)
-	r.PATCH("/tests", testController.PatchTests)
-	r.DELETE("/tests", testController.DeleteTests)
-	r.POST("/test", testController.CreateTest)
-	r.GET("/health", func(c *gin.Context) {
+	public := cors.Group(r, "public")
+	public.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"health": "healthy"})
 		return
 	})
 
-	r.POST("/query", EncodeSearchQuery)
 	return r
 }
 
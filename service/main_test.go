@@ -12,6 +12,36 @@ func TestGetConfig(t *testing.T) {
 	if config.PG == nil {
 		t.Error("PG config was null")
 	}
+	assert.Equal(t, config.CORS.AllowOrigins, []string{"*"})
+	assert.Equal(t, config.CORS.ExposeHeaders, []string{"ETag", "X-Request-ID"})
+	assert.Equal(t, config.Definitions.Fields, []string{"summary"})
+}
+
+// TestDefinitionConfig_Validate will ensure that identity fields can only be the summary or paths into the doc
+func TestDefinitionConfig_Validate(t *testing.T) {
+	validConfigs := []*DefinitionConfig{
+		{Fields: []string{"summary"}},
+		{Fields: []string{"summary", "doc.nodeid"}},
+		{Fields: []string{"doc.package", "doc.class.name"}},
+	}
+	for _, config := range validConfigs {
+		if err := config.Validate(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	invalidConfigs := []*DefinitionConfig{
+		{},
+		{Fields: []string{"outcome"}},
+		{Fields: []string{"summary", "doc."}},
+		{Fields: []string{"summary", "summary"}},
+		{Fields: []string{"doc.nodeid", "doc.nodeid"}},
+	}
+	for _, config := range invalidConfigs {
+		if err := config.Validate(); err == nil {
+			t.Errorf("invalid config %+v did not throw error", config)
+		}
+	}
 }
 
 func TestGetRouter(t *testing.T) {
@@ -19,28 +49,244 @@ func TestGetRouter(t *testing.T) {
 	routes := router.Routes()
 
 	expectedRoutes := []gin.RouteInfo{
+		{
+			Method:      http.MethodOptions,
+			Path:        "/run",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/runs",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/runs/compare",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/run/:id",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/run/:id/finish",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/test",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/test/:id",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/test/:id/history",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/tests",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/tests/restore",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/definition/:id",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/definition/:id/tests",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/definitions",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/import/junit",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/import/gotest",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/import/cucumber",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/quality",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/query",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/keys",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/keys/:id",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/stats",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/flaky",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodOptions,
+			Path:        "/health",
+			Handler:     "github.com/ryandem1/oar.(*CORS).preflight-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/test/:id",
+			Handler:     "github.com/ryandem1/oar.(*TestController).GetTest-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/test/:id/history",
+			Handler:     "github.com/ryandem1/oar.(*TestController).GetTestHistory-fm",
+			HandlerFunc: nil,
+		},
 		{
 			Method:      http.MethodGet,
 			Path:        "/tests",
 			Handler:     "github.com/ryandem1/oar.(*TestController).GetTests-fm",
 			HandlerFunc: nil,
 		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/definition/:id",
+			Handler:     "github.com/ryandem1/oar.(*TestController).GetTestDefinition-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/definition/:id/tests",
+			Handler:     "github.com/ryandem1/oar.(*TestController).GetTestDefinitionTests-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/definitions",
+			Handler:     "github.com/ryandem1/oar.(*TestController).GetTestDefinitions-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/runs",
+			Handler:     "github.com/ryandem1/oar.(*RunController).GetRuns-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/runs/compare",
+			Handler:     "github.com/ryandem1/oar.(*RunController).CompareRuns-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/run/:id",
+			Handler:     "github.com/ryandem1/oar.(*RunController).GetRun-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/stats",
+			Handler:     "github.com/ryandem1/oar.(*TestController).GetTestStats-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/quality",
+			Handler:     "github.com/ryandem1/oar.(*TestController).GetTestQuality-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/flaky",
+			Handler:     "github.com/ryandem1/oar.(*TestController).GetFlakyTests-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/keys",
+			Handler:     "github.com/ryandem1/oar.(*APIKeyController).GetAPIKeys-fm",
+			HandlerFunc: nil,
+		},
 		{
 			Method:      http.MethodGet,
 			Path:        "/health",
-			Handler:     "github.com/ryandem1/oar.GetRouter.func2",
+			Handler:     "github.com/ryandem1/oar.GetRouter.func1",
 			HandlerFunc: nil,
 		},
 		{
-			Method:      http.MethodPatch,
-			Path:        "/tests",
-			Handler:     "github.com/ryandem1/oar.(*TestController).PatchTests-fm",
+			Method:      http.MethodPost,
+			Path:        "/import/junit",
+			Handler:     "github.com/ryandem1/oar.(*TestController).ImportJUnit-fm",
 			HandlerFunc: nil,
 		},
 		{
-			Method:      http.MethodDelete,
-			Path:        "/tests",
-			Handler:     "github.com/ryandem1/oar.(*TestController).DeleteTests-fm",
+			Method:      http.MethodPost,
+			Path:        "/import/gotest",
+			Handler:     "github.com/ryandem1/oar.(*TestController).ImportGoTest-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/import/cucumber",
+			Handler:     "github.com/ryandem1/oar.(*TestController).ImportCucumber-fm",
 			HandlerFunc: nil,
 		},
 		{
@@ -49,12 +295,78 @@ func TestGetRouter(t *testing.T) {
 			Handler:     "github.com/ryandem1/oar.(*TestController).CreateTest-fm",
 			HandlerFunc: nil,
 		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/tests",
+			Handler:     "github.com/ryandem1/oar.(*TestController).CreateTests-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/tests/restore",
+			Handler:     "github.com/ryandem1/oar.(*TestController).RestoreTests-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/run",
+			Handler:     "github.com/ryandem1/oar.(*RunController).CreateRun-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/run/:id/finish",
+			Handler:     "github.com/ryandem1/oar.(*RunController).FinishRun-fm",
+			HandlerFunc: nil,
+		},
 		{
 			Method:      http.MethodPost,
 			Path:        "/query",
 			Handler:     "github.com/ryandem1/oar.EncodeSearchQuery",
 			HandlerFunc: nil,
 		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/flaky",
+			Handler:     "github.com/ryandem1/oar.(*TestController).MarkFlakyTests-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/keys",
+			Handler:     "github.com/ryandem1/oar.(*APIKeyController).CreateAPIKey-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodPatch,
+			Path:        "/tests",
+			Handler:     "github.com/ryandem1/oar.(*TestController).PatchTests-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodPatch,
+			Path:        "/test/:id",
+			Handler:     "github.com/ryandem1/oar.(*TestController).PatchTest-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodDelete,
+			Path:        "/tests",
+			Handler:     "github.com/ryandem1/oar.(*TestController).DeleteTests-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodDelete,
+			Path:        "/test/:id",
+			Handler:     "github.com/ryandem1/oar.(*TestController).DeleteTest-fm",
+			HandlerFunc: nil,
+		},
+		{
+			Method:      http.MethodDelete,
+			Path:        "/keys/:id",
+			Handler:     "github.com/ryandem1/oar.(*APIKeyController).RevokeAPIKey-fm",
+			HandlerFunc: nil,
+		},
 	}
 	for i, expectedRoute := range expectedRoutes {
 		assert.Equal(t, routes[i].Method, expectedRoute.Method)
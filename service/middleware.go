@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+const (
+	// RequestIDHeader is the header that carries the ID of a request, in both the request and the response
+	RequestIDHeader = "X-Request-ID"
+	// RequestIDKey is the gin context key of the ID of a request
+	RequestIDKey = "requestID"
+	// ActorKey is the gin context key of who is making a request, it is set by authentication
+	ActorKey = "actor"
+)
+
+// maxRequestIDLength is the longest request ID that will be taken from a caller
+const maxRequestIDLength = 128
+
+// RequestID is a middleware that will give every request an ID, so that changes can be traced back to the request
+// that made them in the test history. If the caller sends an X-Request-ID header it is used as the ID, otherwise a
+// random ID is generated. The ID is set on the gin context and sent back in the X-Request-ID response header.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			randomID := make([]byte, 16)
+			if _, err := rand.Read(randomID); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, ConvertErrToGinH(err))
+				return
+			}
+			requestID = hex.EncodeToString(randomID)
+		}
+
+		c.Set(RequestIDKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+		c.Next()
+	}
+}
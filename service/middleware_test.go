@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/magiconair/properties/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRequestID will ensure that every request gets an ID, and that the ID of the caller is kept
+func TestRequestID(t *testing.T) {
+	handler := RequestID()
+
+	t.Run("generated", func(t *testing.T) {
+		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodGet, "/tests", nil)
+
+		handler(c)
+		requestID := c.GetString(RequestIDKey)
+		assert.Equal(t, len(requestID), 32)
+		assert.Equal(t, w.Header().Get(RequestIDHeader), requestID)
+		assert.Equal(t, BindAudit(c).RequestID, requestID)
+	})
+
+	t.Run("from the caller", func(t *testing.T) {
+		c, w := Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodGet, "/tests", nil)
+		c.Request.Header.Set(RequestIDHeader, "ci-run-42")
+
+		handler(c)
+		assert.Equal(t, c.GetString(RequestIDKey), "ci-run-42")
+		assert.Equal(t, w.Header().Get(RequestIDHeader), "ci-run-42")
+	})
+
+	t.Run("too long from the caller", func(t *testing.T) {
+		c, _ := Fake.ginContext()
+		c.Request = httptest.NewRequest(http.MethodGet, "/tests", nil)
+		c.Request.Header.Set(RequestIDHeader, strings.Repeat("a", maxRequestIDLength+1))
+
+		handler(c)
+		assert.Equal(t, len(c.GetString(RequestIDKey)), 32)
+	})
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"github.com/google/go-cmp/cmp"
 	"golang.org/x/exp/slices"
+	"math"
+	"reflect"
 	"strings"
 	"time"
 )
@@ -15,15 +20,21 @@ import (
 // The Analysis is the 'A' and will most likely be done after the initial Test upload.
 // The Resolution is the 'R' and will most likely take place after the Analysis
 // The Doc is a free form JSON document that can be used to store any sort of metadata about the Test
+// Deleted is when the Test was soft deleted, deleted tests are kept until they are purged and can be restored
+// DefinitionID is the TestDefinition that the Test is a result of, it is set every time the Test is written
+// RunID is the Run that the Test was reported in, it can only be set when the Test is created
 type Test struct {
-	ID         uint64         `json:"id"`
-	Summary    string         `json:"summary"`
-	Outcome    Outcome        `json:"outcome"`
-	Analysis   Analysis       `json:"analysis"`
-	Resolution Resolution     `json:"resolution"`
-	Created    time.Time      `json:"created"`
-	Modified   time.Time      `json:"modified"`
-	Doc        map[string]any `json:"doc"`
+	ID           uint64         `json:"id"`
+	Summary      string         `json:"summary"`
+	Outcome      Outcome        `json:"outcome"`
+	Analysis     Analysis       `json:"analysis"`
+	Resolution   Resolution     `json:"resolution"`
+	Created      time.Time      `json:"created"`
+	Modified     time.Time      `json:"modified"`
+	Doc          map[string]any `json:"doc"`
+	Deleted      *time.Time     `json:"deleted,omitempty"`
+	DefinitionID *uint64        `json:"definitionId,omitempty"`
+	RunID        *uint64        `json:"runId,omitempty"`
 }
 
 // Validate will ensure that a Test has a valid Outcome, Analysis, and Resolution and a non-blank Summary.
@@ -77,6 +88,44 @@ func (t *Test) Clean() {
 	}
 }
 
+// ETag will return the entity tag of the current version of the test, which changes every time the test is modified.
+// See: https://www.rfc-editor.org/rfc/rfc9110#name-etag
+func (t *Test) ETag() string {
+	return fmt.Sprintf(`"%d-%d"`, t.ID, t.Modified.UnixMicro())
+}
+
+// Identity will return the value of each identity field of the test, by field. A field is either "summary" or a path
+// into the Doc, like "doc.nodeid". The value of a path that is not in the Doc is nil.
+func (t *Test) Identity(fields []string) map[string]any {
+	identity := make(map[string]any, len(fields))
+	for _, field := range fields {
+		docPath, ok := parseDocPath(field)
+		if !ok {
+			identity[field] = t.Summary
+			continue
+		}
+
+		var value any = t.Doc
+		for _, key := range docPath {
+			object, _ := value.(map[string]any)
+			value = object[key]
+		}
+		identity[field] = value
+	}
+	return identity
+}
+
+// Fingerprint will return the hex encoded SHA-256 hash of the JSON of an identity. The keys of JSON objects are
+// sorted, so equal identities always have the same fingerprint.
+func Fingerprint(identity map[string]any) (string, error) {
+	jsonIdentity, err := json.Marshal(identity)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(jsonIdentity)
+	return hex.EncodeToString(hash[:]), nil
+}
+
 // Merge will right-merge the current test instance with a different instance of a test. All values that are in the test
 // to be merged with will be preferred.
 func (t *Test) Merge(testPatch *Test) {
@@ -93,6 +142,9 @@ func (t *Test) Merge(testPatch *Test) {
 		t.Resolution = testPatch.Resolution
 	}
 	if testPatch.Doc != nil && len(testPatch.Doc) > 0 {
+		if t.Doc == nil {
+			t.Doc = map[string]any{}
+		}
 		for k, v := range testPatch.Doc {
 			t.Doc[k] = v
 		}
@@ -126,6 +178,27 @@ func (t *Test) Equal(comparedTest *Test) bool {
 // For the OAR attributes, there is no need to store them as enums here, there is not going to be any checking for
 // valid values when querying, if something is invalid, it will simply not match. It is up to the caller to properly
 // craft a TestQuery
+//
+// Summaries are case-insensitive regular expressions, a test matches if its Summary matches any of them. Search is a
+// keyword search over the Summary and every string in the Doc, it accepts web search syntax like quoted phrases,
+// "or" and "-" to exclude a word. For more information, see:
+// https://www.postgresql.org/docs/current/textsearch-controls.html
+//
+// DocFilters are predicates on paths into the Doc, like "doc.duration > 30". Each DocFilter is treated as its own
+// attribute, so multiple DocFilters are a logical 'AND'.
+//
+// Filter is a nested boolean filter tree for anything that cannot be expressed with the flat attributes, like
+// "Failed AND NOT KnownIssue" or "(env=prod AND Failed) OR FalseNegative". It is treated as its own attribute, so it
+// is a logical 'AND' with the rest of the query.
+//
+// DefinitionIDs match the results of any of the test definitions, see TestDefinition. RunIDs match the results that
+// were reported in any of the runs, see Run.
+//
+// Soft deleted tests are not matched unless IncludeDeleted is true. IncludeDeleted can only be set on the top level
+// query, not on a Filter.
+//
+// Sort will order the results by each TestSort in turn. Without it, results are ordered by how well they match the
+// Search, or if there is no Search, by the most recently created.
 type TestQuery struct {
 	IDs            []uint64         `json:"ids,omitempty"`
 	Summaries      []string         `json:"summaries,omitempty"`
@@ -137,11 +210,531 @@ type TestQuery struct {
 	ModifiedBefore *time.Time       `json:"modifiedBefore,omitempty"`
 	ModifiedAfter  *time.Time       `json:"modifiedAfter,omitempty"`
 	Docs           []map[string]any `json:"docs,omitempty"`
+	DocFilters     []DocFilter      `json:"docFilters,omitempty"`
+	Search         string           `json:"search,omitempty"`
+	Filter         *TestFilter      `json:"filter,omitempty"`
+	Sort           []TestSort       `json:"sort,omitempty"`
+	IncludeDeleted bool             `json:"includeDeleted,omitempty"`
+	Changes        []TestChange     `json:"changes,omitempty"`
+	DefinitionIDs  []uint64         `json:"definitionIds,omitempty"`
+	RunIDs         []uint64         `json:"runIds,omitempty"`
+}
+
+// DocFilter is a predicate on a path into a Test's Doc, like "doc.env" or "doc.latency.p50". The Op decides how the
+// value at the path is checked:
+//
+//   - "exists" and "missing" check if the path is in the Doc, they take no value.
+//   - "=" and "!=" compare the value at the path with the JSON Value. A test that is missing the path is never
+//     matched by "!=".
+//   - "<", "<=", ">" and ">=" compare a number at the path with a number Value.
+//   - "between" checks that a number at the path is within the 2 number Values, inclusive.
+//   - "in" checks that the value at the path equals any of the Values. A test that is missing the path is never
+//     matched, even if a Value is null, see "missing".
+//   - "regex" checks that a string at the path matches the case-insensitive regular expression Value.
+//   - "contains" checks that an array at the path contains the Value, or all the Values if Value is an array.
+type DocFilter struct {
+	Path   string      `json:"path"`
+	Op     DocOperator `json:"op"`
+	Value  any         `json:"value,omitempty"`
+	Values []any       `json:"values,omitempty"`
+}
+
+// TestFilter is a node of a boolean filter tree. Each node must have exactly 1 of: And, where every child filter must
+// match, Or, where any child filter must match, Not, where the child filter must not match, or Query, which is a leaf
+// that matches the same way as a TestQuery. A Query leaf cannot have a Sort.
+//
+// For example, "Failed AND NOT resolution=KnownIssue" is:
+//
+//	{"and": [{"query": {"outcomes": ["Failed"]}}, {"not": {"query": {"resolutions": ["KnownIssue"]}}}]}
+type TestFilter struct {
+	And   []*TestFilter `json:"and,omitempty"`
+	Or    []*TestFilter `json:"or,omitempty"`
+	Not   *TestFilter   `json:"not,omitempty"`
+	Query *TestQuery    `json:"query,omitempty"`
+}
+
+// TestChange matches tests by their history. A test matches if it has at least 1 update that changed the Field, made by
+// the Actor, between the After and Before timestamps. Every attribute is optional, so an empty TestChange matches
+// every test that was ever updated. The Field can be any of the structured Test fields (summary, outcome, analysis,
+// resolution), "doc" for any Doc change or a path into the Doc, like "doc.owner".
+//
+// For example, tests whose analysis was changed by jane in the last week:
+//
+//	{"changes": [{"field": "analysis", "actor": "jane", "after": "2023-01-01T00:00:00Z"}]}
+type TestChange struct {
+	Field  string     `json:"field,omitempty"`
+	Actor  string     `json:"actor,omitempty"`
+	After  *time.Time `json:"after,omitempty"`
+	Before *time.Time `json:"before,omitempty"`
+}
+
+// TestSort is a single sort key of a TestQuery. The Key can be any of the structured Test fields (id, created,
+// modified, summary, outcome, analysis, resolution) or a path into the Doc, like "doc.duration". The Direction is
+// either "asc" or "desc" and will default to "asc".
+type TestSort struct {
+	Key       string `json:"key"`
+	Direction string `json:"direction,omitempty"`
 }
 
 // TestQueryResponse is what a query request will return. Includes the return results, as well as metadata about the
-// response.
+// response. Count is the amount of tests in this page of results, while Total is the amount of tests that match the
+// query across all pages. For very large results, Total will be estimated and TotalEstimated will be true.
+// NextCursor can be passed back in to get the next page of results; it will be empty if there are no more results.
 type TestQueryResponse struct {
-	Count uint64  `json:"count"`
-	Tests []*Test `json:"tests"`
+	Count          uint64  `json:"count"`
+	Total          uint64  `json:"total"`
+	TotalEstimated bool    `json:"totalEstimated"`
+	NextCursor     string  `json:"nextCursor,omitempty"`
+	Tests          []*Test `json:"tests"`
+}
+
+// TestCursor is the position of the last test in a page of query results. Queries are ordered by Created and then ID,
+// so the next page can be found with keyset pagination instead of a slow offset. It is passed around base64 encoded
+// so that callers can treat it as opaque.
+type TestCursor struct {
+	Created time.Time `json:"created"`
+	ID      uint64    `json:"id"`
+}
+
+// TestBatchResult is the result of a single test in a batch creation request. Index is the position of the test in
+// the batch. ID will be set if the test was created, otherwise Error will describe why it was not.
+type TestBatchResult struct {
+	Index int    `json:"index"`
+	ID    uint64 `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// TestBatchResponse is what a batch creation request will return. Count is the amount of tests that were created,
+// which will either be all the tests in the batch or none of them.
+type TestBatchResponse struct {
+	Count   int                `json:"count"`
+	Results []*TestBatchResult `json:"results"`
+}
+
+// TestPatchResponse is what a batch patch request will return. Matched is the amount of tests that matched the query
+// and Count is the amount of them that were changed by the patch. On a dry run nothing is written and Tests has the
+// post-merge values of the tests that would change, up to the first 1000 of them.
+type TestPatchResponse struct {
+	Matched uint64  `json:"matched"`
+	Count   uint64  `json:"count"`
+	DryRun  bool    `json:"dryRun"`
+	Tests   []*Test `json:"tests,omitempty"`
+}
+
+// TestDeleteResponse is what a bulk delete request will return. Count is the amount of tests that were soft deleted, or
+// that would be deleted on a dry run. IDs are only returned on a dry run, up to the first 1000 of them.
+type TestDeleteResponse struct {
+	Count  uint64   `json:"count"`
+	DryRun bool     `json:"dryRun"`
+	IDs    []uint64 `json:"ids,omitempty"`
+}
+
+// TestRestoreResponse is what a restore request will return. Count is the amount of soft deleted tests that were
+// restored and IDs are their IDs.
+type TestRestoreResponse struct {
+	Count uint64   `json:"count"`
+	IDs   []uint64 `json:"ids"`
+}
+
+// Audit attributes a change to a test. Actor is who made the change and RequestID is the request it was made by. It
+// is recorded in the history of every test that the change touches.
+type Audit struct {
+	Actor     string
+	RequestID string
+}
+
+// TestHistory is a single recorded change of a test. Before and After are snapshots of the test in the database,
+// Before is null for an INSERT and After is null for a PURGE. Fields are the fields and Doc keys that were changed.
+// Actor and RequestID are only set if they were known when the change was made. History is kept after a test is
+// purged.
+type TestHistory struct {
+	ID        uint64         `json:"id"`
+	TestID    uint64         `json:"testId"`
+	Operation TestOperation  `json:"operation"`
+	Fields    []string       `json:"fields"`
+	Before    map[string]any `json:"before"`
+	After     map[string]any `json:"after"`
+	Actor     string         `json:"actor,omitempty"`
+	RequestID string         `json:"requestId,omitempty"`
+	Timestamp time.Time      `json:"timestamp"`
+}
+
+// ChangedFields will return the sorted fields that are different between the Before and After snapshots. Changes to
+// the Doc are listed by key, like "doc.owner". The modified timestamp is not a change on its own, so it is left out.
+func (h *TestHistory) ChangedFields() []string {
+	fields := []string{}
+	for _, field := range []string{"summary", "outcome", "analysis", "resolution", "created", "deleted"} {
+		if !reflect.DeepEqual(h.Before[field], h.After[field]) {
+			fields = append(fields, field)
+		}
+	}
+
+	beforeDoc, _ := h.Before["doc"].(map[string]any)
+	afterDoc, _ := h.After["doc"].(map[string]any)
+	for key, value := range beforeDoc {
+		if afterValue, ok := afterDoc[key]; !ok || !reflect.DeepEqual(value, afterValue) {
+			fields = append(fields, "doc."+key)
+		}
+	}
+	for key := range afterDoc {
+		if _, ok := beforeDoc[key]; !ok {
+			fields = append(fields, "doc."+key)
+		}
+	}
+
+	slices.Sort(fields)
+	return fields
+}
+
+// TestHistoryResponse is what a test history request will return. History has every recorded change of the test,
+// oldest first.
+type TestHistoryResponse struct {
+	Count   int            `json:"count"`
+	History []*TestHistory `json:"history"`
+}
+
+// TestStats are the aggregate statistics of a group of tests. Key has the value of each group by dimension for the
+// group, by dimension. Ratio is the share of all matching tests that are in the group, and FailureRate is the share of
+// the tests in the group that Failed.
+type TestStats struct {
+	Key         map[string]any `json:"key"`
+	Count       uint64         `json:"count"`
+	Ratio       float64        `json:"ratio"`
+	Passed      uint64         `json:"passed"`
+	Failed      uint64         `json:"failed"`
+	FailureRate float64        `json:"failureRate"`
+}
+
+// TestStatsResponse is what a stats request will return. Total is the amount of tests that match the query across
+// all groups, and Count is the amount of Groups. Without any GroupBy dimensions, there is a single group of every
+// matching test.
+type TestStatsResponse struct {
+	Total   uint64       `json:"total"`
+	GroupBy []string     `json:"groupBy"`
+	Count   int          `json:"count"`
+	Groups  []*TestStats `json:"groups"`
+}
+
+// ConfusionMatrix counts analyzed tests by their Analysis. A positive is a Failed test, which is true if it found a
+// real problem. A negative is a Passed test, which is false if it missed a real problem.
+type ConfusionMatrix struct {
+	TruePositive  uint64 `json:"truePositive"`
+	FalsePositive uint64 `json:"falsePositive"`
+	TrueNegative  uint64 `json:"trueNegative"`
+	FalseNegative uint64 `json:"falseNegative"`
+}
+
+// TestQuality are the quality metrics of a group of tests, computed from their ConfusionMatrix. Key has the value of
+// each group by dimension for the group, the same as TestStats. Analyzed and NotAnalyzed count the tests that have
+// and have not been analyzed, and Coverage is the share of the tests that have been.
+//
+// Precision is the share of failures that found a real problem, Recall is the share of real problems that were found
+// by a failure and FalsePositiveRate is the share of tests without a real problem that failed anyway. A metric is null
+// if none of the tests it is computed from have been analyzed.
+type TestQuality struct {
+	Key               map[string]any  `json:"key"`
+	Count             uint64          `json:"count"`
+	Analyzed          uint64          `json:"analyzed"`
+	NotAnalyzed       uint64          `json:"notAnalyzed"`
+	ConfusionMatrix   ConfusionMatrix `json:"confusionMatrix"`
+	Precision         *float64        `json:"precision"`
+	Recall            *float64        `json:"recall"`
+	FalsePositiveRate *float64        `json:"falsePositiveRate"`
+	Coverage          *float64        `json:"coverage"`
+}
+
+// ComputeMetrics will compute the Analyzed count and every ratio of the test quality from its counts
+func (q *TestQuality) ComputeMetrics() {
+	m := q.ConfusionMatrix
+	q.Analyzed = m.TruePositive + m.FalsePositive + m.TrueNegative + m.FalseNegative
+	q.Precision = ratio(m.TruePositive, m.TruePositive+m.FalsePositive)
+	q.Recall = ratio(m.TruePositive, m.TruePositive+m.FalseNegative)
+	q.FalsePositiveRate = ratio(m.FalsePositive, m.FalsePositive+m.TrueNegative)
+	q.Coverage = ratio(q.Analyzed, q.Analyzed+q.NotAnalyzed)
+}
+
+// ratio will return the ratio of a part to a whole, or nil if the whole is 0
+func ratio(part uint64, whole uint64) *float64 {
+	if whole == 0 {
+		return nil
+	}
+	r := float64(part) / float64(whole)
+	return &r
+}
+
+// NoisyTest is a test, by Summary, whose failures are often false alarms. FalseAlarmRate is the share of its Failed
+// results that were analyzed as a FalsePositive.
+type NoisyTest struct {
+	Summary        string  `json:"summary"`
+	Count          uint64  `json:"count"`
+	Failed         uint64  `json:"failed"`
+	FalsePositives uint64  `json:"falsePositives"`
+	FalseAlarmRate float64 `json:"falseAlarmRate"`
+}
+
+// TestQualityResponse is what a test quality request will return. Total is the amount of tests that match the query
+// across all groups, and Count is the amount of Groups. Without any GroupBy dimensions, there is a single group of
+// every matching test. Noisiest are the tests with the most false positives across all groups, noisiest first.
+type TestQualityResponse struct {
+	Total    uint64         `json:"total"`
+	GroupBy  []string       `json:"groupBy"`
+	Count    int            `json:"count"`
+	Groups   []*TestQuality `json:"groups"`
+	Noisiest []*NoisyTest   `json:"noisiest"`
+}
+
+// FlakinessOptions are the options of a flaky test analysis. Tests are identified by their summary and the values of
+// the Identity paths into the Doc, like "doc.package". Only the most recent Runs results of each test are analyzed,
+// and only results from the last Days if it is not 0. A test needs at least MinRuns results in the window and a
+// flakiness score of at least the Threshold to be flaky. Limit is the max amount of flaky tests to return.
+type FlakinessOptions struct {
+	Identity  []string
+	Runs      int
+	Days      int
+	MinRuns   int
+	Threshold float64
+	Limit     int
+}
+
+// FlakyTest is the flakiness of a test over its most recent results, oldest to newest. Flips are the amount of times
+// the outcome changed from one result to the next, and FlipRate is the share of consecutive results that flipped.
+// FailureRate is the share of results that Failed. TestIDs are the IDs of the results in the window, oldest first.
+//
+// The Score is the product of the FlipRate and how evenly the results are split between Passed and Failed, from
+// 0 for a test that always has the same outcome to 1 for a test that alternates between them. A test that broke and
+// was fixed has few flips for its failures, so it scores lower than a test that fails as often at random.
+type FlakyTest struct {
+	Identity    map[string]any `json:"identity"`
+	Runs        uint64         `json:"runs"`
+	Failures    uint64         `json:"failures"`
+	Flips       uint64         `json:"flips"`
+	FlipRate    float64        `json:"flipRate"`
+	FailureRate float64        `json:"failureRate"`
+	Score       float64        `json:"score"`
+	LastOutcome Outcome        `json:"lastOutcome"`
+	FirstRun    time.Time      `json:"firstRun"`
+	LastRun     time.Time      `json:"lastRun"`
+	TestIDs     []uint64       `json:"testIds"`
+}
+
+// ComputeScore will compute the FlipRate, FailureRate and Score of the flaky test from its counts
+func (f *FlakyTest) ComputeScore() {
+	f.FlipRate, f.FailureRate, f.Score = 0, 0, 0
+	if f.Runs < 2 {
+		return
+	}
+
+	f.FlipRate = float64(f.Flips) / float64(f.Runs-1)
+	f.FailureRate = float64(f.Failures) / float64(f.Runs)
+	balance := 1 - math.Abs(1-2*f.FailureRate)
+	f.Score = f.FlipRate * balance
+}
+
+// FlakyTestsResponse is what a flaky test analysis will return. Tests are the flaky tests, flakiest first, and Count
+// is the amount of them. Marked is the amount of results that a flaky marker was added to.
+type FlakyTestsResponse struct {
+	Count  int          `json:"count"`
+	Marked uint64       `json:"marked"`
+	Tests  []*FlakyTest `json:"tests"`
+}
+
+// A TestDefinition is a test that results are reported for, where a Test is a single result of it. Results are linked
+// to their definition by the Fingerprint of their Identity, see DefinitionConfig. The Summary is the summary of the
+// first result, and LastSeen is when a result was last linked to the definition.
+//
+// Results is the count of results of the definition, and Latest is its most recent result. Soft deleted results are
+// left out of both.
+type TestDefinition struct {
+	ID          uint64         `json:"id"`
+	Fingerprint string         `json:"fingerprint"`
+	Summary     string         `json:"summary"`
+	Identity    map[string]any `json:"identity"`
+	Created     time.Time      `json:"created"`
+	LastSeen    time.Time      `json:"lastSeen"`
+	Results     uint64         `json:"results"`
+	Latest      *Test          `json:"latest"`
+}
+
+// TestDefinitionsResponse is what a test definition query will return. Count is the amount of Definitions returned,
+// most recently seen first.
+type TestDefinitionsResponse struct {
+	Count       int               `json:"count"`
+	Definitions []*TestDefinition `json:"definitions"`
+}
+
+// A Run groups the results of a single execution of a test suite, like a CI build. Results are attached to a run by
+// their RunID when they are created, until the run is Finished. The Branch, Commit and Environment of the run are
+// optional, and the Doc is a free form JSON document for any other metadata, like the URL of the build.
+type Run struct {
+	ID          uint64         `json:"id"`
+	Name        string         `json:"name"`
+	Branch      string         `json:"branch"`
+	Commit      string         `json:"commit"`
+	Environment string         `json:"environment"`
+	Doc         map[string]any `json:"doc"`
+	Started     time.Time      `json:"started"`
+	Finished    *time.Time     `json:"finished,omitempty"`
+}
+
+// Clean will trim the whitespace around the Name and metadata of a Run
+func (r *Run) Clean() {
+	r.Name = strings.TrimSpace(r.Name)
+	r.Branch = strings.TrimSpace(r.Branch)
+	r.Commit = strings.TrimSpace(r.Commit)
+	r.Environment = strings.TrimSpace(r.Environment)
+}
+
+// Validate will ensure that a Run has a non-blank Name
+func (r *Run) Validate() error {
+	if len(strings.TrimSpace(r.Name)) < 1 {
+		return fmt.Errorf("name cannot be blank")
+	}
+	return nil
+}
+
+// RunSummary is a Run with the totals of its results. Soft deleted results are left out. Duration is how many seconds
+// the run took, or has taken so far if it is not finished.
+type RunSummary struct {
+	Run
+	Duration        float64         `json:"duration"`
+	Total           uint64          `json:"total"`
+	Passed          uint64          `json:"passed"`
+	Failed          uint64          `json:"failed"`
+	NotAnalyzed     uint64          `json:"notAnalyzed"`
+	ConfusionMatrix ConfusionMatrix `json:"confusionMatrix"`
+}
+
+// RunsResponse is what a run query will return. Count is the amount of Runs returned, most recently started first.
+type RunsResponse struct {
+	Count int           `json:"count"`
+	Runs  []*RunSummary `json:"runs"`
+}
+
+// RunTestComparison is a test that is compared between 2 runs, by its test definition. The Base and Head are the
+// latest results of the test in each run, nil if the test does not have a result in that run.
+type RunTestComparison struct {
+	DefinitionID uint64 `json:"definitionId"`
+	Summary      string `json:"summary"`
+	Base         *Test  `json:"base"`
+	Head         *Test  `json:"head"`
+}
+
+// RunComparison is the comparison of a Head run with a Base run, like a CI build of a branch with the latest build of
+// the main branch. Tests are matched by their test definition and compared by the Outcome of their latest result in
+// each run:
+//
+//   - Regressions are the tests that Failed in the Head run and Passed in the Base run.
+//   - Fixed are the tests that Passed in the Head run and Failed in the Base run.
+//   - StillFailing are the tests that Failed in both runs.
+//   - Added are the tests that only have a result in the Head run, Removed only have a result in the Base run.
+//
+// Unchanged is the count of tests that Passed in both runs, they are not listed.
+type RunComparison struct {
+	Base         *RunSummary          `json:"base"`
+	Head         *RunSummary          `json:"head"`
+	Regressions  []*RunTestComparison `json:"regressions"`
+	Fixed        []*RunTestComparison `json:"fixed"`
+	StillFailing []*RunTestComparison `json:"stillFailing"`
+	Added        []*RunTestComparison `json:"added"`
+	Removed      []*RunTestComparison `json:"removed"`
+	Unchanged    int                  `json:"unchanged"`
+}
+
+// Compare will match the latest results of the Base and Head runs by their test definition and sort them into the
+// comparison. Results that are not linked to a test definition cannot be matched, so they are left out. Each list is
+// ordered by summary.
+func (rc *RunComparison) Compare(baseTests []*Test, headTests []*Test) {
+	tests := map[uint64]*RunTestComparison{}
+	testComparison := func(test *Test) *RunTestComparison {
+		if _, ok := tests[*test.DefinitionID]; !ok {
+			tests[*test.DefinitionID] = &RunTestComparison{DefinitionID: *test.DefinitionID, Summary: test.Summary}
+		}
+		return tests[*test.DefinitionID]
+	}
+	for _, test := range baseTests {
+		if test.DefinitionID != nil {
+			testComparison(test).Base = test
+		}
+	}
+	for _, test := range headTests {
+		if test.DefinitionID != nil {
+			comparison := testComparison(test)
+			comparison.Summary = test.Summary
+			comparison.Head = test
+		}
+	}
+
+	rc.Regressions, rc.Fixed, rc.StillFailing = []*RunTestComparison{}, []*RunTestComparison{}, []*RunTestComparison{}
+	rc.Added, rc.Removed, rc.Unchanged = []*RunTestComparison{}, []*RunTestComparison{}, 0
+	for _, comparison := range tests {
+		switch {
+		case comparison.Base == nil:
+			rc.Added = append(rc.Added, comparison)
+		case comparison.Head == nil:
+			rc.Removed = append(rc.Removed, comparison)
+		case comparison.Head.Outcome == Failed && comparison.Base.Outcome == Passed:
+			rc.Regressions = append(rc.Regressions, comparison)
+		case comparison.Head.Outcome == Passed && comparison.Base.Outcome == Failed:
+			rc.Fixed = append(rc.Fixed, comparison)
+		case comparison.Head.Outcome == Failed:
+			rc.StillFailing = append(rc.StillFailing, comparison)
+		default:
+			rc.Unchanged++
+		}
+	}
+
+	lists := [][]*RunTestComparison{rc.Regressions, rc.Fixed, rc.StillFailing, rc.Added, rc.Removed}
+	for _, comparisons := range lists {
+		slices.SortFunc(comparisons, func(a *RunTestComparison, b *RunTestComparison) bool {
+			if a.Summary != b.Summary {
+				return a.Summary < b.Summary
+			}
+			return a.DefinitionID < b.DefinitionID
+		})
+	}
+}
+
+// An APIKey authenticates a caller of the OAR service. The Scopes decide which endpoints the key can call: ingest can
+// create tests, enrich can patch them, read can query them and admin can call every endpoint, including deletes and
+// API key management. Only a hash of the key is stored, so a lost key cannot be recovered, only revoked.
+type APIKey struct {
+	ID       uint64     `json:"id"`
+	Name     string     `json:"name"`
+	Prefix   string     `json:"prefix"`
+	Scopes   []Scope    `json:"scopes"`
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"lastUsed,omitempty"`
+	Revoked  *time.Time `json:"revoked,omitempty"`
+}
+
+// Validate will return an error if the API key has no name, no scopes or an unknown scope
+func (k *APIKey) Validate() error {
+	if len(strings.TrimSpace(k.Name)) < 1 {
+		return fmt.Errorf("name cannot be blank")
+	}
+
+	if len(k.Scopes) == 0 {
+		return fmt.Errorf("must have at least 1 scope")
+	}
+	validScopes := []Scope{ScopeIngest, ScopeEnrich, ScopeRead, ScopeAdmin}
+	for _, scope := range k.Scopes {
+		if !slices.Contains(validScopes, scope) {
+			return fmt.Errorf("invalid scope: '%s', must be one of scopes: %s", scope, validScopes)
+		}
+	}
+
+	return nil
+}
+
+// APIKeyCreateResponse is what an API key creation request will return. Key is the API key itself, it is only ever
+// returned once, when it is created.
+type APIKeyCreateResponse struct {
+	*APIKey
+	Key string `json:"key"`
+}
+
+// APIKeysResponse is what an API key list request will return
+type APIKeysResponse struct {
+	Count int       `json:"count"`
+	Keys  []*APIKey `json:"keys"`
 }
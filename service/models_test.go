@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/google/go-cmp/cmp"
+	"github.com/magiconair/properties/assert"
 	"strings"
 	"testing"
 	"time"
@@ -125,6 +126,51 @@ func TestTest_Equal(t *testing.T) {
 	})
 }
 
+// TestTest_ETag ensures that the ETag of a test changes when the test is modified
+func TestTest_ETag(t *testing.T) {
+	test := Fake.test()
+	test.ID = 7
+	test.Modified = time.Date(2024, 1, 1, 0, 0, 0, 1000, time.UTC)
+
+	etag := test.ETag()
+	if etag != `"7-1704067200000001"` {
+		t.Errorf("unexpected ETag: %s", etag)
+	}
+
+	test.Modified = test.Modified.Add(time.Microsecond)
+	if test.ETag() == etag {
+		t.Error("ETag did not change when the test was modified")
+	}
+}
+
+// TestTestHistory_ChangedFields will ensure that the changed fields of a history entry include Doc keys but not the
+// modified timestamp
+func TestTestHistory_ChangedFields(t *testing.T) {
+	before := map[string]any{
+		"summary":  "test",
+		"analysis": "NotAnalyzed",
+		"modified": "2023-01-01T00:00:00",
+		"doc":      map[string]any{"env": "dev", "owner": "qa", "tags": []any{"a"}},
+	}
+	after := map[string]any{
+		"summary":  "test",
+		"analysis": "TruePositive",
+		"modified": "2023-01-02T00:00:00",
+		"doc":      map[string]any{"env": "prod", "tags": []any{"a"}, "ticket": "OAR-1"},
+	}
+
+	change := &TestHistory{Operation: TestUpdated, Before: before, After: after}
+	if diff := cmp.Diff(change.ChangedFields(), []string{"analysis", "doc.env", "doc.owner", "doc.ticket"}); diff != "" {
+		t.Error(diff)
+	}
+
+	inserted := &TestHistory{Operation: TestInserted, After: after}
+	expectedFields := []string{"analysis", "doc.env", "doc.tags", "doc.ticket", "summary"}
+	if diff := cmp.Diff(inserted.ChangedFields(), expectedFields); diff != "" {
+		t.Error(diff)
+	}
+}
+
 // TestTest_Merge will check that a test can be successfully right-merged with another test
 func TestTest_Merge(t *testing.T) {
 	t.Run("valid test merge with valid test", func(t *testing.T) {
@@ -178,3 +224,164 @@ func TestTest_Merge(t *testing.T) {
 		}
 	})
 }
+
+// TestTestQuality_ComputeMetrics will ensure that the quality metrics are computed from the confusion matrix, and are
+// null when nothing they are computed from has been analyzed
+func TestTestQuality_ComputeMetrics(t *testing.T) {
+	quality := &TestQuality{
+		Count:       12,
+		NotAnalyzed: 2,
+		ConfusionMatrix: ConfusionMatrix{
+			TruePositive:  3,
+			FalsePositive: 1,
+			TrueNegative:  4,
+			FalseNegative: 2,
+		},
+	}
+	quality.ComputeMetrics()
+
+	assert.Equal(t, quality.Analyzed, uint64(10))
+	assert.Equal(t, *quality.Precision, 0.75)
+	assert.Equal(t, *quality.Recall, 0.6)
+	assert.Equal(t, *quality.FalsePositiveRate, 0.2)
+	assert.Equal(t, *quality.Coverage, 10.0/12.0)
+
+	notAnalyzed := &TestQuality{Count: 5, NotAnalyzed: 5}
+	notAnalyzed.ComputeMetrics()
+	assert.Equal(t, notAnalyzed.Analyzed, uint64(0))
+	assert.Equal(t, notAnalyzed.Precision == nil, true)
+	assert.Equal(t, notAnalyzed.Recall == nil, true)
+	assert.Equal(t, notAnalyzed.FalsePositiveRate == nil, true)
+	assert.Equal(t, *notAnalyzed.Coverage, 0.0)
+
+	empty := &TestQuality{}
+	empty.ComputeMetrics()
+	assert.Equal(t, empty.Coverage == nil, true)
+}
+
+// TestFlakyTest_ComputeScore will ensure that tests that flip often with a balanced failure rate score highest, and
+// that tests with a single result are not scored
+func TestFlakyTest_ComputeScore(t *testing.T) {
+	alternating := &FlakyTest{Runs: 6, Failures: 3, Flips: 5}
+	alternating.ComputeScore()
+	assert.Equal(t, alternating.FlipRate, 1.0)
+	assert.Equal(t, alternating.FailureRate, 0.5)
+	assert.Equal(t, alternating.Score, 1.0)
+
+	mostlyPassing := &FlakyTest{Runs: 10, Failures: 1, Flips: 2}
+	mostlyPassing.ComputeScore()
+	assert.Equal(t, mostlyPassing.FlipRate, 2.0/9.0)
+	assert.Equal(t, mostlyPassing.FailureRate, 0.1)
+	assert.Equal(t, mostlyPassing.Score < alternating.Score, true)
+	assert.Equal(t, mostlyPassing.Score > 0, true)
+
+	intermittent := &FlakyTest{Runs: 10, Failures: 3, Flips: 6}
+	intermittent.ComputeScore()
+	assert.Equal(t, intermittent.Score > 0.25, true)
+
+	brokenOnce := &FlakyTest{Runs: 10, Failures: 5, Flips: 1}
+	brokenOnce.ComputeScore()
+	assert.Equal(t, brokenOnce.Score < 0.25, true)
+
+	single := &FlakyTest{Runs: 1, Failures: 1}
+	single.ComputeScore()
+	assert.Equal(t, single.FlipRate, 0.0)
+	assert.Equal(t, single.FailureRate, 0.0)
+	assert.Equal(t, single.Score, 0.0)
+}
+
+// TestTest_Identity will ensure that the identity of a test has the summary and the value of each Doc path, which is
+// nil if the path is not in the Doc
+func TestTest_Identity(t *testing.T) {
+	test := &Test{
+		Summary: "login works",
+		Doc:     map[string]any{"nodeid": "tests/test_login.py::test_login", "app": map[string]any{"name": "oar"}},
+	}
+
+	identity := test.Identity([]string{"summary", "doc.nodeid", "doc.app.name", "doc.package", "doc.nodeid.file"})
+	assert.Equal(t, identity, map[string]any{
+		"summary":         "login works",
+		"doc.nodeid":      "tests/test_login.py::test_login",
+		"doc.app.name":    "oar",
+		"doc.package":     nil,
+		"doc.nodeid.file": nil,
+	})
+
+	test.Doc = nil
+	assert.Equal(t, test.Identity([]string{"doc.nodeid"}), map[string]any{"doc.nodeid": nil})
+}
+
+// TestFingerprint will ensure that equal identities have the same fingerprint and different identities do not
+func TestFingerprint(t *testing.T) {
+	fingerprint := func(identity map[string]any) string {
+		fingerprint, err := Fingerprint(identity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fingerprint
+	}
+
+	loginFingerprint := fingerprint(map[string]any{"summary": "login works", "doc.package": "auth"})
+	assert.Equal(t, len(loginFingerprint), 64)
+	assert.Equal(t, fingerprint(map[string]any{"doc.package": "auth", "summary": "login works"}), loginFingerprint)
+
+	otherIdentities := []map[string]any{
+		{"summary": "login works", "doc.package": "billing"},
+		{"summary": "login works", "doc.package": nil},
+		{"summary": "login works"},
+		{"summary": "Login works", "doc.package": "auth"},
+	}
+	for _, identity := range otherIdentities {
+		if fingerprint(identity) == loginFingerprint {
+			t.Errorf("identity %v has the same fingerprint as a different identity", identity)
+		}
+	}
+}
+
+// TestRunComparison_Compare will ensure that the results of two runs are matched by their test definition and sorted
+// into regressions, fixes, still failing, added and removed tests
+func TestRunComparison_Compare(t *testing.T) {
+	newTest := func(definitionID uint64, summary string, outcome Outcome) *Test {
+		return &Test{Summary: summary, Outcome: outcome, DefinitionID: &definitionID}
+	}
+	baseTests := []*Test{
+		newTest(1, "checkout", Passed),
+		newTest(2, "login", Failed),
+		newTest(3, "logout", Failed),
+		newTest(4, "search", Passed),
+		newTest(5, "signup", Passed),
+		newTest(7, "cart", Passed),
+		{Summary: "no definition", Outcome: Failed},
+	}
+	headTests := []*Test{
+		newTest(1, "checkout", Failed),
+		newTest(2, "login", Passed),
+		newTest(3, "logout", Failed),
+		newTest(4, "search", Passed),
+		newTest(6, "profile", Passed),
+		newTest(7, "cart", Failed),
+	}
+
+	comparison := &RunComparison{}
+	comparison.Compare(baseTests, headTests)
+
+	summaries := func(comparisons []*RunTestComparison) []string {
+		summaries := []string{}
+		for _, comparison := range comparisons {
+			summaries = append(summaries, comparison.Summary)
+		}
+		return summaries
+	}
+	assert.Equal(t, summaries(comparison.Regressions), []string{"cart", "checkout"})
+	assert.Equal(t, summaries(comparison.Fixed), []string{"login"})
+	assert.Equal(t, summaries(comparison.StillFailing), []string{"logout"})
+	assert.Equal(t, summaries(comparison.Added), []string{"profile"})
+	assert.Equal(t, summaries(comparison.Removed), []string{"signup"})
+	assert.Equal(t, comparison.Unchanged, 1)
+
+	assert.Equal(t, comparison.Regressions[0].Base, baseTests[5])
+	assert.Equal(t, comparison.Regressions[0].Head, headTests[5])
+	if comparison.Added[0].Base != nil || comparison.Removed[0].Head != nil {
+		t.Error("added or removed test has a result in the other run")
+	}
+}
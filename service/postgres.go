@@ -1,13 +1,33 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/jackc/pgx"
 	"github.com/jackc/pgx/pgtype"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// insertChunkSize is the max amount of rows that InsertTests will put into a single insert statement. Each row takes
+// 8 parameters and postgres allows a max of 65535 parameters per statement.
+const insertChunkSize = 1000
+
+// patchChunkSize is the max amount of rows that PatchTests will lock, merge and update at a time. Each updated row
+// takes 7 parameters and postgres allows a max of 65535 parameters per statement.
+const patchChunkSize = 1000
+
+// maxDryRunTests is the max amount of patched tests that a dry run of PatchTests will return
+const maxDryRunTests = 1000
+
+// definitionChunkSize is the max amount of test definitions that linkTestDefinitions will put into a single upsert
+// statement. Each row takes 3 parameters and postgres allows a max of 65535 parameters per statement.
+const definitionChunkSize = 1000
+
 type PGConfig struct {
 	Host        string        `mapstructure:"HOST"`
 	Port        uint16        `mapstructure:"PORT"`
@@ -47,26 +67,62 @@ func NewPGPool(config *PGConfig) (*pgx.ConnPool, error) {
 	return pgPool, nil
 }
 
-// InsertTest will insert a new models.Test object into the postgres DB
-func InsertTest(pgPool *pgx.ConnPool, test *Test) (uint64, error) {
-	conn, err := pgPool.Acquire()
+// beginAudited will begin a transaction that attributes every change it makes in the test history to the audit. A nil
+// audit will leave the changes unattributed.
+func beginAudited(pgPool *pgx.ConnPool, audit *Audit) (*pgx.Tx, error) {
+	tx, err := pgPool.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if audit == nil {
+		return tx, nil
+	}
+
+	// The settings are local to the transaction, they are read by the history trigger
+	_, err = tx.Exec(
+		"SELECT SET_CONFIG('oar.actor', $1, TRUE), SET_CONFIG('oar.request_id', $2, TRUE)",
+		audit.Actor,
+		audit.RequestID,
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// InsertTest will insert a new models.Test object into the postgres DB. The test is linked to its test definition by
+// the definitionFields, see DefinitionConfig.
+func InsertTest(pgPool *pgx.ConnPool, audit *Audit, test *Test, definitionFields []string) (uint64, error) {
+	err := test.Validate()
 	if err != nil {
 		return 0, err
 	}
-	defer pgPool.Release(conn)
 
-	err = test.Validate()
+	tx, err := beginAudited(pgPool, audit)
 	if err != nil {
 		return 0, err
 	}
+	defer tx.Rollback()
+
+	if err = checkTestRuns(tx, []*Test{test}); err != nil {
+		return 0, err
+	}
+	if err = linkTestDefinitions(tx, []*Test{test}, definitionFields, true); err != nil {
+		return 0, err
+	}
 
-	row := conn.QueryRow(
-		"insert into oar_tests (summary, outcome, analysis, resolution, doc) values ($1, $2, $3, $4, $5) returning id",
+	row := tx.QueryRow(
+		"insert into oar_tests (summary, outcome, analysis, resolution, doc, definition_id, run_id) "+
+			"values ($1, $2, $3, $4, $5, $6, $7) returning id",
 		test.Summary,
 		test.Outcome,
 		test.Analysis,
 		test.Resolution,
 		test.Doc,
+		test.DefinitionID,
+		test.RunID,
 	)
 	if err != nil {
 		return 0, err
@@ -78,36 +134,475 @@ func InsertTest(pgPool *pgx.ConnPool, test *Test) (uint64, error) {
 		return 0, err
 	}
 
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
 	return createdID, nil
 }
 
-// UpdateTest will update an existing test in the postgres DB by ID
-func UpdateTest(pgPool *pgx.ConnPool, test *Test) error {
-	conn, err := pgPool.Acquire()
+// InsertTests will insert a batch of new models.Test objects into the postgres DB in a single transaction. Either all
+// tests will be inserted or none of them will. Returns the created IDs in the same order as the tests passed in. The
+// tests are linked to their test definitions by the definitionFields, see DefinitionConfig.
+func InsertTests(pgPool *pgx.ConnPool, audit *Audit, tests []*Test, definitionFields []string) ([]uint64, error) {
+	for i, test := range tests {
+		if err := test.Validate(); err != nil {
+			return nil, fmt.Errorf("test %d: %w", i, err)
+		}
+	}
+
+	tx, err := beginAudited(pgPool, audit)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	createdIDs := make([]uint64, 0, len(tests))
+	for start := 0; start < len(tests); start += insertChunkSize {
+		end := start + insertChunkSize
+		if end > len(tests) {
+			end = len(tests)
+		}
+
+		chunkIDs, err := insertTestChunk(tx, tests[start:end], definitionFields)
+		if err != nil {
+			return nil, err
+		}
+		createdIDs = append(createdIDs, chunkIDs...)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return createdIDs, nil
+}
+
+// insertTestChunk will insert tests with a single multi-row insert statement on a transaction. The IDs are drawn from
+// the sequence before the insert and each row is inserted with its own ID, so the returned IDs are in the same order
+// as the tests passed in.
+func insertTestChunk(tx *pgx.Tx, tests []*Test, definitionFields []string) ([]uint64, error) {
+	if err := checkTestRuns(tx, tests); err != nil {
+		return nil, err
+	}
+	if err := linkTestDefinitions(tx, tests, definitionFields, true); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(
+		"SELECT NEXTVAL(PG_GET_SERIAL_SEQUENCE('oar_tests', 'id')) FROM GENERATE_SERIES(1, $1)",
+		len(tests),
+	)
+	if err != nil {
+		return nil, err
+	}
+	createdIDs, err := scanIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(createdIDs) != len(tests) {
+		return nil, fmt.Errorf("IDs drawn: %d != %d", len(createdIDs), len(tests))
+	}
+
+	values := make([]string, 0, len(tests))
+	params := make([]any, 0, len(tests)*8)
+	for i, test := range tests {
+		n := i * 8
+		values = append(values, fmt.Sprintf(
+			"($%d::BIGINT, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8,
+		))
+		params = append(
+			params,
+			int64(createdIDs[i]),
+			test.Summary,
+			test.Outcome,
+			test.Analysis,
+			test.Resolution,
+			test.Doc,
+			test.DefinitionID,
+			test.RunID,
+		)
+	}
+
+	exec, err := tx.Exec(
+		"insert into oar_tests (id, summary, outcome, analysis, resolution, doc, definition_id, run_id) values "+
+			strings.Join(values, ", "),
+		params...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected := exec.RowsAffected(); rowsAffected != int64(len(tests)) {
+		return nil, fmt.Errorf("rows inserted: %d != %d", rowsAffected, len(tests))
+	}
+
+	return createdIDs, nil
+}
+
+// linkTestDefinitions will set the DefinitionID of every test to the test definition with the fingerprint of its
+// identity by the definitionFields on a transaction, see DefinitionConfig. Test definitions that do not exist yet are
+// created, existing ones are only marked as last seen if seen is true. They are upserted in fingerprint order, so that
+// concurrent transactions lock them in the same order and cannot deadlock.
+func linkTestDefinitions(tx *pgx.Tx, tests []*Test, definitionFields []string, seen bool) error {
+	fingerprints := make([]string, len(tests))
+	definitions := map[string]*TestDefinition{}
+	for i, test := range tests {
+		identity := test.Identity(definitionFields)
+		fingerprint, err := Fingerprint(identity)
+		if err != nil {
+			return err
+		}
+
+		fingerprints[i] = fingerprint
+		if _, ok := definitions[fingerprint]; !ok {
+			definitions[fingerprint] = &TestDefinition{
+				Fingerprint: fingerprint,
+				Summary:     test.Summary,
+				Identity:    identity,
+			}
+		}
+	}
+	sortedFingerprints := maps.Keys(definitions)
+	slices.Sort(sortedFingerprints)
+
+	lastSeen := "OAR_TEST_DEFINITIONS.LAST_SEEN"
+	if seen {
+		lastSeen = "(NOW() AT TIME ZONE 'UTC')"
+	}
+
+	for start := 0; start < len(sortedFingerprints); start += definitionChunkSize {
+		end := start + definitionChunkSize
+		if end > len(sortedFingerprints) {
+			end = len(sortedFingerprints)
+		}
+
+		values := make([]string, 0, end-start)
+		params := make([]any, 0, (end-start)*3)
+		for i, fingerprint := range sortedFingerprints[start:end] {
+			n := i * 3
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d::JSONB)", n+1, n+2, n+3))
+			definition := definitions[fingerprint]
+			params = append(params, definition.Fingerprint, definition.Summary, definition.Identity)
+		}
+
+		// Updating an existing definition is what lets its ID be returned
+		rows, err := tx.Query(
+			"INSERT INTO OAR_TEST_DEFINITIONS (FINGERPRINT, SUMMARY, IDENTITY) VALUES "+strings.Join(values, ", ")+
+				" ON CONFLICT (FINGERPRINT) DO UPDATE SET LAST_SEEN = "+lastSeen+" "+
+				"RETURNING ID, FINGERPRINT",
+			params...,
+		)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var definitionID uint64
+			var fingerprint string
+			if err = rows.Scan(&definitionID, &fingerprint); err != nil {
+				rows.Close()
+				return err
+			}
+			definitions[fingerprint].ID = definitionID
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	for i, test := range tests {
+		definitionID := definitions[fingerprints[i]].ID
+		test.DefinitionID = &definitionID
+	}
+	return nil
+}
+
+// relinkTestDefinitions will link the tests whose identity by the definitionFields no longer has the fingerprint of
+// their test definition again on a transaction, without marking any test definition as last seen. Tests whose
+// identity did not change keep their test definition.
+func relinkTestDefinitions(tx *pgx.Tx, tests []*Test, definitionFields []string) error {
+	var definitionIDs []uint64
+	for _, test := range tests {
+		if test.DefinitionID != nil {
+			definitionIDs = append(definitionIDs, *test.DefinitionID)
+		}
+	}
+
+	fingerprints := map[uint64]string{}
+	if len(definitionIDs) > 0 {
+		rows, err := tx.Query("SELECT ID, FINGERPRINT FROM OAR_TEST_DEFINITIONS WHERE ID = ANY($1)", definitionIDs)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var definitionID uint64
+			var fingerprint string
+			if err = rows.Scan(&definitionID, &fingerprint); err != nil {
+				rows.Close()
+				return err
+			}
+			fingerprints[definitionID] = fingerprint
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	var changedTests []*Test
+	for _, test := range tests {
+		fingerprint, err := Fingerprint(test.Identity(definitionFields))
+		if err != nil {
+			return err
+		}
+		if test.DefinitionID == nil || fingerprints[*test.DefinitionID] != fingerprint {
+			changedTests = append(changedTests, test)
+		}
+	}
+	if len(changedTests) == 0 {
+		return nil
+	}
+
+	return linkTestDefinitions(tx, changedTests, definitionFields, false)
+}
+
+// checkTestRuns will return an error if any of the tests is attached to a run that does not exist or is finished. The
+// runs are locked until the transaction ends, so they cannot be finished while the tests are inserted.
+func checkTestRuns(tx *pgx.Tx, tests []*Test) error {
+	var runIDs []uint64
+	for _, test := range tests {
+		if test.RunID != nil && !slices.Contains(runIDs, *test.RunID) {
+			runIDs = append(runIDs, *test.RunID)
+		}
+	}
+	if len(runIDs) == 0 {
+		return nil
+	}
+
+	rows, err := tx.Query("SELECT ID FROM OAR_RUNS WHERE ID = ANY($1) AND FINISHED IS NULL FOR SHARE", runIDs)
 	if err != nil {
 		return err
 	}
-	defer pgPool.Release(conn)
+	openRunIDs, err := scanIDs(rows)
+	if err != nil {
+		return err
+	}
+
+	for _, runID := range runIDs {
+		if !slices.Contains(openRunIDs, runID) {
+			return fmt.Errorf("run %d not found or already finished, results cannot be attached to it", runID)
+		}
+	}
+	return nil
+}
 
-	err = test.Validate()
+// UpdateTest will update an existing test in the postgres DB by ID. The test is linked to its test definition again by
+// the definitionFields if its identity changed.
+func UpdateTest(pgPool *pgx.ConnPool, audit *Audit, test *Test, definitionFields []string) error {
+	rowsAffected, err := updateTest(pgPool, audit, test, definitionFields, nil)
 	if err != nil {
 		return err
 	}
+	if rowsAffected != 1 {
+		return fmt.Errorf("rows affected: %d != 1", rowsAffected)
+	}
 
-	exec, err := conn.Exec(
-		"UPDATE OAR_TESTS SET summary=$1, outcome=$2, analysis=$3, resolution=$4, doc=$5 WHERE id=$6",
-		test.Summary,
-		test.Outcome,
-		test.Analysis,
-		test.Resolution,
-		test.Doc,
-		test.ID,
+	return nil
+}
+
+// UpdateTestIfUnmodified will update an existing test in the postgres DB by ID, only if it has not been modified since
+// the passed modified timestamp. Returns false if the test was modified since, or no longer exists, and nothing was
+// updated.
+func UpdateTestIfUnmodified(
+	pgPool *pgx.ConnPool,
+	audit *Audit,
+	test *Test,
+	definitionFields []string,
+	modified time.Time,
+) (bool, error) {
+	rowsAffected, err := updateTest(pgPool, audit, test, definitionFields, &modified)
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// updateTest will validate and update an existing test by ID, optionally only if it was last modified at the passed
+// timestamp. Returns the amount of rows affected.
+func updateTest(
+	pgPool *pgx.ConnPool,
+	audit *Audit,
+	test *Test,
+	definitionFields []string,
+	modified *time.Time,
+) (int64, error) {
+	err := test.Validate()
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := beginAudited(pgPool, audit)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// The summary or doc could have changed the identity of the test
+	if err = relinkTestDefinitions(tx, []*Test{test}, definitionFields); err != nil {
+		return 0, err
+	}
+
+	SQL := "UPDATE OAR_TESTS SET summary=$1, outcome=$2, analysis=$3, resolution=$4, doc=$5, definition_id=$6 " +
+		"WHERE id=$7"
+	args := []any{test.Summary, test.Outcome, test.Analysis, test.Resolution, test.Doc, test.DefinitionID, test.ID}
+	if modified != nil {
+		SQL += " AND modified=$8"
+		args = append(args, *modified)
+	}
+
+	exec, err := tx.Exec(SQL, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return exec.RowsAffected(), nil
+}
+
+// PatchTests will apply a TestPatcher to every test that matches a WHERE clause in a single transaction. Tests are
+// locked, patched and validated in chunks, and only the tests that were changed by the patch are updated. Either every
+// matching test is patched or none of them are. Changed tests are linked to their test definition again by the
+// definitionFields if their identity changed.
+//
+// If dryRun is true, nothing will be written or locked and the response will include the patched values of the first
+// maxDryRunTests tests that would change. Count still includes every test that would change.
+func PatchTests(
+	pgPool *pgx.ConnPool,
+	audit *Audit,
+	where *sqlWhere,
+	patch TestPatcher,
+	definitionFields []string,
+	dryRun bool,
+) (*TestPatchResponse, error) {
+	tx, err := beginAudited(pgPool, audit)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	response := &TestPatchResponse{DryRun: dryRun, Tests: []*Test{}}
+	var lastID uint64
+	for {
+		chunkWhere := where.clone()
+		chunkWhere.and("ID > " + chunkWhere.param(lastID))
+
+		SQL := "SELECT * FROM OAR_TESTS" + chunkWhere.String() + " ORDER BY ID LIMIT " + strconv.Itoa(patchChunkSize)
+		if !dryRun {
+			SQL += " FOR UPDATE"
+		}
+		rows, err := tx.Query(SQL, chunkWhere.params...)
+		if err != nil {
+			return nil, err
+		}
+		tests, err := scanTests(rows)
+		if err != nil {
+			return nil, err
+		}
+		if len(tests) == 0 {
+			break
+		}
+		lastID = tests[len(tests)-1].ID
+		response.Matched += uint64(len(tests))
+
+		var changedTests []*Test
+		for _, test := range tests {
+			originalTest := *test
+			originalTest.Doc = maps.Clone(test.Doc)
+
+			if err = patch(test); err != nil {
+				return nil, fmt.Errorf("test %d: %w", test.ID, err)
+			}
+
+			// Validate after the patch to ensure the patch is still okay for every test
+			if err = test.Validate(); err != nil {
+				return nil, fmt.Errorf("test %d: %w", test.ID, err)
+			}
+			if !test.Equal(&originalTest) {
+				changedTests = append(changedTests, test)
+			}
+		}
+		response.Count += uint64(len(changedTests))
+
+		if dryRun {
+			if remaining := maxDryRunTests - len(response.Tests); len(changedTests) > remaining {
+				changedTests = changedTests[:remaining]
+			}
+			response.Tests = append(response.Tests, changedTests...)
+		} else if len(changedTests) > 0 {
+			if err = updateTestChunk(tx, changedTests, definitionFields); err != nil {
+				return nil, err
+			}
+		}
+
+		if len(tests) < patchChunkSize {
+			break
+		}
+	}
+
+	if dryRun {
+		return response, nil
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// updateTestChunk will update tests by ID with a single multi-row update statement on a transaction. The tests are
+// linked to their test definition again if the patch changed their identity.
+func updateTestChunk(tx *pgx.Tx, tests []*Test, definitionFields []string) error {
+	if err := relinkTestDefinitions(tx, tests, definitionFields); err != nil {
+		return err
+	}
+
+	values := make([]string, 0, len(tests))
+	params := make([]any, 0, len(tests)*7)
+	for i, test := range tests {
+		n := i * 7
+		values = append(values, fmt.Sprintf(
+			"($%d::BIGINT, $%d, $%d, $%d, $%d, $%d::JSONB, $%d::BIGINT)",
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7,
+		))
+		params = append(
+			params,
+			int64(test.ID),
+			test.Summary,
+			test.Outcome,
+			test.Analysis,
+			test.Resolution,
+			test.Doc,
+			test.DefinitionID,
+		)
+	}
+
+	exec, err := tx.Exec(
+		"UPDATE OAR_TESTS AS T SET SUMMARY=V.SUMMARY, OUTCOME=V.OUTCOME, ANALYSIS=V.ANALYSIS, "+
+			"RESOLUTION=V.RESOLUTION, DOC=V.DOC, DEFINITION_ID=V.DEFINITION_ID FROM (VALUES "+
+			strings.Join(values, ", ")+") AS V(ID, SUMMARY, OUTCOME, ANALYSIS, RESOLUTION, DOC, DEFINITION_ID) "+
+			"WHERE T.ID = V.ID",
+		params...,
 	)
 	if err != nil {
 		return err
 	}
-	if exec.RowsAffected() != 1 {
-		return fmt.Errorf("rows affected: %d != 1", exec.RowsAffected())
+	if exec.RowsAffected() != int64(len(tests)) {
+		return fmt.Errorf("rows updated: %d != %d", exec.RowsAffected(), len(tests))
 	}
 
 	return nil
@@ -122,16 +617,44 @@ func SelectTests(pgPool *pgx.ConnPool, query string, args ...any) ([]*Test, erro
 		return nil, err
 	}
 	defer pgPool.Release(conn)
-	var tests []*Test
 
 	rows, err := conn.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 
+	return scanTests(rows)
+}
+
+// SelectTest will select a single test by ID. Soft deleted tests are only selected if includeDeleted is true. Will
+// return nil if there is no test with the ID.
+func SelectTest(pgPool *pgx.ConnPool, testID uint64, includeDeleted bool) (*Test, error) {
+	query := "SELECT * FROM OAR_TESTS WHERE ID = $1"
+	if !includeDeleted {
+		query += " AND " + testNotDeleted
+	}
+
+	tests, err := SelectTests(pgPool, query, testID)
+	if err != nil {
+		return nil, err
+	}
+	if len(tests) == 0 {
+		return nil, nil
+	}
+
+	return tests[0], nil
+}
+
+// scanTests will deserialize every row of a query that returns rows in the models.Test schema
+func scanTests(rows *pgx.Rows) ([]*Test, error) {
+	defer rows.Close()
+	var tests []*Test
+
 	for rows.Next() {
 		test := &Test{}
-		err = rows.Scan(
+		var deleted pgtype.Timestamp
+		var definitionID, runID pgtype.Int8
+		err := rows.Scan(
 			&test.ID,
 			&test.Summary,
 			&test.Outcome,
@@ -140,35 +663,779 @@ func SelectTests(pgPool *pgx.ConnPool, query string, args ...any) ([]*Test, erro
 			&test.Created,
 			&test.Modified,
 			&test.Doc,
+			&deleted,
+			&definitionID,
+			&runID,
 		)
 		if err != nil {
 			return nil, err
 		}
+		if deleted.Status == pgtype.Present {
+			test.Deleted = &deleted.Time
+		}
+		if definitionID.Status == pgtype.Present {
+			id := uint64(definitionID.Int)
+			test.DefinitionID = &id
+		}
+		if runID.Status == pgtype.Present {
+			id := uint64(runID.Int)
+			test.RunID = &id
+		}
 		tests = append(tests, test)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
 	return tests, nil
 }
 
-// DeleteTests will take in a slice of test IDs and attempt to delete all tests with those IDs. Will return the amount
-// of rows deleted and any error that occurred. If an error occurred, it will return -1 rows deleted, which is invalid.
-func DeleteTests(pgPool *pgx.ConnPool, testIDs []uint64) (int64, error) {
+// CountTests will count the tests that match a WHERE clause. Counting stops after exactCountLimit matches, past which
+// the count will be estimated from the postgres query plan. Will return the count and whether it is an estimate.
+func CountTests(pgPool *pgx.ConnPool, where *sqlWhere) (uint64, bool, error) {
 	conn, err := pgPool.Acquire()
 	if err != nil {
-		return -1, err
+		return 0, false, err
 	}
 	defer pgPool.Release(conn)
 
-	pgTestIDs := &pgtype.Int8Array{}
-	err = pgTestIDs.Set(testIDs)
+	var count uint64
+	err = conn.QueryRow(
+		"SELECT COUNT(*) FROM (SELECT 1 FROM OAR_TESTS"+where.String()+
+			" LIMIT "+strconv.Itoa(exactCountLimit+1)+") AS MATCHES",
+		where.params...,
+	).Scan(&count)
 	if err != nil {
-		return -1, err
+		return 0, false, err
+	}
+
+	if count <= exactCountLimit {
+		return count, false, nil
 	}
 
-	exec, err := conn.Exec("DELETE FROM OAR_TESTS WHERE ID = ANY($1)", pgTestIDs)
+	var plan string
+	err = conn.QueryRow("EXPLAIN (FORMAT JSON) SELECT 1 FROM OAR_TESTS"+where.String(), where.params...).Scan(&plan)
 	if err != nil {
-		return -1, err
+		return 0, false, err
 	}
 
-	return exec.RowsAffected(), nil
+	var explained []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err = json.Unmarshal([]byte(plan), &explained); err != nil {
+		return 0, false, err
+	}
+	if len(explained) == 0 {
+		return 0, false, errors.New("query plan is empty")
+	}
+
+	// The planner can underestimate, but we know there are at least this many
+	estimate := uint64(explained[0].Plan.Rows)
+	if estimate < count {
+		estimate = count
+	}
+
+	return estimate, true, nil
+}
+
+// SelectTestStats will take in a query that returns rows of grouped test stats, see QueryTestStats, and deserialize
+// them. Will return the stats of each group and the total amount of tests across all groups.
+// args will be passed down to Conn.query
+func SelectTestStats(pgPool *pgx.ConnPool, query string, args ...any) ([]*TestStats, uint64, error) {
+	conn, err := pgPool.Acquire()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer pgPool.Release(conn)
+
+	rows, err := conn.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var groups []*TestStats
+	var total uint64
+	for rows.Next() {
+		group := &TestStats{}
+		err = rows.Scan(
+			&group.Key,
+			&group.Count,
+			&group.Ratio,
+			&group.Passed,
+			&group.Failed,
+			&group.FailureRate,
+			&total,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		groups = append(groups, group)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return groups, total, nil
+}
+
+// SelectTestQuality will take in a query that returns rows of grouped test analysis counts, see QueryTestQuality, and
+// deserialize them. The metrics of each group are not computed. Will return the quality of each group and the total
+// amount of tests across all groups.
+// args will be passed down to Conn.query
+func SelectTestQuality(pgPool *pgx.ConnPool, query string, args ...any) ([]*TestQuality, uint64, error) {
+	conn, err := pgPool.Acquire()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer pgPool.Release(conn)
+
+	rows, err := conn.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var groups []*TestQuality
+	var total uint64
+	for rows.Next() {
+		group := &TestQuality{}
+		err = rows.Scan(
+			&group.Key,
+			&group.Count,
+			&group.ConfusionMatrix.TruePositive,
+			&group.ConfusionMatrix.FalsePositive,
+			&group.ConfusionMatrix.TrueNegative,
+			&group.ConfusionMatrix.FalseNegative,
+			&group.NotAnalyzed,
+			&total,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		groups = append(groups, group)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return groups, total, nil
+}
+
+// SelectNoisyTests will find the tests that match a WHERE clause whose failures were most often false positives. Tests
+// are identified by their summary, and only tests with at least 1 false positive are noisy. They are ordered by their
+// amount of false positives, then by their false alarm rate, up to the limit.
+func SelectNoisyTests(pgPool *pgx.ConnPool, where *sqlWhere, limit int) ([]*NoisyTest, error) {
+	conn, err := pgPool.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer pgPool.Release(conn)
+
+	falsePositives := countAnalysis(FalsePositive)
+	rows, err := conn.Query(
+		"SELECT SUMMARY, COUNT(*), "+countOutcome(Failed)+", "+falsePositives+", "+
+			"COALESCE("+falsePositives+"::FLOAT8 / NULLIF("+countOutcome(Failed)+", 0), 0) AS FALSE_ALARM_RATE "+
+			"FROM OAR_TESTS"+where.String()+
+			" GROUP BY SUMMARY HAVING "+falsePositives+" > 0"+
+			" ORDER BY "+falsePositives+" DESC, FALSE_ALARM_RATE DESC, SUMMARY LIMIT "+strconv.Itoa(limit),
+		where.params...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var noisyTests []*NoisyTest
+	for rows.Next() {
+		noisyTest := &NoisyTest{}
+		err = rows.Scan(
+			&noisyTest.Summary,
+			&noisyTest.Count,
+			&noisyTest.Failed,
+			&noisyTest.FalsePositives,
+			&noisyTest.FalseAlarmRate,
+		)
+		if err != nil {
+			return nil, err
+		}
+		noisyTests = append(noisyTests, noisyTest)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return noisyTests, nil
+}
+
+// SelectFlakyTests will take in a query that returns rows of test identities with their failures and flips, see
+// buildFlakyTestsSQL, and deserialize them. Their flakiness is not scored.
+// args will be passed down to Conn.query
+func SelectFlakyTests(pgPool *pgx.ConnPool, query string, args ...any) ([]*FlakyTest, error) {
+	conn, err := pgPool.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer pgPool.Release(conn)
+
+	rows, err := conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var flakyTests []*FlakyTest
+	for rows.Next() {
+		flakyTest := &FlakyTest{}
+		err = rows.Scan(
+			&flakyTest.Identity,
+			&flakyTest.Runs,
+			&flakyTest.Failures,
+			&flakyTest.Flips,
+			&flakyTest.LastOutcome,
+			&flakyTest.FirstRun,
+			&flakyTest.LastRun,
+			&flakyTest.TestIDs,
+		)
+		if err != nil {
+			return nil, err
+		}
+		flakyTests = append(flakyTests, flakyTest)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return flakyTests, nil
+}
+
+// DeleteTestsWhere will soft delete every test that matches a WHERE clause with a single statement, tests that are
+// already deleted are left as they are. If more than maxRows tests would be deleted, the transaction is rolled back
+// and an error is returned, a maxRows of 0 means there is no max. Soft deleted tests are kept until PurgeDeletedTests.
+//
+// If dryRun is true, nothing will be deleted or locked and the response will include the IDs of up to the first
+// maxDryRunTests tests that would be deleted. Count still includes every test that would be deleted.
+func DeleteTestsWhere(
+	pgPool *pgx.ConnPool,
+	audit *Audit,
+	where *sqlWhere,
+	maxRows uint64,
+	dryRun bool,
+) (*TestDeleteResponse, error) {
+	deleteWhere := where.clone()
+	deleteWhere.and(testNotDeleted)
+
+	if dryRun {
+		conn, err := pgPool.Acquire()
+		if err != nil {
+			return nil, err
+		}
+		defer pgPool.Release(conn)
+
+		response := &TestDeleteResponse{DryRun: true}
+		err = conn.QueryRow("SELECT COUNT(*) FROM OAR_TESTS"+deleteWhere.String(), deleteWhere.params...).
+			Scan(&response.Count)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := conn.Query(
+			"SELECT ID FROM OAR_TESTS"+deleteWhere.String()+" ORDER BY ID LIMIT "+strconv.Itoa(maxDryRunTests),
+			deleteWhere.params...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if response.IDs, err = scanIDs(rows); err != nil {
+			return nil, err
+		}
+		return response, nil
+	}
+
+	tx, err := beginAudited(pgPool, audit)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		"UPDATE OAR_TESTS SET DELETED = (NOW() AT TIME ZONE 'UTC')"+deleteWhere.String()+" RETURNING ID",
+		deleteWhere.params...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	deletedIDs, err := scanIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+	// The deferred rollback undoes the delete, checking in the transaction means no test can slip past the max
+	if maxRows > 0 && uint64(len(deletedIDs)) > maxRows {
+		return nil, fmt.Errorf("would delete more than the max of %d tests, pass confirm=true to delete them", maxRows)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &TestDeleteResponse{Count: uint64(len(deletedIDs))}, nil
+}
+
+// RestoreTestsWhere will restore every soft deleted test that matches a WHERE clause with a single statement. Returns
+// the IDs of the restored tests.
+func RestoreTestsWhere(pgPool *pgx.ConnPool, audit *Audit, where *sqlWhere) ([]uint64, error) {
+	tx, err := beginAudited(pgPool, audit)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	restoreWhere := where.clone()
+	restoreWhere.and("DELETED IS NOT NULL")
+	rows, err := tx.Query(
+		"UPDATE OAR_TESTS SET DELETED = NULL"+restoreWhere.String()+" RETURNING ID",
+		restoreWhere.params...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	restoredIDs, err := scanIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return restoredIDs, nil
+}
+
+// PurgeDeletedTests will permanently delete every test that was soft deleted longer ago than the retention. Will
+// return the amount of tests purged.
+func PurgeDeletedTests(pgPool *pgx.ConnPool, retention time.Duration) (int64, error) {
+	conn, err := pgPool.Acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer pgPool.Release(conn)
+
+	exec, err := conn.Exec(
+		"DELETE FROM OAR_TESTS WHERE DELETED < (NOW() AT TIME ZONE 'UTC') - MAKE_INTERVAL(SECS => $1)",
+		retention.Seconds(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return exec.RowsAffected(), nil
+}
+
+// SelectTestHistory will select the recorded changes of a test by ID, oldest first. The history of a purged test is
+// still selected.
+func SelectTestHistory(pgPool *pgx.ConnPool, testID uint64) ([]*TestHistory, error) {
+	conn, err := pgPool.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer pgPool.Release(conn)
+
+	rows, err := conn.Query(
+		"SELECT ID, TEST_ID, OPERATION, BEFORE, AFTER, ACTOR, REQUEST_ID, TIMESTAMP FROM OAR_TEST_HISTORY "+
+			"WHERE TEST_ID = $1 ORDER BY ID",
+		testID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []*TestHistory{}
+	for rows.Next() {
+		change := &TestHistory{}
+		var actor, requestID pgtype.Text
+		err = rows.Scan(
+			&change.ID,
+			&change.TestID,
+			&change.Operation,
+			&change.Before,
+			&change.After,
+			&actor,
+			&requestID,
+			&change.Timestamp,
+		)
+		if err != nil {
+			return nil, err
+		}
+		change.Actor = actor.String
+		change.RequestID = requestID.String
+		change.Fields = change.ChangedFields()
+		history = append(history, change)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// testDefinitionColumns are the columns of a TestDefinition in a query over the oar_test_definitions table as D,
+// followed by the count of its results that are not soft deleted
+const testDefinitionColumns = "D.ID, D.FINGERPRINT, D.SUMMARY, D.IDENTITY, D.CREATED, D.LAST_SEEN, " +
+	"(SELECT COUNT(*) FROM OAR_TESTS T WHERE T.DEFINITION_ID = D.ID AND T.DELETED IS NULL)"
+
+// SelectTestDefinitions will take in a query that returns rows of testDefinitionColumns, deserialize them and select
+// the latest result of each test definition.
+// args will be passed down to Conn.query
+func SelectTestDefinitions(pgPool *pgx.ConnPool, query string, args ...any) ([]*TestDefinition, error) {
+	conn, err := pgPool.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer pgPool.Release(conn)
+
+	rows, err := conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	definitions := []*TestDefinition{}
+	definitionIDs := []uint64{}
+	for rows.Next() {
+		definition := &TestDefinition{}
+		err = rows.Scan(
+			&definition.ID,
+			&definition.Fingerprint,
+			&definition.Summary,
+			&definition.Identity,
+			&definition.Created,
+			&definition.LastSeen,
+			&definition.Results,
+		)
+		if err != nil {
+			return nil, err
+		}
+		definitions = append(definitions, definition)
+		definitionIDs = append(definitionIDs, definition.ID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(definitions) == 0 {
+		return definitions, nil
+	}
+
+	rows, err = conn.Query(
+		"SELECT DISTINCT ON (DEFINITION_ID) * FROM OAR_TESTS WHERE DEFINITION_ID = ANY($1) AND "+testNotDeleted+
+			" ORDER BY DEFINITION_ID, CREATED DESC, ID DESC",
+		definitionIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	latestTests, err := scanTests(rows)
+	if err != nil {
+		return nil, err
+	}
+	latestTestByDefinition := map[uint64]*Test{}
+	for _, test := range latestTests {
+		latestTestByDefinition[*test.DefinitionID] = test
+	}
+	for _, definition := range definitions {
+		definition.Latest = latestTestByDefinition[definition.ID]
+	}
+
+	return definitions, nil
+}
+
+// runSummaryColumns are the columns of a RunSummary in a query over runs as R with their runTotals
+var runSummaryColumns = "R.ID, R.NAME, R.BRANCH, R.COMMIT, R.ENVIRONMENT, R.DOC, R.STARTED, R.FINISHED, " +
+	"EXTRACT(EPOCH FROM COALESCE(R.FINISHED, NOW() AT TIME ZONE 'UTC') - R.STARTED)::FLOAT8, " +
+	"S.TOTAL, S.PASSED, S.FAILED, S.NOT_ANALYZED, S.TRUE_POSITIVE, S.FALSE_POSITIVE, S.TRUE_NEGATIVE, S.FALSE_NEGATIVE"
+
+// runTotals is a lateral join of the totals of the results of each run R that are not soft deleted, as S
+var runTotals = "CROSS JOIN LATERAL (SELECT COUNT(*) AS TOTAL, " +
+	countOutcome(Passed) + " AS PASSED, " +
+	countOutcome(Failed) + " AS FAILED, " +
+	countAnalysis(NotAnalyzed) + " AS NOT_ANALYZED, " +
+	countAnalysis(TruePositive) + " AS TRUE_POSITIVE, " +
+	countAnalysis(FalsePositive) + " AS FALSE_POSITIVE, " +
+	countAnalysis(TrueNegative) + " AS TRUE_NEGATIVE, " +
+	countAnalysis(FalseNegative) + " AS FALSE_NEGATIVE " +
+	"FROM OAR_TESTS WHERE RUN_ID = R.ID AND " + testNotDeleted + ") S"
+
+// InsertRun will validate and insert a new run. The ID and Started timestamp of the run are set from the inserted row.
+func InsertRun(pgPool *pgx.ConnPool, run *Run) error {
+	if err := run.Validate(); err != nil {
+		return err
+	}
+
+	conn, err := pgPool.Acquire()
+	if err != nil {
+		return err
+	}
+	defer pgPool.Release(conn)
+
+	return conn.QueryRow(
+		"INSERT INTO OAR_RUNS (NAME, BRANCH, COMMIT, ENVIRONMENT, DOC) VALUES ($1, $2, $3, $4, $5) "+
+			"RETURNING ID, STARTED",
+		run.Name,
+		run.Branch,
+		run.Commit,
+		run.Environment,
+		run.Doc,
+	).Scan(&run.ID, &run.Started)
+}
+
+// FinishRun will finish the run with an ID, so that no more results can be attached to it. Will return the summary of
+// the finished run, or nil if there is no run with the ID or it is already finished.
+func FinishRun(pgPool *pgx.ConnPool, runID uint64) (*RunSummary, error) {
+	runs, err := SelectRunSummaries(
+		pgPool,
+		"WITH R AS (UPDATE OAR_RUNS SET FINISHED = (NOW() AT TIME ZONE 'UTC') WHERE ID = $1 AND FINISHED IS NULL "+
+			"RETURNING *) SELECT "+runSummaryColumns+" FROM R "+runTotals,
+		runID,
+	)
+	if err != nil || len(runs) == 0 {
+		return nil, err
+	}
+
+	return runs[0], nil
+}
+
+// SelectRunSummaries will take in a query that returns rows of runSummaryColumns and deserialize them.
+// args will be passed down to Conn.query
+func SelectRunSummaries(pgPool *pgx.ConnPool, query string, args ...any) ([]*RunSummary, error) {
+	conn, err := pgPool.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer pgPool.Release(conn)
+
+	rows, err := conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []*RunSummary{}
+	for rows.Next() {
+		run := &RunSummary{}
+		var finished pgtype.Timestamp
+		err = rows.Scan(
+			&run.ID,
+			&run.Name,
+			&run.Branch,
+			&run.Commit,
+			&run.Environment,
+			&run.Doc,
+			&run.Started,
+			&finished,
+			&run.Duration,
+			&run.Total,
+			&run.Passed,
+			&run.Failed,
+			&run.NotAnalyzed,
+			&run.ConfusionMatrix.TruePositive,
+			&run.ConfusionMatrix.FalsePositive,
+			&run.ConfusionMatrix.TrueNegative,
+			&run.ConfusionMatrix.FalseNegative,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if finished.Status == pgtype.Present {
+			run.Finished = &finished.Time
+		}
+		runs = append(runs, run)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
+
+// SelectRunTests will select the latest result of each test definition in the run with an ID. Results that are soft
+// deleted or not linked to a test definition are not selected.
+func SelectRunTests(pgPool *pgx.ConnPool, runID uint64) ([]*Test, error) {
+	return SelectTests(
+		pgPool,
+		"SELECT DISTINCT ON (DEFINITION_ID) * FROM OAR_TESTS WHERE RUN_ID = $1 AND DEFINITION_ID IS NOT NULL AND "+
+			testNotDeleted+" ORDER BY DEFINITION_ID, CREATED DESC, ID DESC",
+		runID,
+	)
+}
+
+// scanIDs will read every ID returned by a query, sorted in ascending order
+func scanIDs(rows *pgx.Rows) ([]uint64, error) {
+	defer rows.Close()
+
+	ids := []uint64{}
+	for rows.Next() {
+		var id uint64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.Sort(ids)
+
+	return ids, nil
+}
+
+// apiKeyColumns are the columns of oar_api_keys that are scanned into an APIKey by scanAPIKeys, in order
+const apiKeyColumns = "ID, NAME, PREFIX, SCOPES, CREATED, LAST_USED, REVOKED"
+
+// InsertAPIKey will validate and insert a new API key with the hash of the key. The ID and Created timestamp of the
+// API key are set from the inserted row.
+func InsertAPIKey(pgPool *pgx.ConnPool, apiKey *APIKey, hash string) error {
+	if err := apiKey.Validate(); err != nil {
+		return err
+	}
+
+	conn, err := pgPool.Acquire()
+	if err != nil {
+		return err
+	}
+	defer pgPool.Release(conn)
+
+	scopes := make([]string, len(apiKey.Scopes))
+	for i, scope := range apiKey.Scopes {
+		scopes[i] = string(scope)
+	}
+
+	return conn.QueryRow(
+		"INSERT INTO OAR_API_KEYS (NAME, PREFIX, HASH, SCOPES) VALUES ($1, $2, $3, $4) RETURNING ID, CREATED",
+		apiKey.Name,
+		apiKey.Prefix,
+		hash,
+		scopes,
+	).Scan(&apiKey.ID, &apiKey.Created)
+}
+
+// SelectAPIKeys will select every API key, oldest first. Revoked API keys are only selected if includeRevoked is true.
+func SelectAPIKeys(pgPool *pgx.ConnPool, includeRevoked bool) ([]*APIKey, error) {
+	conn, err := pgPool.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer pgPool.Release(conn)
+
+	query := "SELECT " + apiKeyColumns + " FROM OAR_API_KEYS"
+	if !includeRevoked {
+		query += " WHERE REVOKED IS NULL"
+	}
+	rows, err := conn.Query(query + " ORDER BY ID")
+	if err != nil {
+		return nil, err
+	}
+
+	return scanAPIKeys(rows)
+}
+
+// apiKeyLastUsedInterval is how often AuthenticateAPIKey will update when an API key was last used, so that every
+// request with the same key does not write to and lock its row
+const apiKeyLastUsedInterval = time.Minute
+
+// AuthenticateAPIKey will select the API key with a hash, if it is not revoked, and mark it as used if it was last
+// marked over apiKeyLastUsedInterval ago. Will return nil if there is no such API key.
+func AuthenticateAPIKey(pgPool *pgx.ConnPool, hash string) (*APIKey, error) {
+	conn, err := pgPool.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer pgPool.Release(conn)
+
+	rows, err := conn.Query(
+		"SELECT "+apiKeyColumns+" FROM OAR_API_KEYS WHERE HASH = $1 AND REVOKED IS NULL",
+		hash,
+	)
+	if err != nil {
+		return nil, err
+	}
+	apiKeys, err := scanAPIKeys(rows)
+	if err != nil || len(apiKeys) == 0 {
+		return nil, err
+	}
+	apiKey := apiKeys[0]
+
+	now := time.Now().UTC()
+	if apiKey.LastUsed == nil || now.Sub(*apiKey.LastUsed) >= apiKeyLastUsedInterval {
+		// Another request could have marked it as used since it was selected
+		_, err = conn.Exec(
+			"UPDATE OAR_API_KEYS SET LAST_USED = (NOW() AT TIME ZONE 'UTC') WHERE ID = $1 AND "+
+				"(LAST_USED IS NULL OR LAST_USED < (NOW() AT TIME ZONE 'UTC') - MAKE_INTERVAL(SECS => $2))",
+			apiKey.ID,
+			apiKeyLastUsedInterval.Seconds(),
+		)
+		if err != nil {
+			return nil, err
+		}
+		apiKey.LastUsed = &now
+	}
+
+	return apiKey, nil
+}
+
+// RevokeAPIKey will revoke an API key by ID, so it can no longer be used. Will return the revoked API key, or nil if
+// there is no API key with the ID or it is already revoked.
+func RevokeAPIKey(pgPool *pgx.ConnPool, apiKeyID uint64) (*APIKey, error) {
+	conn, err := pgPool.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer pgPool.Release(conn)
+
+	rows, err := conn.Query(
+		"UPDATE OAR_API_KEYS SET REVOKED = (NOW() AT TIME ZONE 'UTC') WHERE ID = $1 AND REVOKED IS NULL "+
+			"RETURNING "+apiKeyColumns,
+		apiKeyID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	apiKeys, err := scanAPIKeys(rows)
+	if err != nil || len(apiKeys) == 0 {
+		return nil, err
+	}
+
+	return apiKeys[0], nil
+}
+
+// scanAPIKeys will deserialize every row of a query that returns the apiKeyColumns
+func scanAPIKeys(rows *pgx.Rows) ([]*APIKey, error) {
+	defer rows.Close()
+
+	apiKeys := []*APIKey{}
+	for rows.Next() {
+		apiKey := &APIKey{}
+		var scopes []string
+		var lastUsed, revoked pgtype.Timestamp
+		err := rows.Scan(&apiKey.ID, &apiKey.Name, &apiKey.Prefix, &scopes, &apiKey.Created, &lastUsed, &revoked)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, scope := range scopes {
+			apiKey.Scopes = append(apiKey.Scopes, Scope(scope))
+		}
+		if lastUsed.Status == pgtype.Present {
+			apiKey.LastUsed = &lastUsed.Time
+		}
+		if revoked.Status == pgtype.Present {
+			apiKey.Revoked = &revoked.Time
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return apiKeys, nil
 }
@@ -4,7 +4,9 @@ package main
 
 import (
 	"github.com/jackc/pgx"
+	"github.com/magiconair/properties/assert"
 	"testing"
+	"time"
 )
 
 // TestNewPGPoolPositive ensures NewPGPool works with a valid config
@@ -73,7 +75,7 @@ func TestSelectCreateTests(t *testing.T) {
 	validTests := multiple(amountOfTests, Fake.test)
 
 	for _, validTest := range validTests {
-		_, err := InsertTest(pgPool, validTest)
+		_, err := InsertTest(pgPool, nil, validTest, Fake.definitionFields())
 		if err != nil {
 			t.Error("error during data setup", err)
 		}
@@ -97,50 +99,248 @@ func TestSelectCreateTests(t *testing.T) {
 	}
 }
 
-// TestDeleteTests will ensure that we can delete tests with DeleteTests. Also ensures that if you attempt to delete
-// tests that are already deleted, it will just return 0 rows affected with no errors.
-func TestDeleteTests(t *testing.T) {
-	amountOfTests := 5 // number of tests to create/read
+// TestInsertTests will ensure that a batch of tests gets inserted with IDs in the same order as the batch, and that an
+// invalid test in the batch stops the whole batch from being inserted
+func TestInsertTests(t *testing.T) {
+	pgPool := Fake.pgPool()
+	validTests := multiple(5, Fake.test)
+
+	testIDs, err := InsertTests(pgPool, nil, validTests, Fake.definitionFields())
+	if err != nil {
+		t.Error(err)
+	}
+
+	for i, testID := range testIDs {
+		tests, err := SelectTests(pgPool, "select * from oar_tests where id=$1", testID)
+		if err != nil || len(tests) != 1 {
+			t.Error("inserted test could not be selected", err)
+			continue
+		}
+		if tests[0].Summary != validTests[i].Summary || tests[0].Outcome != validTests[i].Outcome {
+			t.Error("inserted IDs are not in the same order as the batch")
+		}
+	}
 
+	t.Run("invalid test stops the batch", func(t *testing.T) {
+		invalidBatch := multiple(3, Fake.test)
+		invalidBatch[2].Outcome = "Skipped"
+
+		testIDs, err = InsertTests(pgPool, nil, invalidBatch, Fake.definitionFields())
+		if err == nil {
+			t.Error("invalid batch did not throw error")
+		}
+		if testIDs != nil {
+			t.Error("invalid batch returned IDs")
+		}
+	})
+}
+
+// TestPatchTests will ensure that a patch is applied to every matching test in a single transaction, that an invalid
+// patch stops every test from being updated and that a dry run does not write anything.
+func TestPatchTests(t *testing.T) {
 	pgPool := Fake.pgPool()
-	validTests := multiple(amountOfTests, Fake.test)
 
-	for _, validTest := range validTests {
-		_, err := InsertTest(pgPool, validTest)
+	testIDs, err := InsertTests(pgPool, nil, multiple(5, Fake.test), Fake.definitionFields())
+	if err != nil {
+		t.Error("setup error", err)
+	}
+
+	where, err := buildTestQueryWhere(&TestQuery{IDs: testIDs})
+	if err != nil {
+		t.Error("setup error", err)
+	}
+
+	t.Run("dry run does not write", func(t *testing.T) {
+		patchResponse, err := PatchTests(
+			pgPool,
+			nil,
+			where,
+			RightMergePatcher(&Test{Summary: "dry run summary"}),
+			Fake.definitionFields(),
+			true,
+		)
 		if err != nil {
-			t.Error("error during data setup", err)
+			t.Error(err)
+		}
+		assert.Equal(t, patchResponse.Matched, uint64(5))
+		assert.Equal(t, patchResponse.Count, uint64(5))
+		for _, test := range patchResponse.Tests {
+			assert.Equal(t, test.Summary, "dry run summary")
+		}
+
+		tests, err := SelectTests(pgPool, "select * from oar_tests where summary=$1", "dry run summary")
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, len(tests), 0)
+	})
+
+	t.Run("invalid patch updates nothing", func(t *testing.T) {
+		invalidPatch := RightMergePatcher(&Test{Outcome: Passed, Analysis: TruePositive})
+		_, err = PatchTests(pgPool, nil, where, invalidPatch, Fake.definitionFields(), false)
+		if err == nil {
+			t.Error("invalid patch did not throw error")
 		}
+
+		tests, err := SelectTests(
+			pgPool,
+			"select * from oar_tests where id = any($1) and analysis=$2",
+			testIDs,
+			TruePositive,
+		)
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, len(tests), 0)
+	})
+
+	t.Run("patch updates every match", func(t *testing.T) {
+		patchResponse, err := PatchTests(
+			pgPool,
+			nil,
+			where,
+			RightMergePatcher(&Test{Doc: map[string]any{"patched": true}}),
+			Fake.definitionFields(),
+			false,
+		)
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, patchResponse.Count, uint64(5))
+
+		tests, err := SelectTests(
+			pgPool,
+			"select * from oar_tests where id = any($1) and doc @> $2::jsonb",
+			testIDs,
+			`{"patched": true}`,
+		)
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, len(tests), 5)
+
+		// Patching again does not change anything
+		patchResponse, err = PatchTests(
+			pgPool,
+			nil,
+			where,
+			RightMergePatcher(&Test{Doc: map[string]any{"patched": true}}),
+			Fake.definitionFields(),
+			false,
+		)
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, patchResponse.Matched, uint64(5))
+		assert.Equal(t, patchResponse.Count, uint64(0))
+	})
+}
+
+// TestDeleteTestsWhere will ensure that every matching test is deleted, that a dry run does not delete anything and
+// that going over the max rows deletes nothing.
+func TestDeleteTestsWhere(t *testing.T) {
+	pgPool := Fake.pgPool()
+
+	testIDs, err := InsertTests(pgPool, nil, multiple(5, Fake.test), Fake.definitionFields())
+	if err != nil {
+		t.Error("setup error", err)
 	}
 
-	testsToDelete, err := SelectTests(
-		pgPool,
-		"select * from oar_tests order by created desc limit $1",
-		amountOfTests,
-	)
+	where, err := buildTestQueryWhere(&TestQuery{IDs: testIDs})
+	if err != nil {
+		t.Error("setup error", err)
+	}
 
-	var testIDsToDelete []uint64
+	deleteResponse, err := DeleteTestsWhere(pgPool, nil, where, 0, true)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, deleteResponse.Count, uint64(5))
+	assert.Equal(t, deleteResponse.IDs, testIDs)
+
+	_, err = DeleteTestsWhere(pgPool, nil, where, 4, false)
+	if err == nil {
+		t.Error("delete over the max rows did not throw error")
+	}
 
-	for _, testToDelete := range testsToDelete {
-		testIDsToDelete = append(testIDsToDelete, testToDelete.ID)
+	deleteResponse, err = DeleteTestsWhere(pgPool, nil, where, 5, false)
+	if err != nil {
+		t.Error(err)
 	}
+	assert.Equal(t, deleteResponse.Count, uint64(5))
 
-	rowsDeleted, err := DeleteTests(pgPool, testIDsToDelete)
+	tests, err := SelectTests(pgPool, "select * from oar_tests where id = any($1)", testIDs)
 	if err != nil {
 		t.Error(err)
 	}
+	for _, test := range tests {
+		if test.Deleted == nil {
+			t.Error("test was not soft deleted")
+		}
+	}
+
+	t.Run("restore", func(t *testing.T) {
+		restoreWhere, err := buildTestQueryWhere(&TestQuery{IDs: testIDs[:2], IncludeDeleted: true})
+		if err != nil {
+			t.Error("setup error", err)
+		}
+
+		restoredIDs, err := RestoreTestsWhere(pgPool, nil, restoreWhere)
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, restoredIDs, testIDs[:2])
+
+		tests, err = SelectTests(pgPool, "select * from oar_tests where id = any($1) and deleted is null", testIDs)
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, len(tests), 2)
+	})
+}
+
+// TestPurgeDeletedTests will ensure that only tests that have been deleted for longer than the retention are purged
+func TestPurgeDeletedTests(t *testing.T) {
+	pgPool := Fake.pgPool()
+
+	testIDs, err := InsertTests(pgPool, nil, multiple(2, Fake.test), Fake.definitionFields())
+	if err != nil {
+		t.Error("setup error", err)
+	}
 
-	if rowsDeleted != int64(amountOfTests) {
-		t.Error("not all tests were deleted")
+	where, err := buildTestQueryWhere(&TestQuery{IDs: testIDs[:1]})
+	if err != nil {
+		t.Error("setup error", err)
+	}
+	if _, err = DeleteTestsWhere(pgPool, nil, where, 0, false); err != nil {
+		t.Error("setup error", err)
 	}
 
-	rowsDeleted, err = DeleteTests(pgPool, testIDsToDelete)
+	// Nothing has been deleted for an hour yet
+	_, err = PurgeDeletedTests(pgPool, time.Hour)
 	if err != nil {
 		t.Error(err)
 	}
+	tests, err := SelectTests(pgPool, "select * from oar_tests where id = any($1)", testIDs)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, len(tests), 2)
 
-	if rowsDeleted != 0 {
-		t.Error("consecutive delete call deleted more rows")
+	purged, err := PurgeDeletedTests(pgPool, 0)
+	if err != nil {
+		t.Error(err)
 	}
+	if purged < 1 {
+		t.Error("deleted test was not purged")
+	}
+
+	tests, err = SelectTests(pgPool, "select * from oar_tests where id = any($1)", testIDs)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, len(tests), 1)
+	assert.Equal(t, tests[0].ID, testIDs[1])
 }
 
 // TestUpdateTest will check that we can update a valid test with valid details and rejects invalid tests.
@@ -148,7 +348,7 @@ func TestUpdateTest(t *testing.T) {
 	validTest := Fake.test()
 
 	pgPool := Fake.pgPool()
-	testID, err := InsertTest(pgPool, validTest)
+	testID, err := InsertTest(pgPool, nil, validTest, Fake.definitionFields())
 	if err != nil {
 		t.Error("setup error", err)
 	}
@@ -185,7 +385,7 @@ func TestUpdateTest(t *testing.T) {
 		t.Run(scenario, func(t *testing.T) {
 			test.Merge(testPatch)
 
-			err = UpdateTest(pgPool, test)
+			err = UpdateTest(pgPool, nil, test, Fake.definitionFields())
 			if err != nil {
 				t.Error(err)
 			}
@@ -204,7 +404,7 @@ func TestUpdateTest(t *testing.T) {
 	t.Run("Test test must be valid to be updated", func(t *testing.T) {
 		test.Summary = ""
 
-		err = UpdateTest(pgPool, test)
+		err = UpdateTest(pgPool, nil, test, Fake.definitionFields())
 		if err == nil {
 			t.Error("invalid test did not throw error")
 		}
@@ -218,3 +418,81 @@ func TestUpdateTest(t *testing.T) {
 		}
 	})
 }
+
+// TestSelectTestHistory will ensure that every change to a test is recorded with its audit, and that tests can be
+// queried by their changes
+func TestSelectTestHistory(t *testing.T) {
+	pgPool := Fake.pgPool()
+	ingest := &Audit{Actor: "ci", RequestID: "request-1"}
+	enrich := &Audit{Actor: Fake.testSummary(), RequestID: "request-2"}
+
+	test := Fake.test()
+	test.Outcome = Failed
+	test.Analysis = NotAnalyzed
+	testID, err := InsertTest(pgPool, ingest, test, Fake.definitionFields())
+	if err != nil {
+		t.Error("setup error", err)
+	}
+	test.ID = testID
+	test.Analysis = FalsePositive
+	if err = UpdateTest(pgPool, enrich, test, Fake.definitionFields()); err != nil {
+		t.Error("setup error", err)
+	}
+	where, err := buildTestQueryWhere(&TestQuery{IDs: []uint64{testID}})
+	if err != nil {
+		t.Error("setup error", err)
+	}
+	if _, err = DeleteTestsWhere(pgPool, nil, where, 0, false); err != nil {
+		t.Error("setup error", err)
+	}
+
+	history, err := SelectTestHistory(pgPool, testID)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, len(history), 3)
+
+	assert.Equal(t, history[0].Operation, TestInserted)
+	assert.Equal(t, history[0].Actor, "ci")
+	assert.Equal(t, history[0].RequestID, "request-1")
+	assert.Equal(t, history[0].Before == nil, true)
+
+	assert.Equal(t, history[1].Operation, TestUpdated)
+	assert.Equal(t, history[1].Actor, enrich.Actor)
+	assert.Equal(t, history[1].Fields, []string{"analysis"})
+	assert.Equal(t, history[1].Before["analysis"], string(NotAnalyzed))
+	assert.Equal(t, history[1].After["analysis"], string(FalsePositive))
+
+	assert.Equal(t, history[2].Operation, TestDeleted)
+	assert.Equal(t, history[2].Actor, "")
+	assert.Equal(t, history[2].Fields, []string{"deleted"})
+
+	t.Run("query by change", func(t *testing.T) {
+		changeWhere, err := buildTestQueryWhere(&TestQuery{
+			IncludeDeleted: true,
+			Changes:        []TestChange{{Field: "analysis", Actor: enrich.Actor}},
+		})
+		if err != nil {
+			t.Error("setup error", err)
+		}
+		tests, err := SelectTests(pgPool, "SELECT * FROM OAR_TESTS"+changeWhere.String(), changeWhere.params...)
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, len(tests), 1)
+		assert.Equal(t, tests[0].ID, testID)
+
+		changeWhere, err = buildTestQueryWhere(&TestQuery{
+			IncludeDeleted: true,
+			Changes:        []TestChange{{Field: "resolution", Actor: enrich.Actor}},
+		})
+		if err != nil {
+			t.Error("setup error", err)
+		}
+		tests, err = SelectTests(pgPool, "SELECT * FROM OAR_TESTS"+changeWhere.String(), changeWhere.params...)
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, len(tests), 0)
+	})
+}
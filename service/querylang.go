@@ -0,0 +1,615 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QueryParseError is returned when a textual query cannot be parsed. Pos is the 0-based byte offset in the query
+// where the problem was found.
+type QueryParseError struct {
+	Pos int
+	Msg string
+}
+
+func (e *QueryParseError) Error() string {
+	return fmt.Sprintf("query parse error at position %d: %s", e.Pos, e.Msg)
+}
+
+// queryTermError is an error in a single part of a field term, the part is either "field", "operator" or "value"
+type queryTermError struct {
+	part string
+	msg  string
+}
+
+func (e *queryTermError) Error() string {
+	return e.msg
+}
+
+// queryNode is a node of a parsed textual query, before it is converted into a TestQuery
+type queryNode struct {
+	and      []*queryNode
+	or       []*queryNode
+	not      *queryNode
+	term     *TestQuery // A field term, like outcome:Failed
+	search   string     // A bare word or quoted phrase
+	sort     []TestSort // A sort:key term
+	position int
+}
+
+// queryParser is a recursive descent parser for the textual query syntax. See ParseTextQuery.
+type queryParser struct {
+	input string
+	pos   int
+}
+
+// ParseTextQuery will parse a compact textual query into a TestQuery, for example:
+//
+//	outcome:Failed analysis:NotAnalyzed doc.env:prod created>2024-01-01 summary:"login"
+//
+// Terms next to each other are a logical AND. Terms can also be joined with OR, negated with a leading "-" or NOT
+// and grouped with parentheses. A term is one of:
+//
+//   - id, summary, outcome, analysis, resolution, definition or run followed by ":" and a value. Multiple values can
+//     be separated with commas, which is a logical OR. Summaries are case-insensitive regular expressions,
+//     definitions are test definition IDs and runs are run IDs.
+//   - created or modified followed by ">", ">=", "<", "<=" or ":" and a date or RFC 3339 timestamp. ":" matches the
+//     whole day.
+//   - A path into the Doc, like doc.env, followed by ":" or "=" and one or more values, "!=", ">", ">=", "<" or "<="
+//     and a value, or ":*" to check that the path exists. Values that look like numbers, booleans or null are
+//     matched as JSON numbers, booleans or null; quote them to match them as strings.
+//   - sort followed by ":" and a sort key, with a leading "-" for descending. Only allowed at the top level.
+//   - Any other word or quoted phrase, which is a keyword search. See TestQuery.Search.
+//
+// Values with spaces can be quoted with double quotes.
+func ParseTextQuery(input string) (*TestQuery, error) {
+	parser := &queryParser{input: input}
+
+	parser.skipSpaces()
+	if parser.done() {
+		return &TestQuery{}, nil
+	}
+
+	node, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	parser.skipSpaces()
+	if !parser.done() {
+		return nil, parser.unexpected()
+	}
+
+	return queryNodeToTestQuery(node)
+}
+
+// queryNodeToTestQuery will convert a parsed query tree into a TestQuery. Searches and sorts at the top level of the
+// query are put on the TestQuery itself so that searches are ranked, everything else becomes the Filter.
+func queryNodeToTestQuery(node *queryNode) (*TestQuery, error) {
+	topLevelNodes := []*queryNode{node}
+	if node.and != nil {
+		topLevelNodes = node.and
+	}
+
+	query := &TestQuery{}
+	var searches []string
+	var filters []*TestFilter
+	for _, topLevelNode := range topLevelNodes {
+		switch {
+		case topLevelNode.sort != nil:
+			query.Sort = append(query.Sort, topLevelNode.sort...)
+		case topLevelNode.search != "":
+			searches = append(searches, topLevelNode.search)
+		default:
+			filter, err := queryNodeToTestFilter(topLevelNode)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, filter)
+		}
+	}
+
+	query.Search = strings.Join(searches, " ")
+	if len(filters) == 1 {
+		query.Filter = filters[0]
+	} else if len(filters) > 1 {
+		query.Filter = &TestFilter{And: filters}
+	}
+
+	return query, nil
+}
+
+// queryNodeToTestFilter will convert a nested query tree node into a TestFilter
+func queryNodeToTestFilter(node *queryNode) (*TestFilter, error) {
+	switch {
+	case node.sort != nil:
+		return nil, &QueryParseError{Pos: node.position, Msg: "sort can only be used at the top level of a query"}
+	case node.term != nil:
+		return &TestFilter{Query: node.term}, nil
+	case node.search != "":
+		return &TestFilter{Query: &TestQuery{Search: node.search}}, nil
+	case node.not != nil:
+		filter, err := queryNodeToTestFilter(node.not)
+		if err != nil {
+			return nil, err
+		}
+		return &TestFilter{Not: filter}, nil
+	}
+
+	nodes, filter := node.and, &TestFilter{}
+	if node.or != nil {
+		nodes = node.or
+	}
+	for _, childNode := range nodes {
+		childFilter, err := queryNodeToTestFilter(childNode)
+		if err != nil {
+			return nil, err
+		}
+		if node.or != nil {
+			filter.Or = append(filter.Or, childFilter)
+		} else {
+			filter.And = append(filter.And, childFilter)
+		}
+	}
+	return filter, nil
+}
+
+// parseOr parses: and ("OR" and)*
+func (p *queryParser) parseOr() (*queryNode, error) {
+	position := p.pos
+	node, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []*queryNode{node}
+	for p.keyword("OR") {
+		node, err = p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return &queryNode{or: nodes, position: position}, nil
+}
+
+// parseAnd parses: unary (["AND"] unary)*
+func (p *queryParser) parseAnd() (*queryNode, error) {
+	position := p.pos
+	var nodes []*queryNode
+	for {
+		p.skipSpaces()
+		if p.done() || p.peek() == ')' || p.peekKeyword("OR") {
+			break
+		}
+		if len(nodes) > 0 {
+			p.keyword("AND")
+		}
+
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	if len(nodes) == 0 {
+		return nil, p.errorf("expected a term")
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return &queryNode{and: nodes, position: position}, nil
+}
+
+// parseUnary parses: ("-" | "NOT") unary | "(" or ")" | term
+func (p *queryParser) parseUnary() (*queryNode, error) {
+	p.skipSpaces()
+	position := p.pos
+
+	if p.keyword("NOT") || (p.peek() == '-' && p.pos+1 < len(p.input) && !isQuerySpace(p.input[p.pos+1])) {
+		if p.peek() == '-' {
+			p.pos++
+		}
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &queryNode{not: node, position: position}, nil
+	}
+
+	if p.peek() == '(' {
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+		if p.peek() != ')' {
+			return nil, p.errorf("expected ')' to close '(' at position %d", position)
+		}
+		p.pos++
+		return node, nil
+	}
+
+	return p.parseTerm()
+}
+
+// parseTerm parses a single field term, sort term or search word/phrase
+func (p *queryParser) parseTerm() (*queryNode, error) {
+	position := p.pos
+
+	if p.peek() == '"' {
+		phrase, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		return &queryNode{search: `"` + phrase + `"`, position: position}, nil
+	}
+
+	word := p.readWhile(func(c byte) bool {
+		return !isQuerySpace(c) && !strings.ContainsRune("()\":=!<>", rune(c))
+	})
+	if word == "" {
+		return nil, p.unexpected()
+	}
+
+	operator := p.readWhile(func(c byte) bool { return strings.ContainsRune(":=!<>", rune(c)) })
+	if operator == "" {
+		return &queryNode{search: word, position: position}, nil
+	}
+	operatorPosition := p.pos - len(operator)
+
+	if strings.ToLower(word) == "sort" {
+		return p.parseSortTerm(operator, operatorPosition, position)
+	}
+
+	valuesPosition := p.pos
+	values, quoted, err := p.parseValues()
+	if err != nil {
+		return nil, err
+	}
+
+	term, err := queryTerm(word, operator, values, quoted)
+	if err != nil {
+		// Points to the part of the term that is wrong
+		errorPosition := valuesPosition
+		switch err.(*queryTermError).part {
+		case "field":
+			errorPosition = position
+		case "operator":
+			errorPosition = operatorPosition
+		}
+		return nil, &QueryParseError{Pos: errorPosition, Msg: err.Error()}
+	}
+
+	return &queryNode{term: term, position: position}, nil
+}
+
+// parseSortTerm parses the value of a sort:key term
+func (p *queryParser) parseSortTerm(operator string, operatorPosition int, position int) (*queryNode, error) {
+	if operator != ":" {
+		return nil, &QueryParseError{Pos: operatorPosition, Msg: "invalid operator for sort, must be ':'"}
+	}
+
+	keysPosition := p.pos
+	keys, _, err := p.parseValues()
+	if err != nil {
+		return nil, err
+	}
+
+	var sorts []TestSort
+	for _, key := range keys {
+		sort := TestSort{Key: key, Direction: "asc"}
+		if strings.HasPrefix(key, "-") {
+			sort = TestSort{Key: key[1:], Direction: "desc"}
+		}
+		if _, err = buildTestQueryOrderBy([]TestSort{sort}, &sqlWhere{}); err != nil {
+			return nil, &QueryParseError{Pos: keysPosition, Msg: err.Error()}
+		}
+		sorts = append(sorts, sort)
+	}
+
+	return &queryNode{sort: sorts, position: position}, nil
+}
+
+// parseValues parses a comma separated list of values, each of which can be quoted. Also returns whether each value
+// was quoted.
+func (p *queryParser) parseValues() ([]string, []bool, error) {
+	var values []string
+	var quoted []bool
+	for {
+		if p.peek() == '"' {
+			value, err := p.parseQuoted()
+			if err != nil {
+				return nil, nil, err
+			}
+			values = append(values, value)
+			quoted = append(quoted, true)
+		} else {
+			value := p.readWhile(func(c byte) bool { return !isQuerySpace(c) && c != ',' && c != ')' })
+			if value == "" {
+				return nil, nil, p.errorf("expected a value")
+			}
+			values = append(values, value)
+			quoted = append(quoted, false)
+		}
+
+		if p.peek() != ',' {
+			return values, quoted, nil
+		}
+		p.pos++
+	}
+}
+
+// parseQuoted parses a double-quoted string, a quote can be escaped inside with a backslash
+func (p *queryParser) parseQuoted() (string, error) {
+	start := p.pos
+	p.pos++ // Opening quote
+
+	var value strings.Builder
+	for !p.done() {
+		c := p.input[p.pos]
+		switch {
+		case c == '\\' && p.pos+1 < len(p.input):
+			value.WriteByte(p.input[p.pos+1])
+			p.pos += 2
+		case c == '"':
+			p.pos++
+			return value.String(), nil
+		default:
+			value.WriteByte(c)
+			p.pos++
+		}
+	}
+
+	return "", &QueryParseError{Pos: start, Msg: "unterminated quote"}
+}
+
+// queryTerm will convert a single field term into a TestQuery. Field names are case-insensitive, except for the keys
+// of doc paths.
+func queryTerm(field string, operator string, values []string, quoted []bool) (*TestQuery, error) {
+	if docPath, ok := parseDocPath(field); ok {
+		return queryDocTerm("doc."+strings.Join(docPath, "."), operator, values, quoted)
+	}
+
+	field = strings.ToLower(field)
+
+	switch field {
+	case "created", "modified":
+		return queryTimeTerm(field, operator, values)
+	case "id", "summary", "outcome", "analysis", "resolution", "definition", "run":
+	default:
+		return nil, &queryTermError{"field", fmt.Sprintf(
+			"unknown field: '%s', must be one of id, summary, outcome, analysis, resolution, definition, run, "+
+				"created, modified, sort or a path into the doc, like doc.env",
+			field,
+		)}
+	}
+
+	if operator != ":" && operator != "=" {
+		return nil, &queryTermError{"operator", fmt.Sprintf("invalid operator for %s: '%s', must be ':'", field, operator)}
+	}
+
+	query := &TestQuery{}
+	switch field {
+	case "id":
+		for _, value := range values {
+			id, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, &queryTermError{"value", fmt.Sprintf("invalid id: '%s'", value)}
+			}
+			query.IDs = append(query.IDs, id)
+		}
+	case "summary":
+		query.Summaries = values
+	case "outcome":
+		query.Outcomes = values
+	case "analysis":
+		query.Analyses = values
+	case "resolution":
+		query.Resolutions = values
+	case "definition":
+		for _, value := range values {
+			definitionID, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, &queryTermError{"value", fmt.Sprintf("invalid definition: '%s'", value)}
+			}
+			query.DefinitionIDs = append(query.DefinitionIDs, definitionID)
+		}
+	case "run":
+		for _, value := range values {
+			runID, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, &queryTermError{"value", fmt.Sprintf("invalid run: '%s'", value)}
+			}
+			query.RunIDs = append(query.RunIDs, runID)
+		}
+	}
+	return query, nil
+}
+
+// queryTimeTerm will convert a created or modified term into a TestQuery
+func queryTimeTerm(field string, operator string, values []string) (*TestQuery, error) {
+	if len(values) != 1 {
+		return nil, &queryTermError{"value", field + " only takes 1 value"}
+	}
+
+	value, isDate, err := parseQueryTime(values[0])
+	if err != nil {
+		return nil, &queryTermError{"value", err.Error()}
+	}
+
+	// Postgres timestamps have microsecond precision, so this turns the inclusive operators into exclusive ones
+	var before, after time.Time
+	switch operator {
+	case ">":
+		after = value
+	case ">=":
+		after = value.Add(-time.Microsecond)
+	case "<":
+		before = value
+	case "<=":
+		before = value.Add(time.Microsecond)
+	case ":", "=":
+		if !isDate {
+			return nil, &queryTermError{"value", field + " with ':' must be a date, like 2024-01-01"}
+		}
+		after = value.Add(-time.Microsecond)
+		before = value.AddDate(0, 0, 1)
+	default:
+		return nil, &queryTermError{
+			"operator",
+			fmt.Sprintf("invalid operator for %s: '%s', must be one of : > >= < <=", field, operator),
+		}
+	}
+
+	query := &TestQuery{}
+	if !before.IsZero() && field == "created" {
+		query.CreatedBefore = &before
+	}
+	if !after.IsZero() && field == "created" {
+		query.CreatedAfter = &after
+	}
+	if !before.IsZero() && field == "modified" {
+		query.ModifiedBefore = &before
+	}
+	if !after.IsZero() && field == "modified" {
+		query.ModifiedAfter = &after
+	}
+	return query, nil
+}
+
+// parseQueryTime will parse a date or RFC 3339 timestamp. Returns whether it was only a date.
+func parseQueryTime(value string) (time.Time, bool, error) {
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date, true, nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05"} {
+		if timestamp, err := time.Parse(layout, value); err == nil {
+			return timestamp, false, nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("invalid date: '%s', must be like 2024-01-01 or 2024-01-01T15:04:05Z", value)
+}
+
+// queryDocTerm will convert a doc path term into a TestQuery with a DocFilter
+func queryDocTerm(path string, operator string, values []string, quoted []bool) (*TestQuery, error) {
+	docValues := make([]any, len(values))
+	for i, value := range values {
+		docValues[i] = parseQueryDocValue(value, quoted[i])
+	}
+
+	filter := DocFilter{Path: path}
+	switch operator {
+	case ":", "=":
+		if len(values) == 1 && values[0] == "*" && !quoted[0] {
+			filter.Op = DocExists
+		} else if len(values) == 1 {
+			filter.Op, filter.Value = DocEqual, docValues[0]
+		} else {
+			filter.Op, filter.Values = DocIn, docValues
+		}
+	case "!=", ">", ">=", "<", "<=":
+		if len(values) != 1 {
+			return nil, &queryTermError{"value", fmt.Sprintf("'%s' only takes 1 value", operator)}
+		}
+		filter.Op, filter.Value = DocOperator(operator), docValues[0]
+		if _, isNumber := docValues[0].(float64); operator != "!=" && !isNumber {
+			return nil, &queryTermError{"value", fmt.Sprintf("'%s' must have a number value", operator)}
+		}
+	default:
+		return nil, &queryTermError{
+			"operator",
+			fmt.Sprintf("invalid operator for %s: '%s', must be one of : != > >= < <=", path, operator),
+		}
+	}
+
+	return &TestQuery{DocFilters: []DocFilter{filter}}, nil
+}
+
+// parseQueryDocValue will convert an unquoted doc value that looks like a JSON number, boolean or null into one,
+// everything else is a string
+func parseQueryDocValue(value string, quoted bool) any {
+	if quoted {
+		return value
+	}
+	switch value {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		return number
+	}
+	return value
+}
+
+// keyword will consume an uppercase keyword, like "OR", if it is next and is a whole word
+func (p *queryParser) keyword(keyword string) bool {
+	p.skipSpaces()
+	if !p.peekKeyword(keyword) {
+		return false
+	}
+	p.pos += len(keyword)
+	return true
+}
+
+// peekKeyword will check if an uppercase keyword is next and is a whole word
+func (p *queryParser) peekKeyword(keyword string) bool {
+	end := p.pos + len(keyword)
+	if !strings.HasPrefix(p.input[p.pos:], keyword) {
+		return false
+	}
+	return end == len(p.input) || isQuerySpace(p.input[end]) || p.input[end] == '(' || p.input[end] == ')'
+}
+
+// readWhile will consume and return characters while they match
+func (p *queryParser) readWhile(match func(c byte) bool) string {
+	start := p.pos
+	for !p.done() && match(p.input[p.pos]) {
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+func (p *queryParser) skipSpaces() {
+	p.readWhile(isQuerySpace)
+}
+
+func (p *queryParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *queryParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *queryParser) errorf(format string, args ...any) *QueryParseError {
+	return &QueryParseError{Pos: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// unexpected will return an error for the next character, or the end of the query
+func (p *queryParser) unexpected() *QueryParseError {
+	if p.done() {
+		return p.errorf("unexpected end of query")
+	}
+	return p.errorf("unexpected '%c'", p.peek())
+}
+
+// isQuerySpace checks if a character separates terms
+func isQuerySpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
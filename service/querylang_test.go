@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"github.com/magiconair/properties/assert"
+	"testing"
+	"time"
+)
+
+// TestParseTextQuery will ensure that valid textual queries are parsed into the equivalent TestQuery
+func TestParseTextQuery(t *testing.T) {
+	t.Run("empty query", func(t *testing.T) {
+		query, err := ParseTextQuery("  ")
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, query, &TestQuery{})
+	})
+
+	t.Run("terms next to each other are and-ed", func(t *testing.T) {
+		query, err := ParseTextQuery(`outcome:Failed analysis:NotAnalyzed doc.env:prod summary:"log in"`)
+		if err != nil {
+			t.Error(err)
+		}
+
+		assert.Equal(t, query, &TestQuery{Filter: &TestFilter{And: []*TestFilter{
+			{Query: &TestQuery{Outcomes: []string{"Failed"}}},
+			{Query: &TestQuery{Analyses: []string{"NotAnalyzed"}}},
+			{Query: &TestQuery{DocFilters: []DocFilter{{Path: "doc.env", Op: DocEqual, Value: "prod"}}}},
+			{Query: &TestQuery{Summaries: []string{"log in"}}},
+		}}})
+	})
+
+	t.Run("single term", func(t *testing.T) {
+		query, err := ParseTextQuery("id:1,2")
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, query, &TestQuery{Filter: &TestFilter{Query: &TestQuery{IDs: []uint64{1, 2}}}})
+	})
+
+	t.Run("definition term", func(t *testing.T) {
+		query, err := ParseTextQuery("definition:7 outcome:Failed")
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, query.Filter.And[0].Query, &TestQuery{DefinitionIDs: []uint64{7}})
+	})
+
+	t.Run("run term", func(t *testing.T) {
+		query, err := ParseTextQuery("run:4,5")
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, query, &TestQuery{Filter: &TestFilter{Query: &TestQuery{RunIDs: []uint64{4, 5}}}})
+	})
+
+	t.Run("or, not and parentheses", func(t *testing.T) {
+		query, err := ParseTextQuery("(outcome:Failed OR resolution:NotNeeded) -analysis:TruePositive NOT id:3")
+		if err != nil {
+			t.Error(err)
+		}
+
+		assert.Equal(t, query, &TestQuery{Filter: &TestFilter{And: []*TestFilter{
+			{Or: []*TestFilter{
+				{Query: &TestQuery{Outcomes: []string{"Failed"}}},
+				{Query: &TestQuery{Resolutions: []string{"NotNeeded"}}},
+			}},
+			{Not: &TestFilter{Query: &TestQuery{Analyses: []string{"TruePositive"}}}},
+			{Not: &TestFilter{Query: &TestQuery{IDs: []uint64{3}}}},
+		}}})
+	})
+
+	t.Run("top level words are a search and sort keys are sorts", func(t *testing.T) {
+		query, err := ParseTextQuery(`timeout "connection refused" sort:-created,summary`)
+		if err != nil {
+			t.Error(err)
+		}
+
+		assert.Equal(t, query, &TestQuery{
+			Search: `timeout "connection refused"`,
+			Sort:   []TestSort{{Key: "created", Direction: "desc"}, {Key: "summary", Direction: "asc"}},
+		})
+	})
+
+	t.Run("created and modified", func(t *testing.T) {
+		query, err := ParseTextQuery("created>2024-01-01 modified:2024-02-01")
+		if err != nil {
+			t.Error(err)
+		}
+
+		createdAfter := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		modifiedAfter := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC).Add(-time.Microsecond)
+		modifiedBefore := time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC)
+		assert.Equal(t, query, &TestQuery{Filter: &TestFilter{And: []*TestFilter{
+			{Query: &TestQuery{CreatedAfter: &createdAfter}},
+			{Query: &TestQuery{ModifiedBefore: &modifiedBefore, ModifiedAfter: &modifiedAfter}},
+		}}})
+	})
+
+	t.Run("doc operators and value types", func(t *testing.T) {
+		query, err := ParseTextQuery(`doc.retries>=2 doc.flaky:true doc.build:"42" doc.env:dev,qa doc.owner:* doc.team!=core`)
+		if err != nil {
+			t.Error(err)
+		}
+
+		var docFilters []DocFilter
+		for _, filter := range query.Filter.And {
+			docFilters = append(docFilters, filter.Query.DocFilters...)
+		}
+		assert.Equal(t, docFilters, []DocFilter{
+			{Path: "doc.retries", Op: DocGreaterThanOrEqual, Value: float64(2)},
+			{Path: "doc.flaky", Op: DocEqual, Value: true},
+			{Path: "doc.build", Op: DocEqual, Value: "42"},
+			{Path: "doc.env", Op: DocIn, Values: []any{"dev", "qa"}},
+			{Path: "doc.owner", Op: DocExists},
+			{Path: "doc.team", Op: DocNotEqual, Value: "core"},
+		})
+	})
+
+	t.Run("doc keys keep their case", func(t *testing.T) {
+		query, err := ParseTextQuery("Outcome:Passed doc.className:Login")
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, query.Filter.And[1].Query.DocFilters[0].Path, "doc.className")
+	})
+}
+
+// TestParseTextQueryErrors will ensure that invalid textual queries return the position of the problem
+func TestParseTextQueryErrors(t *testing.T) {
+	invalidQueries := map[string]int{
+		"outcome:":                     8,
+		"colour:red":                   0,
+		"outcome>Failed":               7,
+		"id:one":                       3,
+		"definition:login":             11,
+		`summary:"unterminated`:        8,
+		"(outcome:Failed":              15,
+		"outcome:Failed)":              14,
+		"created>yesterday":            8,
+		"doc.retries>many":             12,
+		"(sort:created OR id:1)":       1,
+		"sort:password":                5,
+		"outcome:Failed OR":            17,
+		"outcome:Passed AND (id:1 OR)": 27,
+	}
+	for invalidQuery, position := range invalidQueries {
+		t.Run(invalidQuery, func(t *testing.T) {
+			_, err := ParseTextQuery(invalidQuery)
+
+			var queryParseErr *QueryParseError
+			if !errors.As(err, &queryParseErr) {
+				t.Fatalf("expected a QueryParseError, got: %v", err)
+			}
+			assert.Equal(t, queryParseErr.Pos, position)
+		})
+	}
+}
@@ -1,99 +1,470 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/jackc/pgx"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"log"
 	"strconv"
 	"strings"
+	"time"
 )
 
-// QueryTest will take a DB connection pool to the OAR DB, parse the query, apply the limit and offset and return
-// the query response.
-// See GetTests for more info
-func QueryTest(dbPool *pgx.ConnPool, query *TestQuery, limit int, offset int) (*TestQueryResponse, error) {
-	// Build query
-	var wheres []string // Will contain all the "WHERE" clauses
-	var params []any    // These are the parameters to pass for the SQL prepared statement
+// testSearchVector is the full-text search document of a test: the summary, weighted highest, and every string value
+// in the Doc. It must stay the same as the expression of the oar_tests_search index, or searches will not use it.
+const testSearchVector = "(SETWEIGHT(TO_TSVECTOR('english', SUMMARY), 'A') || " +
+	"SETWEIGHT(JSONB_TO_TSVECTOR('english', COALESCE(DOC, '{}'), '[\"string\"]'), 'B'))"
+
+// exactCountLimit is the max amount of matching tests that QueryTest will count exactly. Past this, counting every
+// row gets slow on a large oar_tests table, so the total is estimated from the query plan instead.
+const exactCountLimit = 100000
+
+// sqlWhere accumulates the conditions of a SQL WHERE clause along with the parameters to pass for the prepared
+// statement. All conditions are joined with AND.
+type sqlWhere struct {
+	conditions []string
+	params     []any
+}
+
+// param will add a parameter to the prepared statement and return its placeholder, like "$3"
+func (w *sqlWhere) param(value any) string {
+	w.params = append(w.params, value)
+	return "$" + strconv.Itoa(len(w.params))
+}
+
+// and will add a condition to the WHERE clause
+func (w *sqlWhere) and(condition string) {
+	w.conditions = append(w.conditions, condition)
+}
+
+// String will return the full WHERE clause, or an empty string if there are no conditions
+func (w *sqlWhere) String() string {
+	if len(w.conditions) == 0 {
+		return ""
+	}
+	return " " + "WHERE" + " " + strings.Join(w.conditions, " "+"AND"+" ")
+}
+
+// clone will return a copy of the WHERE clause that conditions and parameters can be added to without changing this one
+func (w *sqlWhere) clone() *sqlWhere {
+	return &sqlWhere{conditions: slices.Clone(w.conditions), params: slices.Clone(w.params)}
+}
+
+// condition will return all the conditions as a single condition, or TRUE if there are no conditions
+func (w *sqlWhere) condition() string {
+	if len(w.conditions) == 0 {
+		return "TRUE"
+	}
+	return "(" + strings.Join(w.conditions, " "+"AND"+" ") + ")"
+}
+
+// testNotDeleted is the condition that excludes soft deleted tests
+const testNotDeleted = "DELETED IS NULL"
 
-	SQL := "SELECT * FROM OAR_TESTS"
+// buildTestQueryWhere will convert a TestQuery into a WHERE clause over the oar_tests table. Soft deleted tests are
+// excluded unless the query has IncludeDeleted.
+func buildTestQueryWhere(query *TestQuery) (*sqlWhere, error) {
+	where := &sqlWhere{}
+	if query == nil {
+		where.and(testNotDeleted)
+		return where, nil
+	}
+
+	if err := addTestQueryConditions(query, where); err != nil {
+		return nil, err
+	}
+	if !query.IncludeDeleted {
+		where.and(testNotDeleted)
+	}
+	return where, nil
+}
+
+// addTestQueryConditions will add a condition to the WHERE clause for every attribute of the TestQuery
+func addTestQueryConditions(query *TestQuery, where *sqlWhere) error {
 	if len(query.IDs) > 0 {
-		wheres = append(wheres, "ID = ANY($)")
-		params = append(params, query.IDs)
+		where.and("ID = ANY(" + where.param(query.IDs) + ")")
 	}
 
-	if query != nil && len(query.Summaries) > 0 {
-		wheres = append(wheres, "SUMMARY ~* "+"'"+strings.Join(query.Summaries, "|")+"'")
+	if len(query.Summaries) > 0 {
+		where.and("SUMMARY ~* ANY(" + where.param(query.Summaries) + ")")
 	}
 
-	if query != nil && len(query.Outcomes) > 0 {
-		wheres = append(wheres, "OUTCOME = ANY($)")
-		params = append(params, query.Outcomes)
+	if len(query.DefinitionIDs) > 0 {
+		where.and("DEFINITION_ID = ANY(" + where.param(query.DefinitionIDs) + ")")
 	}
 
-	if query != nil && len(query.Analyses) > 0 {
-		wheres = append(wheres, "ANALYSIS = ANY($)")
-		params = append(params, query.Analyses)
+	if len(query.RunIDs) > 0 {
+		where.and("RUN_ID = ANY(" + where.param(query.RunIDs) + ")")
 	}
 
-	if query != nil && len(query.Resolutions) > 0 {
-		wheres = append(wheres, "RESOLUTION = ANY($)")
-		params = append(params, query.Resolutions)
+	if query.Search != "" {
+		where.and(testSearchVector + " @@ WEBSEARCH_TO_TSQUERY('english', " + where.param(query.Search) + ")")
 	}
 
-	if query != nil && query.CreatedBefore != nil {
-		wheres = append(wheres, "CREATED < $")
-		params = append(params, query.CreatedBefore)
+	if len(query.Outcomes) > 0 {
+		where.and("OUTCOME = ANY(" + where.param(query.Outcomes) + ")")
 	}
 
-	if query != nil && query.CreatedAfter != nil {
-		wheres = append(wheres, "CREATED > $")
-		params = append(params, query.CreatedAfter)
+	if len(query.Analyses) > 0 {
+		where.and("ANALYSIS = ANY(" + where.param(query.Analyses) + ")")
 	}
 
-	if query != nil && query.ModifiedBefore != nil {
-		wheres = append(wheres, "MODIFIED < $")
-		params = append(params, query.ModifiedBefore)
+	if len(query.Resolutions) > 0 {
+		where.and("RESOLUTION = ANY(" + where.param(query.Resolutions) + ")")
 	}
 
-	if query != nil && query.ModifiedAfter != nil {
-		wheres = append(wheres, "MODIFIED > $")
-		params = append(params, query.ModifiedAfter)
+	if query.CreatedBefore != nil {
+		where.and("CREATED < " + where.param(query.CreatedBefore))
 	}
 
-	if query != nil && len(query.Docs) > 0 {
-		docQuery := "("
-		for i, doc := range query.Docs {
-			if i == 0 {
-				docQuery += "doc @> '$'"
-			} else {
-				docQuery += " " + "OR" + " " + "doc @> '$'"
-			}
+	if query.CreatedAfter != nil {
+		where.and("CREATED > " + where.param(query.CreatedAfter))
+	}
+
+	if query.ModifiedBefore != nil {
+		where.and("MODIFIED < " + where.param(query.ModifiedBefore))
+	}
+
+	if query.ModifiedAfter != nil {
+		where.and("MODIFIED > " + where.param(query.ModifiedAfter))
+	}
+
+	if len(query.Docs) > 0 {
+		var docConditions []string
+		for _, doc := range query.Docs {
 			strDoc, err := json.Marshal(doc)
 			if err != nil {
-				return nil, err
+				return err
+			}
+			docConditions = append(docConditions, "DOC @> "+where.param(string(strDoc))+"::JSONB")
+		}
+		where.and("(" + strings.Join(docConditions, " "+"OR"+" ") + ")")
+	}
+
+	for _, docFilter := range query.DocFilters {
+		condition, err := buildDocFilterCondition(docFilter, where)
+		if err != nil {
+			return err
+		}
+		where.and(condition)
+	}
+
+	for _, change := range query.Changes {
+		condition, err := buildTestChangeCondition(change, where)
+		if err != nil {
+			return err
+		}
+		where.and(condition)
+	}
+
+	if query.Filter != nil {
+		condition, err := buildTestFilterCondition(query.Filter, where)
+		if err != nil {
+			return err
+		}
+		where.and(condition)
+	}
+
+	return nil
+}
+
+// testChangeFields are the oar_tests columns that a TestChange can match changes of
+var testChangeFields = []string{"summary", "outcome", "analysis", "resolution", "doc"}
+
+// buildTestChangeCondition will convert a TestChange into a SQL condition that checks the test history for a matching
+// update. The field is compared between the before and after snapshots of each update.
+func buildTestChangeCondition(change TestChange, where *sqlWhere) (string, error) {
+	conditions := []string{"H.TEST_ID = OAR_TESTS.ID", "H.OPERATION = '" + string(TestUpdated) + "'"}
+
+	if change.Field != "" {
+		field := strings.ToLower(change.Field)
+		if docKeys, ok := parseDocPath(change.Field); ok {
+			path := where.param(docKeys)
+			conditions = append(conditions, "H.BEFORE->'doc' #> "+path+" IS DISTINCT FROM H.AFTER->'doc' #> "+path)
+		} else if slices.Contains(testChangeFields, field) {
+			conditions = append(conditions, "H.BEFORE->'"+field+"' IS DISTINCT FROM H.AFTER->'"+field+"'")
+		} else {
+			return "", fmt.Errorf(
+				"invalid change field: '%s', must be one of %s or a path into the doc, like doc.owner",
+				change.Field,
+				strings.Join(testChangeFields, ", "),
+			)
+		}
+	}
+
+	if change.Actor != "" {
+		conditions = append(conditions, "H.ACTOR = "+where.param(change.Actor))
+	}
+
+	if change.After != nil {
+		conditions = append(conditions, "H.TIMESTAMP > "+where.param(change.After))
+	}
+
+	if change.Before != nil {
+		conditions = append(conditions, "H.TIMESTAMP < "+where.param(change.Before))
+	}
+
+	return "EXISTS (SELECT 1 FROM OAR_TEST_HISTORY H WHERE " + strings.Join(conditions, " AND ") + ")", nil
+}
+
+// buildTestFilterCondition will recursively convert a TestFilter tree into a single SQL condition. Every value in the
+// tree is passed as a parameter.
+func buildTestFilterCondition(filter *TestFilter, where *sqlWhere) (string, error) {
+	nodesSet := 0
+	for _, isSet := range []bool{filter.And != nil, filter.Or != nil, filter.Not != nil, filter.Query != nil} {
+		if isSet {
+			nodesSet++
+		}
+	}
+	if nodesSet != 1 {
+		return "", errors.New("each filter must have exactly 1 of 'and', 'or', 'not' or 'query'")
+	}
+
+	switch {
+	case filter.Query != nil:
+		if len(filter.Query.Sort) > 0 {
+			return "", errors.New("a filter query cannot have a sort")
+		}
+		if filter.Query.IncludeDeleted {
+			return "", errors.New("a filter query cannot have includeDeleted, it can only be set on the top level query")
+		}
+
+		// The nested query shares parameters with the rest of the WHERE clause so that placeholders line up
+		nestedWhere := &sqlWhere{params: where.params}
+		if err := addTestQueryConditions(filter.Query, nestedWhere); err != nil {
+			return "", err
+		}
+		where.params = nestedWhere.params
+		return nestedWhere.condition(), nil
+	case filter.Not != nil:
+		condition, err := buildTestFilterCondition(filter.Not, where)
+		if err != nil {
+			return "", err
+		}
+		// A condition on a missing doc path is NULL rather than FALSE, so it has to be coalesced for NOT to match it
+		return "(NOT COALESCE(" + condition + ", FALSE))", nil
+	default:
+		operator, nodes := "AND", filter.And
+		if filter.Or != nil {
+			operator, nodes = "OR", filter.Or
+		}
+		if len(nodes) == 0 {
+			return "", fmt.Errorf("'%s' filter must have at least 1 filter", strings.ToLower(operator))
+		}
+
+		conditions := make([]string, 0, len(nodes))
+		for _, node := range nodes {
+			condition, err := buildTestFilterCondition(node, where)
+			if err != nil {
+				return "", err
 			}
-			docQuery = strings.Replace(docQuery, "$", string(strDoc), 1)
+			conditions = append(conditions, condition)
+		}
+		return "(" + strings.Join(conditions, " "+operator+" ") + ")", nil
+	}
+}
+
+// buildDocFilterCondition will convert a DocFilter into a SQL condition. The path and values are always passed as
+// parameters. See DocFilter for the supported operators.
+func buildDocFilterCondition(filter DocFilter, where *sqlWhere) (string, error) {
+	docPath, ok := parseDocPath(filter.Path)
+	if !ok {
+		return "", fmt.Errorf("invalid doc filter path: '%s', must be a path into the doc, like 'doc.env'", filter.Path)
+	}
+	path := where.param(docPath)
+
+	// numeric will only cast the value at the path to a number if it is one, otherwise it will be null
+	numeric := "(CASE WHEN JSONB_TYPEOF(DOC #> " + path + ") = 'number' THEN (DOC #>> " + path + ")::NUMERIC END)"
+
+	switch filter.Op {
+	case DocExists:
+		return "DOC #> " + path + " IS NOT NULL", nil
+	case DocMissing:
+		return "DOC #> " + path + " IS NULL", nil
+	case DocEqual, DocNotEqual:
+		value, err := json.Marshal(filter.Value)
+		if err != nil {
+			return "", err
+		}
+		operator := "="
+		if filter.Op == DocNotEqual {
+			operator = "<>"
+		}
+		return "DOC #> " + path + " " + operator + " " + where.param(string(value)) + "::JSONB", nil
+	case DocLessThan, DocLessThanOrEqual, DocGreaterThan, DocGreaterThanOrEqual:
+		value, ok := filter.Value.(float64)
+		if !ok {
+			return "", fmt.Errorf("doc filter '%s' on '%s' must have a number value", filter.Op, filter.Path)
+		}
+		return numeric + " " + string(filter.Op) + " " + where.param(value) + "::NUMERIC", nil
+	case DocBetween:
+		if len(filter.Values) != 2 {
+			return "", fmt.Errorf("doc filter 'between' on '%s' must have 2 values", filter.Path)
+		}
+		low, lowOk := filter.Values[0].(float64)
+		high, highOk := filter.Values[1].(float64)
+		if !lowOk || !highOk {
+			return "", fmt.Errorf("doc filter 'between' on '%s' must have number values", filter.Path)
+		}
+		return numeric + " BETWEEN " + where.param(low) + "::NUMERIC AND " + where.param(high) + "::NUMERIC", nil
+	case DocIn:
+		if len(filter.Values) == 0 {
+			return "", fmt.Errorf("doc filter 'in' on '%s' must have at least 1 value", filter.Path)
+		}
+		values, err := json.Marshal(filter.Values)
+		if err != nil {
+			return "", err
+		}
+		// A missing path would be built into [null], which must not match a null value
+		return "(DOC #> " + path + " IS NOT NULL AND " + where.param(string(values)) +
+			"::JSONB @> JSONB_BUILD_ARRAY(DOC #> " + path + "))", nil
+	case DocRegex:
+		pattern, ok := filter.Value.(string)
+		if !ok {
+			return "", fmt.Errorf("doc filter 'regex' on '%s' must have a string value", filter.Path)
+		}
+		return "(JSONB_TYPEOF(DOC #> " + path + ") = 'string' AND DOC #>> " + path + " ~* " + where.param(pattern) + ")",
+			nil
+	case DocContains:
+		contained := filter.Value
+		if _, isArray := contained.([]any); !isArray {
+			contained = []any{contained}
 		}
-		docQuery += ")"
-		wheres = append(wheres, docQuery)
+		value, err := json.Marshal(contained)
+		if err != nil {
+			return "", err
+		}
+		return "DOC #> " + path + " @> " + where.param(string(value)) + "::JSONB", nil
+	default:
+		return "", fmt.Errorf(
+			"invalid doc filter operator: '%s', must be one of %s",
+			filter.Op,
+			[]DocOperator{
+				DocExists, DocMissing, DocEqual, DocNotEqual, DocLessThan, DocLessThanOrEqual, DocGreaterThan,
+				DocGreaterThanOrEqual, DocBetween, DocIn, DocRegex, DocContains,
+			},
+		)
 	}
+}
+
+// testSortColumns are the oar_tests columns that a TestQuery can be sorted by
+var testSortColumns = map[string]string{
+	"id":         "ID",
+	"created":    "CREATED",
+	"modified":   "MODIFIED",
+	"summary":    "SUMMARY",
+	"outcome":    "OUTCOME",
+	"analysis":   "ANALYSIS",
+	"resolution": "RESOLUTION",
+}
+
+// parseDocPath will split a JSON path into the Doc, like "doc.latency.p50", into its keys. Returns false if the path
+// is not a path into the Doc.
+func parseDocPath(path string) ([]string, bool) {
+	keys := strings.Split(path, ".")
+	if len(keys) < 2 || strings.ToLower(keys[0]) != "doc" {
+		return nil, false
+	}
+	for _, key := range keys[1:] {
+		if key == "" {
+			return nil, false
+		}
+	}
+	return keys[1:], true
+}
 
-	for i, where := range wheres {
-		where = strings.Replace(where, "$", "$"+strconv.Itoa(i+1), 1) // formats string replacement params
-		if i == 0 {
-			SQL += " " + "WHERE" + " " + where
+// buildTestQueryOrderBy will convert TestQuery sort keys into a SQL ORDER BY list. Only allowlisted columns can be
+// sorted on, and paths into the Doc are passed as parameters, so nothing from the sort keys is put into the SQL
+// directly. Tests that are missing a Doc path are always sorted last. ID is always added as the last sort key so that
+// the order is stable.
+func buildTestQueryOrderBy(sorts []TestSort, where *sqlWhere) (string, error) {
+	var orderBys []string
+	for _, sort := range sorts {
+		var direction string
+		switch strings.ToLower(sort.Direction) {
+		case "", "asc":
+			direction = "ASC"
+		case "desc":
+			direction = "DESC"
+		default:
+			return "", fmt.Errorf("invalid sort direction: '%s', must be 'asc' or 'desc'", sort.Direction)
+		}
+
+		if column, ok := testSortColumns[strings.ToLower(sort.Key)]; ok {
+			orderBys = append(orderBys, column+" "+direction)
+		} else if docPath, ok := parseDocPath(sort.Key); ok {
+			orderBys = append(orderBys, "DOC #> "+where.param(docPath)+" "+direction+" NULLS LAST")
 		} else {
-			SQL += " " + "AND" + " " + where
+			sortKeys := maps.Keys(testSortColumns)
+			slices.Sort(sortKeys)
+			return "", fmt.Errorf(
+				"invalid sort key: '%s', must be one of %s or a path into the doc, like 'doc.duration'",
+				sort.Key,
+				sortKeys,
+			)
 		}
 	}
 
-	// Orders by the most recently modified tests being first
-	SQL += " " + "ORDER BY CREATED DESC"
+	return strings.Join(append(orderBys, "ID DESC"), ", "), nil
+}
+
+// QueryTest will take a DB connection pool to the OAR DB, parse the query, apply the limit and offset and return
+// the query response. If a cursor from a previous response is passed, the query will continue after the last test of
+// that response.
+// See GetTests for more info
+func QueryTest(
+	dbPool *pgx.ConnPool,
+	query *TestQuery,
+	limit int,
+	offset int,
+	cursor *TestCursor,
+) (*TestQueryResponse, error) {
+	where, err := buildTestQueryWhere(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cursors are positions in the default order, they cannot be used with a custom sort or a ranked search
+	customSort := query != nil && len(query.Sort) > 0
+	rankedSearch := query != nil && query.Search != "" && !customSort
+	if cursor != nil && (customSort || rankedSearch) {
+		return nil, errors.New("a cursor cannot be used with a custom sort or a search, use an offset instead")
+	}
+
+	// The total is counted before the cursor is applied, so it is the same for every page
+	total, totalEstimated, err := CountTests(dbPool, where)
+	if err != nil {
+		return nil, err
+	}
+
+	if cursor != nil {
+		where.and("(CREATED, ID) < (" + where.param(cursor.Created) + ", " + where.param(cursor.ID) + ")")
+	}
+
+	SQL := "SELECT * FROM OAR_TESTS" + where.String()
+
+	// By default, orders by the most recently created tests being first, ID breaks ties so that cursors are stable
+	orderBy := "CREATED DESC, ID DESC"
+	if customSort {
+		orderBy, err = buildTestQueryOrderBy(query.Sort, where)
+		if err != nil {
+			return nil, err
+		}
+	} else if rankedSearch {
+		// Without a custom sort, searches are ordered by how well the tests match
+		orderBy = "TS_RANK(" + testSearchVector + ", WEBSEARCH_TO_TSQUERY('english', " + where.param(query.Search) +
+			")) DESC, ID DESC"
+	}
+	SQL += " " + "ORDER BY " + orderBy
 
 	// Add offset and limit
 	SQL += " " + "OFFSET " + strconv.Itoa(offset) + " " + "LIMIT" + " " + strconv.Itoa(limit)
 
-	tests, err := SelectTests(dbPool, SQL, params...)
+	tests, err := SelectTests(dbPool, SQL, where.params...)
 	if err != nil {
 		return nil, err
 	}
@@ -102,6 +473,468 @@ func QueryTest(dbPool *pgx.ConnPool, query *TestQuery, limit int, offset int) (*
 		tests = []*Test{}
 	}
 
-	response := &TestQueryResponse{Count: uint64(len(tests)), Tests: tests}
+	response := &TestQueryResponse{
+		Count:          uint64(len(tests)),
+		Total:          total,
+		TotalEstimated: totalEstimated,
+		Tests:          tests,
+	}
+
+	// A full page means there could be more results after it
+	if !customSort && !rankedSearch && limit > 0 && len(tests) == limit {
+		lastTest := tests[len(tests)-1]
+		response.NextCursor, err = encodeToBase64(TestCursor{Created: lastTest.Created, ID: lastTest.ID})
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return response, nil
 }
+
+// testStatsColumns are the oar_tests columns that tests can be grouped by for stats
+var testStatsColumns = []string{"summary", "outcome", "analysis", "resolution"}
+
+// testStatsTimeColumns are the oar_tests timestamp columns that tests can be grouped into time buckets by
+var testStatsTimeColumns = []string{"created", "modified"}
+
+// testStatsIntervals are the sizes of the time buckets that tests can be grouped into
+var testStatsIntervals = []string{"hour", "day", "week", "month", "year"}
+
+// buildTestStatsGroupBy will convert group by dimensions into SQL expressions over the oar_tests table. Each expression
+// is a JSONB value, so that dimensions of any type can be put into the key of a group. A dimension can be any of the
+// testStatsColumns, a path into the Doc, like "doc.env", or a time bucket of a timestamp column, like "created:day".
+// Time buckets are the start of the bucket in UTC, weeks start on Monday. Paths into the Doc are passed as parameters.
+func buildTestStatsGroupBy(groupBy []string, where *sqlWhere) ([]string, error) {
+	expressions := make([]string, 0, len(groupBy))
+	for i, dimension := range groupBy {
+		if slices.Contains(groupBy[:i], dimension) {
+			return nil, fmt.Errorf("cannot group by '%s' more than once", dimension)
+		}
+
+		column, interval, isTimeBucket := strings.Cut(strings.ToLower(dimension), ":")
+		switch {
+		case isTimeBucket:
+			if !slices.Contains(testStatsTimeColumns, column) || !slices.Contains(testStatsIntervals, interval) {
+				return nil, fmt.Errorf(
+					"invalid time bucket: '%s', must be one of %s, then ':' and one of %s, like 'created:day'",
+					dimension,
+					strings.Join(testStatsTimeColumns, ", "),
+					strings.Join(testStatsIntervals, ", "),
+				)
+			}
+			expressions = append(
+				expressions,
+				"TO_JSONB(TO_CHAR(DATE_TRUNC('"+interval+"', "+strings.ToUpper(column)+
+					"), 'YYYY-MM-DD\"T\"HH24:MI:SS\"Z\"'))",
+			)
+		case slices.Contains(testStatsColumns, column):
+			expressions = append(expressions, "TO_JSONB("+strings.ToUpper(column)+")")
+		default:
+			docPath, ok := parseDocPath(dimension)
+			if !ok {
+				return nil, fmt.Errorf(
+					"invalid group by: '%s', must be one of %s, a path into the doc, like 'doc.env', or a time "+
+						"bucket, like 'created:day'",
+					dimension,
+					strings.Join(testStatsColumns, ", "),
+				)
+			}
+			expressions = append(expressions, "DOC #> "+where.param(docPath))
+		}
+	}
+
+	return expressions, nil
+}
+
+// buildTestStatsSQL will return the SQL that groups the tests that match a WHERE clause by the group by dimensions,
+// see buildTestStatsGroupBy. Each row is a group: the key of the group, an object of each dimension and its value in
+// the group, then each aggregate, then the total amount of tests across all groups. Groups are ordered by their key,
+// up to the limit.
+func buildTestStatsSQL(groupBy []string, aggregates []string, where *sqlWhere, limit int) (string, error) {
+	expressions, err := buildTestStatsGroupBy(groupBy, where)
+	if err != nil {
+		return "", err
+	}
+
+	SQL := "SELECT " + buildTestStatsKey(groupBy, expressions, where) + ", " + strings.Join(aggregates, ", ") +
+		", (SUM(COUNT(*)) OVER ())::BIGINT FROM OAR_TESTS" + where.String()
+	if len(expressions) > 0 {
+		SQL += " GROUP BY " + strings.Join(expressions, ", ") + " ORDER BY " + strings.Join(expressions, ", ")
+	}
+	return SQL + " LIMIT " + strconv.Itoa(limit), nil
+}
+
+// buildTestStatsKey will return the SQL of a JSONB object of each group by dimension and its expression, see
+// buildTestStatsGroupBy. The dimensions are passed as parameters.
+func buildTestStatsKey(groupBy []string, expressions []string, where *sqlWhere) string {
+	keyPairs := make([]string, len(expressions))
+	for i, expression := range expressions {
+		keyPairs[i] = where.param(groupBy[i]) + "::TEXT, " + expression
+	}
+	return "JSONB_BUILD_OBJECT(" + strings.Join(keyPairs, ", ") + ")"
+}
+
+// countOutcome will return the SQL aggregate that counts the tests of a group with an outcome
+func countOutcome(outcome Outcome) string {
+	return "(COUNT(*) FILTER (WHERE OUTCOME = '" + string(outcome) + "'))"
+}
+
+// countAnalysis will return the SQL aggregate that counts the tests of a group with an analysis
+func countAnalysis(analysis Analysis) string {
+	return "(COUNT(*) FILTER (WHERE ANALYSIS = '" + string(analysis) + "'))"
+}
+
+// QueryTestStats will take a DB connection pool to the OAR DB, parse the query and group the matching tests by the
+// group by dimensions, see buildTestStatsGroupBy. The counts and ratios of each group are computed by postgres, so
+// only the groups are returned, not the tests. Groups are ordered by their key, up to the limit.
+// See GetTestStats for more info
+func QueryTestStats(dbPool *pgx.ConnPool, query *TestQuery, groupBy []string, limit int) (*TestStatsResponse, error) {
+	where, err := buildTestQueryWhere(query)
+	if err != nil {
+		return nil, err
+	}
+
+	SQL, err := buildTestStatsSQL(groupBy, []string{
+		"COUNT(*)",
+		"COALESCE(COUNT(*) / NULLIF(SUM(COUNT(*)) OVER (), 0)::FLOAT8, 0)",
+		countOutcome(Passed),
+		countOutcome(Failed),
+		"COALESCE(" + countOutcome(Failed) + "::FLOAT8 / NULLIF(COUNT(*), 0), 0)",
+	}, where, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, total, err := SelectTestStats(dbPool, SQL, where.params...)
+	if err != nil {
+		return nil, err
+	}
+
+	if groups == nil {
+		groups = []*TestStats{}
+	}
+	if groupBy == nil {
+		groupBy = []string{}
+	}
+
+	return &TestStatsResponse{Total: total, GroupBy: groupBy, Count: len(groups), Groups: groups}, nil
+}
+
+// QueryTestQuality will take a DB connection pool to the OAR DB, parse the query and compute the quality metrics of
+// the matching tests for each group of the group by dimensions, the same way as QueryTestStats. The analyses of each
+// group are counted by postgres, see TestQuality for the metrics computed from them. It will also find the noisiest
+// tests that match the query, up to noisiest of them, see SelectNoisyTests.
+// See GetTestQuality for more info
+func QueryTestQuality(
+	dbPool *pgx.ConnPool,
+	query *TestQuery,
+	groupBy []string,
+	limit int,
+	noisiest int,
+) (*TestQualityResponse, error) {
+	where, err := buildTestQueryWhere(query)
+	if err != nil {
+		return nil, err
+	}
+	noisyWhere := where.clone()
+
+	SQL, err := buildTestStatsSQL(groupBy, []string{
+		"COUNT(*)",
+		countAnalysis(TruePositive),
+		countAnalysis(FalsePositive),
+		countAnalysis(TrueNegative),
+		countAnalysis(FalseNegative),
+		countAnalysis(NotAnalyzed),
+	}, where, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, total, err := SelectTestQuality(dbPool, SQL, where.params...)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		group.ComputeMetrics()
+	}
+
+	noisyTests, err := SelectNoisyTests(dbPool, noisyWhere, noisiest)
+	if err != nil {
+		return nil, err
+	}
+
+	if groups == nil {
+		groups = []*TestQuality{}
+	}
+	if noisyTests == nil {
+		noisyTests = []*NoisyTest{}
+	}
+	if groupBy == nil {
+		groupBy = []string{}
+	}
+
+	return &TestQualityResponse{
+		Total:    total,
+		GroupBy:  groupBy,
+		Count:    len(groups),
+		Groups:   groups,
+		Noisiest: noisyTests,
+	}, nil
+}
+
+// buildFlakyTestsSQL will return the SQL that finds the most recent results of each test identity that matches a
+// WHERE clause, see FlakinessOptions, and counts their failures and flips. Flips are counted between consecutive
+// results in the window, in the order they were created. Only tests with at least 1 flip are returned.
+func buildFlakyTestsSQL(options *FlakinessOptions, where *sqlWhere) (string, error) {
+	for _, dimension := range options.Identity {
+		if _, ok := parseDocPath(dimension); !ok {
+			return "", fmt.Errorf("invalid identity: '%s', must be a path into the doc, like 'doc.package'", dimension)
+		}
+	}
+	identity := append([]string{"summary"}, options.Identity...)
+	expressions, err := buildTestStatsGroupBy(identity, where)
+	if err != nil {
+		return "", err
+	}
+
+	if options.Days > 0 {
+		days := where.param(options.Days)
+		where.and("CREATED > (NOW() AT TIME ZONE 'UTC') - MAKE_INTERVAL(DAYS => " + days + "::INT)")
+	}
+
+	// The results of each identity are numbered from newest to oldest, to keep the most recent Runs of them
+	results := "SELECT ID, OUTCOME, CREATED, " + buildTestStatsKey(identity, expressions, where) + " AS IDENTITY " +
+		"FROM OAR_TESTS" + where.String()
+	recent := "SELECT *, ROW_NUMBER() OVER (PARTITION BY IDENTITY ORDER BY CREATED DESC, ID DESC) AS RECENCY " +
+		"FROM RESULTS"
+	windowed := "SELECT *, LAG(OUTCOME) OVER (PARTITION BY IDENTITY ORDER BY CREATED, ID) AS PREVIOUS_OUTCOME " +
+		"FROM RECENT WHERE RECENCY <= " + where.param(options.Runs)
+
+	flips := "(COUNT(*) FILTER (WHERE OUTCOME <> PREVIOUS_OUTCOME))"
+	SQL := "WITH RESULTS AS (" + results + "), RECENT AS (" + recent + "), WINDOWED AS (" + windowed + ") " +
+		"SELECT IDENTITY, COUNT(*), " + countOutcome(Failed) + ", " + flips + ", " +
+		"(ARRAY_AGG(OUTCOME ORDER BY CREATED DESC, ID DESC))[1], MIN(CREATED), MAX(CREATED), " +
+		"ARRAY_AGG(ID ORDER BY CREATED, ID) " +
+		"FROM WINDOWED GROUP BY IDENTITY HAVING COUNT(*) >= " + where.param(options.MinRuns) + " AND " + flips + " > 0"
+	return SQL, nil
+}
+
+// QueryFlakyTests will take a DB connection pool to the OAR DB, parse the query and find the flaky tests among the
+// matching tests, see FlakinessOptions and FlakyTest. The results are counted by postgres and the flakiness is scored
+// here, flakiest first.
+// See GetFlakyTests for more info
+func QueryFlakyTests(dbPool *pgx.ConnPool, query *TestQuery, options *FlakinessOptions) ([]*FlakyTest, error) {
+	where, err := buildTestQueryWhere(query)
+	if err != nil {
+		return nil, err
+	}
+
+	SQL, err := buildFlakyTestsSQL(options, where)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := SelectFlakyTests(dbPool, SQL, where.params...)
+	if err != nil {
+		return nil, err
+	}
+
+	flakyTests := make([]*FlakyTest, 0, len(candidates))
+	for _, candidate := range candidates {
+		candidate.ComputeScore()
+		if candidate.Score >= options.Threshold {
+			flakyTests = append(flakyTests, candidate)
+		}
+	}
+
+	slices.SortStableFunc(flakyTests, func(a *FlakyTest, b *FlakyTest) bool {
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.LastRun.After(b.LastRun)
+	})
+	if len(flakyTests) > options.Limit {
+		flakyTests = flakyTests[:options.Limit]
+	}
+
+	return flakyTests, nil
+}
+
+// MarkFlakyTests will add a "flaky": true marker to the Doc of every result of the flaky tests, so that they can be
+// shown and queried as flaky. Results that are already marked are not changed. Will return the amount of results that
+// were marked. See PatchTests for the definitionFields.
+func MarkFlakyTests(
+	dbPool *pgx.ConnPool,
+	audit *Audit,
+	flakyTests []*FlakyTest,
+	definitionFields []string,
+) (uint64, error) {
+	var testIDs []uint64
+	for _, flakyTest := range flakyTests {
+		testIDs = append(testIDs, flakyTest.TestIDs...)
+	}
+	if len(testIDs) == 0 {
+		return 0, nil
+	}
+
+	where, err := buildTestQueryWhere(&TestQuery{IDs: testIDs})
+	if err != nil {
+		return 0, err
+	}
+
+	patchResponse, err := PatchTests(
+		dbPool,
+		audit,
+		where,
+		JSONMergePatcher(map[string]any{"flaky": true}),
+		definitionFields,
+		false,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return patchResponse.Count, nil
+}
+
+// QueryTestDefinitions will take a DB connection pool to the OAR DB and return the test definitions whose summary
+// matches any of the summaries, or every test definition if there are none, most recently seen first. Summaries are
+// case-insensitive regular expressions, the same as TestQuery.Summaries.
+// See GetTestDefinitions for more info
+func QueryTestDefinitions(
+	dbPool *pgx.ConnPool,
+	summaries []string,
+	limit int,
+	offset int,
+) (*TestDefinitionsResponse, error) {
+	where := &sqlWhere{}
+	if len(summaries) > 0 {
+		where.and("D.SUMMARY ~* ANY(" + where.param(summaries) + ")")
+	}
+
+	SQL := "SELECT " + testDefinitionColumns + " FROM OAR_TEST_DEFINITIONS D" + where.String() +
+		" ORDER BY D.LAST_SEEN DESC, D.ID DESC OFFSET " + strconv.Itoa(offset) + " LIMIT " + strconv.Itoa(limit)
+	definitions, err := SelectTestDefinitions(dbPool, SQL, where.params...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TestDefinitionsResponse{Count: len(definitions), Definitions: definitions}, nil
+}
+
+// QueryTestDefinition will return the test definition with an ID, or nil if there is none
+func QueryTestDefinition(dbPool *pgx.ConnPool, definitionID uint64) (*TestDefinition, error) {
+	definitions, err := SelectTestDefinitions(
+		dbPool,
+		"SELECT "+testDefinitionColumns+" FROM OAR_TEST_DEFINITIONS D WHERE D.ID = $1",
+		definitionID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(definitions) == 0 {
+		return nil, nil
+	}
+
+	return definitions[0], nil
+}
+
+// QueryRuns will take a DB connection pool to the OAR DB and return the summaries of the runs that match all of the
+// non-empty branch, commit and environment, most recently started first.
+// See GetRuns for more info
+func QueryRuns(
+	dbPool *pgx.ConnPool,
+	branch string,
+	commit string,
+	environment string,
+	limit int,
+	offset int,
+) (*RunsResponse, error) {
+	where := &sqlWhere{}
+	if branch != "" {
+		where.and("R.BRANCH = " + where.param(branch))
+	}
+	if commit != "" {
+		where.and("R.COMMIT = " + where.param(commit))
+	}
+	if environment != "" {
+		where.and("R.ENVIRONMENT = " + where.param(environment))
+	}
+
+	SQL := "SELECT " + runSummaryColumns + " FROM OAR_RUNS R " + runTotals + where.String() +
+		" ORDER BY R.STARTED DESC, R.ID DESC OFFSET " + strconv.Itoa(offset) + " LIMIT " + strconv.Itoa(limit)
+	runs, err := SelectRunSummaries(dbPool, SQL, where.params...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RunsResponse{Count: len(runs), Runs: runs}, nil
+}
+
+// QueryRun will return the summary of the run with an ID, or nil if there is none
+func QueryRun(dbPool *pgx.ConnPool, runID uint64) (*RunSummary, error) {
+	runs, err := SelectRunSummaries(
+		dbPool,
+		"SELECT "+runSummaryColumns+" FROM OAR_RUNS R "+runTotals+" WHERE R.ID = $1",
+		runID,
+	)
+	if err != nil || len(runs) == 0 {
+		return nil, err
+	}
+
+	return runs[0], nil
+}
+
+// CompareRuns will compare the results of the head run with the results of the base run, by test definition. Will
+// return nil if either run does not exist.
+// See RunComparison.Compare for more info
+func CompareRuns(dbPool *pgx.ConnPool, baseRunID uint64, headRunID uint64) (*RunComparison, error) {
+	comparison := &RunComparison{}
+	var err error
+
+	if comparison.Base, err = QueryRun(dbPool, baseRunID); err != nil || comparison.Base == nil {
+		return nil, err
+	}
+	if comparison.Head, err = QueryRun(dbPool, headRunID); err != nil || comparison.Head == nil {
+		return nil, err
+	}
+
+	baseTests, err := SelectRunTests(dbPool, baseRunID)
+	if err != nil {
+		return nil, err
+	}
+	headTests, err := SelectRunTests(dbPool, headRunID)
+	if err != nil {
+		return nil, err
+	}
+
+	comparison.Compare(baseTests, headTests)
+	return comparison, nil
+}
+
+// RunTestPurger will permanently delete tests that have been soft deleted for longer than the retention, once every
+// interval, until the context is done. Errors are logged, so that a failed purge is retried on the next interval. A
+// retention or interval of 0 disables purging.
+func RunTestPurger(ctx context.Context, pgPool *pgx.ConnPool, retention time.Duration, interval time.Duration) {
+	if retention <= 0 || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := PurgeDeletedTests(pgPool, retention)
+		if err != nil {
+			log.Println("error purging deleted tests:", err)
+		} else if purged > 0 {
+			log.Printf("purged %d tests that were deleted more than %s ago", purged, retention)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
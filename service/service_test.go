@@ -0,0 +1,341 @@
+package main
+
+import (
+	"github.com/magiconair/properties/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseDocPath will ensure that only valid paths into the Doc are parsed
+func TestParseDocPath(t *testing.T) {
+	docPath, ok := parseDocPath("doc.latency (ms).p50")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, docPath, []string{"latency (ms)", "p50"})
+
+	for _, invalidPath := range []string{"doc", "summary", "doc..p50", "docs.p50", ""} {
+		t.Run(invalidPath, func(t *testing.T) {
+			if _, ok := parseDocPath(invalidPath); ok {
+				t.Errorf("invalid path %q was parsed", invalidPath)
+			}
+		})
+	}
+}
+
+// TestBuildTestQueryOrderBy will ensure that sort keys are allowlisted and that Doc paths are passed as parameters
+func TestBuildTestQueryOrderBy(t *testing.T) {
+	t.Run("valid sort keys", func(t *testing.T) {
+		where := &sqlWhere{}
+		where.param("existing parameter")
+
+		orderBy, err := buildTestQueryOrderBy([]TestSort{
+			{Key: "outcome"},
+			{Key: "Modified", Direction: "DESC"},
+			{Key: "doc.duration", Direction: "desc"},
+		}, where)
+		if err != nil {
+			t.Error(err)
+		}
+
+		assert.Equal(t, orderBy, "OUTCOME ASC, MODIFIED DESC, DOC #> $2 DESC NULLS LAST, ID DESC")
+		assert.Equal(t, where.params[1], []string{"duration"})
+	})
+
+	invalidSorts := map[string]TestSort{
+		"unknown column":    {Key: "password"},
+		"sql injection":     {Key: "created; DROP TABLE oar_tests"},
+		"invalid direction": {Key: "created", Direction: "sideways"},
+		"blank doc path":    {Key: "doc."},
+	}
+	for scenario, invalidSort := range invalidSorts {
+		t.Run(scenario, func(t *testing.T) {
+			if _, err := buildTestQueryOrderBy([]TestSort{invalidSort}, &sqlWhere{}); err == nil {
+				t.Error("invalid sort did not throw error")
+			}
+		})
+	}
+}
+
+// TestBuildTestQueryWhere will ensure that query values are passed as parameters instead of being put into the SQL
+func TestBuildTestQueryWhere(t *testing.T) {
+	t.Run("nil query only excludes deleted tests", func(t *testing.T) {
+		where, err := buildTestQueryWhere(nil)
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, where.String(), " WHERE DELETED IS NULL")
+	})
+
+	t.Run("include deleted has no conditions", func(t *testing.T) {
+		where, err := buildTestQueryWhere(&TestQuery{IncludeDeleted: true})
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, where.String(), "")
+	})
+
+	t.Run("filter query cannot include deleted", func(t *testing.T) {
+		_, err := buildTestQueryWhere(&TestQuery{Filter: &TestFilter{Query: &TestQuery{IncludeDeleted: true}}})
+		if err == nil {
+			t.Error("filter query with includeDeleted did not throw error")
+		}
+	})
+
+	t.Run("summaries and search are parameters", func(t *testing.T) {
+		maliciousSummary := "'; DROP TABLE oar_tests; --"
+		where, err := buildTestQueryWhere(&TestQuery{
+			IDs:       []uint64{1, 2},
+			Summaries: []string{maliciousSummary, "user's test"},
+			Search:    "login -timeout",
+		})
+		if err != nil {
+			t.Error(err)
+		}
+
+		assert.Equal(t, len(where.params), 3)
+		assert.Equal(t, where.params[1], []string{maliciousSummary, "user's test"})
+		assert.Equal(t, where.params[2], "login -timeout")
+		assert.Equal(t, strings.Contains(where.String(), "DROP TABLE"), false)
+		assert.Equal(t, strings.Contains(where.String(), "SUMMARY ~* ANY($2)"), true)
+	})
+}
+
+// TestBuildTestChangeCondition will ensure that a change filter checks the test history with parameters and that
+// invalid change fields are rejected
+func TestBuildTestChangeCondition(t *testing.T) {
+	after := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("analysis changed by an actor", func(t *testing.T) {
+		where := &sqlWhere{}
+		condition, err := buildTestChangeCondition(
+			TestChange{Field: "Analysis", Actor: "'; DROP TABLE oar_tests; --", After: &after},
+			where,
+		)
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, condition, "EXISTS (SELECT 1 FROM OAR_TEST_HISTORY H WHERE H.TEST_ID = OAR_TESTS.ID AND "+
+			"H.OPERATION = 'UPDATE' AND H.BEFORE->'analysis' IS DISTINCT FROM H.AFTER->'analysis' AND "+
+			"H.ACTOR = $1 AND H.TIMESTAMP > $2)")
+		assert.Equal(t, len(where.params), 2)
+	})
+
+	t.Run("doc path", func(t *testing.T) {
+		where := &sqlWhere{}
+		condition, err := buildTestChangeCondition(TestChange{Field: "doc.owner"}, where)
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, strings.Contains(condition, "H.BEFORE->'doc' #> $1 IS DISTINCT FROM H.AFTER->'doc' #> $1"), true)
+		assert.Equal(t, where.params[0], []string{"owner"})
+	})
+
+	t.Run("invalid field", func(t *testing.T) {
+		if _, err := buildTestChangeCondition(TestChange{Field: "modified"}, &sqlWhere{}); err == nil {
+			t.Error("invalid change field did not throw error")
+		}
+		if _, err := buildTestChangeCondition(TestChange{Field: "analysis'--"}, &sqlWhere{}); err == nil {
+			t.Error("invalid change field did not throw error")
+		}
+	})
+}
+
+// TestBuildDocFilterCondition will ensure that every doc filter operator builds a parameterized condition and that
+// invalid doc filters are rejected
+func TestBuildDocFilterCondition(t *testing.T) {
+	validFilters := map[string]struct {
+		filter    DocFilter
+		condition string
+		params    []any
+	}{
+		"exists": {
+			DocFilter{Path: "doc.env", Op: DocExists},
+			"DOC #> $1 IS NOT NULL",
+			[]any{[]string{"env"}},
+		},
+		"not equal": {
+			DocFilter{Path: "doc.env", Op: DocNotEqual, Value: "prod"},
+			"DOC #> $1 <> $2::JSONB",
+			[]any{[]string{"env"}, `"prod"`},
+		},
+		"greater than": {
+			DocFilter{Path: "doc.duration", Op: DocGreaterThan, Value: 30.0},
+			"(CASE WHEN JSONB_TYPEOF(DOC #> $1) = 'number' THEN (DOC #>> $1)::NUMERIC END) > $2::NUMERIC",
+			[]any{[]string{"duration"}, 30.0},
+		},
+		"in": {
+			DocFilter{Path: "doc.env", Op: DocIn, Values: []any{"staging", "prod"}},
+			"(DOC #> $1 IS NOT NULL AND $2::JSONB @> JSONB_BUILD_ARRAY(DOC #> $1))",
+			[]any{[]string{"env"}, `["staging","prod"]`},
+		},
+		"in with null does not match a missing path": {
+			DocFilter{Path: "doc.ticket", Op: DocIn, Values: []any{"OAR-1", nil}},
+			"(DOC #> $1 IS NOT NULL AND $2::JSONB @> JSONB_BUILD_ARRAY(DOC #> $1))",
+			[]any{[]string{"ticket"}, `["OAR-1",null]`},
+		},
+		"contains": {
+			DocFilter{Path: "doc.browsers", Op: DocContains, Value: "chrome"},
+			"DOC #> $1 @> $2::JSONB",
+			[]any{[]string{"browsers"}, `["chrome"]`},
+		},
+	}
+	for scenario, validFilter := range validFilters {
+		t.Run(scenario, func(t *testing.T) {
+			where := &sqlWhere{}
+			condition, err := buildDocFilterCondition(validFilter.filter, where)
+			if err != nil {
+				t.Error(err)
+			}
+			assert.Equal(t, condition, validFilter.condition)
+			assert.Equal(t, where.params, validFilter.params)
+		})
+	}
+
+	invalidFilters := map[string]DocFilter{
+		"path outside doc":     {Path: "summary", Op: DocExists},
+		"unknown operator":     {Path: "doc.env", Op: "like"},
+		"non-number compare":   {Path: "doc.duration", Op: DocLessThan, Value: "30"},
+		"between with 1 value": {Path: "doc.duration", Op: DocBetween, Values: []any{1.0}},
+		"empty in":             {Path: "doc.env", Op: DocIn},
+		"non-string regex":     {Path: "doc.env", Op: DocRegex, Value: 1.0},
+	}
+	for scenario, invalidFilter := range invalidFilters {
+		t.Run(scenario, func(t *testing.T) {
+			if _, err := buildDocFilterCondition(invalidFilter, &sqlWhere{}); err == nil {
+				t.Error("invalid doc filter did not throw error")
+			}
+		})
+	}
+}
+
+// TestBuildTestFilterCondition will ensure that filter trees are compiled into parameterized conditions with the
+// correct grouping, and that invalid trees are rejected
+func TestBuildTestFilterCondition(t *testing.T) {
+	t.Run("nested filter tree", func(t *testing.T) {
+		where := &sqlWhere{}
+		where.param("existing parameter")
+
+		condition, err := buildTestFilterCondition(&TestFilter{
+			Or: []*TestFilter{
+				{And: []*TestFilter{
+					{Query: &TestQuery{DocFilters: []DocFilter{{Path: "doc.env", Op: DocEqual, Value: "prod"}}}},
+					{Query: &TestQuery{Outcomes: []string{"Failed"}}},
+				}},
+				{Not: &TestFilter{Query: &TestQuery{Analyses: []string{"FalseNegative"}}}},
+			},
+		}, where)
+		if err != nil {
+			t.Error(err)
+		}
+
+		assert.Equal(
+			t,
+			condition,
+			"(((DOC #> $2 = $3::JSONB) AND (OUTCOME = ANY($4))) OR (NOT COALESCE((ANALYSIS = ANY($5)), FALSE)))",
+		)
+		assert.Equal(t, len(where.params), 5)
+	})
+
+	invalidFilters := map[string]*TestFilter{
+		"empty node":          {},
+		"multiple nodes":      {Not: &TestFilter{Query: &TestQuery{}}, Query: &TestQuery{}},
+		"empty and":           {And: []*TestFilter{}},
+		"sort in query":       {Query: &TestQuery{Sort: []TestSort{{Key: "id"}}}},
+		"invalid nested node": {Or: []*TestFilter{{Query: &TestQuery{}}, {}}},
+	}
+	for scenario, invalidFilter := range invalidFilters {
+		t.Run(scenario, func(t *testing.T) {
+			if _, err := buildTestFilterCondition(invalidFilter, &sqlWhere{}); err == nil {
+				t.Error("invalid filter did not throw error")
+			}
+		})
+	}
+}
+
+// TestBuildTestStatsGroupBy will ensure that group by dimensions are allowlisted and that Doc paths are passed as
+// parameters
+func TestBuildTestStatsGroupBy(t *testing.T) {
+	t.Run("valid dimensions", func(t *testing.T) {
+		where := &sqlWhere{}
+		where.param("existing parameter")
+
+		expressions, err := buildTestStatsGroupBy([]string{"Outcome", "doc.env", "created:week"}, where)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, expressions, []string{
+			"TO_JSONB(OUTCOME)",
+			"DOC #> $2",
+			`TO_JSONB(TO_CHAR(DATE_TRUNC('week', CREATED), 'YYYY-MM-DD"T"HH24:MI:SS"Z"'))`,
+		})
+		assert.Equal(t, where.params[1], []string{"env"})
+	})
+
+	t.Run("no dimensions", func(t *testing.T) {
+		expressions, err := buildTestStatsGroupBy(nil, &sqlWhere{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, len(expressions), 0)
+	})
+
+	invalidGroupBys := map[string][]string{
+		"unknown column":    {"password"},
+		"sql injection":     {"created:day'); DROP TABLE oar_tests; --"},
+		"unknown interval":  {"created:fortnight"},
+		"untimed column":    {"summary:day"},
+		"blank doc path":    {"doc."},
+		"grouped twice":     {"outcome", "outcome"},
+		"interval only":     {":day"},
+		"deleted timestamp": {"deleted:day"},
+	}
+	for scenario, invalidGroupBy := range invalidGroupBys {
+		t.Run(scenario, func(t *testing.T) {
+			if _, err := buildTestStatsGroupBy(invalidGroupBy, &sqlWhere{}); err == nil {
+				t.Error("invalid group by did not throw error")
+			}
+		})
+	}
+}
+
+// TestBuildFlakyTestsSQL will ensure that tests are identified by their summary and Doc paths only, and that the
+// options are passed as parameters
+func TestBuildFlakyTestsSQL(t *testing.T) {
+	t.Run("valid options", func(t *testing.T) {
+		where := &sqlWhere{}
+		options := &FlakinessOptions{Identity: []string{"doc.package"}, Runs: 20, Days: 7, MinRuns: 5}
+		SQL, err := buildFlakyTestsSQL(options, where)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, where.params, []any{[]string{"package"}, 7, "summary", "doc.package", 20, 5})
+		assert.Equal(t, strings.Contains(SQL, "MAKE_INTERVAL(DAYS => $2::INT)"), true)
+		assert.Equal(t, strings.Contains(SQL, "RECENCY <= $5"), true)
+		assert.Equal(t, strings.Contains(SQL, "COUNT(*) >= $6"), true)
+	})
+
+	t.Run("no days", func(t *testing.T) {
+		SQL, err := buildFlakyTestsSQL(&FlakinessOptions{Runs: 20, MinRuns: 5}, &sqlWhere{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, strings.Contains(SQL, "MAKE_INTERVAL"), false)
+	})
+
+	invalidIdentities := map[string][]string{
+		"column":           {"outcome"},
+		"time bucket":      {"created:day"},
+		"blank doc path":   {"doc."},
+		"identified twice": {"doc.package", "doc.package"},
+	}
+	for scenario, invalidIdentity := range invalidIdentities {
+		t.Run(scenario, func(t *testing.T) {
+			options := &FlakinessOptions{Identity: invalidIdentity, Runs: 20, MinRuns: 5}
+			if _, err := buildFlakyTestsSQL(options, &sqlWhere{}); err == nil {
+				t.Error("invalid identity did not throw error")
+			}
+		})
+	}
+}